@@ -0,0 +1,230 @@
+package blobstore
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// archive formats supported by /prefix/download
+const (
+	archiveFormatZip   = "zip"
+	archiveFormatTarGz = "tar.gz"
+)
+
+// errSizeLimit stops a listing once the objects no longer fit the archive size limit
+var errSizeLimit = fmt.Errorf("size limit exceeded")
+
+// archiveEntry is an object packaged into an archive.
+type archiveEntry struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// archiveWriter writes the entries of a zip or tar.gz archive to an underlying writer.
+type archiveWriter interface {
+	add(name string, size int64, modTime time.Time, r io.Reader) error
+	Close() error
+}
+
+func newArchiveWriter(format string, w io.Writer) (archiveWriter, error) {
+	switch format {
+	case archiveFormatZip:
+		return &zipArchiveWriter{zw: zip.NewWriter(w)}, nil
+	case archiveFormatTarGz:
+		gw := gzip.NewWriter(w)
+		return &tarGzArchiveWriter{gw: gw, tw: tar.NewWriter(gw)}, nil
+	}
+	return nil, fmt.Errorf("unsupported archive format `%s`, expected %s or %s", format, archiveFormatZip, archiveFormatTarGz)
+}
+
+func archiveContentType(format string) string {
+	if format == archiveFormatTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchiveWriter) add(name string, size int64, modTime time.Time, r io.Reader) error {
+	w, err := a.zw.CreateHeader(&zip.FileHeader{
+		Name:               name,
+		Method:             zip.Deflate,
+		Modified:           modTime,
+		UncompressedSize64: uint64(size),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.zw.Close()
+}
+
+type tarGzArchiveWriter struct {
+	gw *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a *tarGzArchiveWriter) add(name string, size int64, modTime time.Time, r io.Reader) error {
+	err := a.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Size:     size,
+		Mode:     int64(0644),
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(a.tw, r)
+	return err
+}
+
+func (a *tarGzArchiveWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gw.Close()
+}
+
+// listArchiveEntries returns the objects under prefix the caller may read, failing once their
+// total size exceeds limit bytes. Directory markers are left out since archives create folders
+// from the entry names.
+func (s3Ctrl *S3Controller) listArchiveEntries(bucket, prefix string, permissions []string, fullAccess bool, limit uint64) ([]archiveEntry, uint64, error) {
+	var entries []archiveEntry
+	var totalSize uint64
+	err := s3Ctrl.GetListWithCallBack(bucket, prefix, false, func(page *s3.ListObjectsV2Output) error {
+		for _, item := range page.Contents {
+			key := aws.StringValue(item.Key)
+			if strings.HasSuffix(key, "/") {
+				continue
+			}
+			if !fullAccess && !IsPermittedPrefix(bucket, key, permissions) {
+				continue
+			}
+			size := aws.Int64Value(item.Size)
+			totalSize += uint64(size)
+			if totalSize > limit {
+				return errSizeLimit
+			}
+			entries = append(entries, archiveEntry{Key: key, Size: size, LastModified: aws.TimeValue(item.LastModified)})
+		}
+		return nil
+	})
+	return entries, totalSize, err
+}
+
+// writeArchive streams the objects in entries into aw, naming them relative to prefix.
+func (s3Ctrl *S3Controller) writeArchive(aw archiveWriter, bucket, prefix string, entries []archiveEntry) error {
+	for _, entry := range entries {
+		output, err := s3Ctrl.Store.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(entry.Key),
+		})
+		if err != nil {
+			return fmt.Errorf("error getting object %s: %s", entry.Key, err.Error())
+		}
+		err = aw.add(strings.TrimPrefix(entry.Key, prefix), entry.Size, entry.LastModified, output.Body)
+		output.Body.Close()
+		if err != nil {
+			return fmt.Errorf("error adding %s to the archive: %s", entry.Key, err.Error())
+		}
+	}
+	return aw.Close()
+}
+
+// archiveParams reads the prefix, ending it with a slash, and the archive format of the request.
+func archiveParams(c echo.Context) (string, string, error) {
+	prefix := c.QueryParam("prefix")
+	if prefix == "" {
+		return "", "", fmt.Errorf("request must include a `prefix` parameter")
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+	format := c.QueryParam("format")
+	if format == "" {
+		format = archiveFormatZip
+	}
+	if format != archiveFormatZip && format != archiveFormatTarGz {
+		return "", "", fmt.Errorf("`format` must be %s or %s", archiveFormatZip, archiveFormatTarGz)
+	}
+	return prefix, format, nil
+}
+
+// HandleGetPrefixArchive streams a zip or tar.gz of every object under a prefix the caller may read.
+// Nothing is buffered, so once the archive has started an error can only cut the transfer short.
+func (bh *BlobHandler) HandleGetPrefixArchive(c echo.Context) error {
+	prefix, format, err := archiveParams(c)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	bucket := c.QueryParam("bucket")
+	s3Ctrl, err := bh.GetController(bucket)
+	if err != nil {
+		errMsg := fmt.Errorf("`bucket` %s is not available, %s", bucket, err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	permissions, fullAccess, statusCode, err := bh.GetS3ReadPermissions(c, bucket)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(statusCode, err.Error())
+	}
+
+	limit := uint64(bh.Config.DefaultZipDownloadSizeLimit) * 1024 * 1024 * 1024
+	entries, _, err := s3Ctrl.listArchiveEntries(bucket, prefix, permissions, fullAccess, limit)
+	if err == errSizeLimit {
+		errMsg := fmt.Errorf("request entity is larger than %v GB, use /prefix/download/archive for larger prefixes", bh.Config.DefaultZipDownloadSizeLimit)
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusRequestEntityTooLarge, errMsg.Error())
+	}
+	if err != nil {
+		errMsg := fmt.Errorf("error listing objects: %s", err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusInternalServerError, errMsg.Error())
+	}
+	if len(entries) == 0 {
+		errMsg := fmt.Errorf("the specified prefix %s does not exist or holds no objects you may read", prefix)
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusNotFound, errMsg.Error())
+	}
+
+	filename := fmt.Sprintf("%s.%s", path.Base(strings.TrimSuffix(prefix, "/")), format)
+	aw, err := newArchiveWriter(format, c.Response())
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, err.Error())
+	}
+	c.Response().Header().Set(echo.HeaderContentType, archiveContentType(format))
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	c.Response().WriteHeader(http.StatusOK)
+
+	if err := s3Ctrl.writeArchive(aw, bucket, prefix, entries); err != nil {
+		// the status line is already sent, all that is left is to log the aborted transfer
+		log.Errorf("error streaming archive of prefix %s in bucket %s: %s", prefix, bucket, err.Error())
+		return nil
+	}
+	log.Infof("successfully streamed %d object(s) of prefix %s in bucket %s as %s", len(entries), prefix, bucket, format)
+	return nil
+}
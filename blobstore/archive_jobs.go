@@ -0,0 +1,388 @@
+package blobstore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dewberry/s3api/auth"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// states of an archive job
+const (
+	archiveJobPending   = "pending"
+	archiveJobRunning   = "running"
+	archiveJobCompleted = "completed"
+	archiveJobFailed    = "failed"
+)
+
+// maxArchiveWorkers is how many archives are packaged at the same time, further jobs stay pending
+const maxArchiveWorkers = 2
+
+// archiveJob is the progress of an archive packaged in the background, as reported by the status endpoint.
+type archiveJob struct {
+	ID          string     `json:"id"`
+	Bucket      string     `json:"bucket"`
+	Prefix      string     `json:"prefix"`
+	Format      string     `json:"format"`
+	Status      string     `json:"status"`
+	Objects     int        `json:"objects"`
+	ObjectsDone int        `json:"objects_done"`
+	Bytes       uint64     `json:"bytes"`
+	BytesDone   uint64     `json:"bytes_done"`
+	Key         string     `json:"key,omitempty"`
+	Reused      bool       `json:"reused"`
+	URL         string     `json:"url,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	// owner is the email of the user who started the job, empty when authorization is disabled
+	owner string
+}
+
+// archiveJobStore keeps the archive jobs in memory, they are lost on restart but the archives
+// they wrote stay in the temp prefix and are reused by the next job for the same prefix.
+type archiveJobStore struct {
+	mu      sync.Mutex
+	jobs    map[string]*archiveJob
+	workers chan struct{}
+	// building locks the archive keys jobs are writing, so two jobs never write the same archive at once
+	building map[string]*archiveKeyLock
+}
+
+// archiveKeyLock is held by the job writing an archive, waiters counts the jobs holding or waiting for it.
+type archiveKeyLock struct {
+	sync.Mutex
+	waiters int
+}
+
+func newArchiveJobStore() *archiveJobStore {
+	return &archiveJobStore{
+		jobs:     make(map[string]*archiveJob),
+		workers:  make(chan struct{}, maxArchiveWorkers),
+		building: make(map[string]*archiveKeyLock),
+	}
+}
+
+// add registers job and forgets the jobs that finished more than retention ago.
+func (s *archiveJobStore) add(job *archiveJob, retention time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, j := range s.jobs {
+		if j.FinishedAt != nil && time.Since(*j.FinishedAt) > retention {
+			delete(s.jobs, id)
+		}
+	}
+	s.jobs[job.ID] = job
+}
+
+// get returns a copy of the job with the given id if it was started by owner.
+func (s *archiveJobStore) get(id, owner string) (archiveJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok || job.owner != owner {
+		return archiveJob{}, false
+	}
+	return *job, true
+}
+
+// lockKey waits until no other job is writing the archive at key and returns the function releasing it.
+func (s *archiveJobStore) lockKey(key string) func() {
+	s.mu.Lock()
+	l, ok := s.building[key]
+	if !ok {
+		l = &archiveKeyLock{}
+		s.building[key] = l
+	}
+	l.waiters++
+	s.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		l.waiters--
+		if l.waiters == 0 {
+			delete(s.building, key)
+		}
+	}
+}
+
+func (s *archiveJobStore) update(id string, fn func(job *archiveJob)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+func (bh *BlobHandler) jobs() *archiveJobStore {
+	bh.Mu.Lock()
+	defer bh.Mu.Unlock()
+	if bh.archiveJobs == nil {
+		bh.archiveJobs = newArchiveJobStore()
+	}
+	return bh.archiveJobs
+}
+
+func newRandomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// requestOwner identifies the caller so a job is only reported to whoever started it.
+func requestOwner(c echo.Context) string {
+	if claims, ok := c.Get("claims").(*auth.Claims); ok {
+		return claims.Email
+	}
+	return ""
+}
+
+// archiveJobKey is where the archive of a job is written. Archives of users with full read access
+// hold every object of the prefix and are shared, the archives of limited readers only hold what
+// they may read and are written per job.
+func (bh *BlobHandler) archiveJobKey(job archiveJob, fullAccess bool) string {
+	name := strings.TrimSuffix(job.Prefix, "/")
+	if !fullAccess {
+		name = path.Join(job.ID, path.Base(name))
+	}
+	return fmt.Sprintf("%s/archives/%s.%s", bh.Config.DefaultTempPrefix, name, job.Format)
+}
+
+// trackedArchiveWriter reports the objects and bytes added to an archive as they are written.
+type trackedArchiveWriter struct {
+	archiveWriter
+	track func(objects int, bytes int64)
+}
+
+func (t *trackedArchiveWriter) add(name string, size int64, modTime time.Time, r io.Reader) error {
+	err := t.archiveWriter.add(name, size, modTime, &trackedReader{r: r, track: t.track})
+	if err == nil {
+		t.track(1, 0)
+	}
+	return err
+}
+
+type trackedReader struct {
+	r     io.Reader
+	track func(objects int, bytes int64)
+}
+
+func (t *trackedReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.track(0, int64(n))
+	}
+	return n, err
+}
+
+// cachedArchive reports whether the archive a previous job wrote to key is newer than every object in the prefix
+// and was written after notBefore.
+func (s3Ctrl *S3Controller) cachedArchive(bucket, prefix, key string, notBefore time.Time) (bool, error) {
+	output, err := s3Ctrl.Store.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+			return false, nil
+		}
+		return false, err
+	}
+	modTime := aws.TimeValue(output.LastModified)
+	if !modTime.After(notBefore) {
+		return false, nil
+	}
+	mostRecent, err := s3Ctrl.getMostRecentModTime(bucket, prefix)
+	if err != nil {
+		return false, err
+	}
+	return modTime.After(mostRecent), nil
+}
+
+// runArchiveJob packages the prefix of the job into the temp prefix once a worker is free. A job for
+// an archive another job is writing waits for it, and then reuses that archive if it is current.
+func (bh *BlobHandler) runArchiveJob(jobs *archiveJobStore, job archiveJob, s3Ctrl *S3Controller, permissions []string, fullAccess bool, urlExpiration time.Duration) {
+	key := bh.archiveJobKey(job, fullAccess)
+	unlock := jobs.lockKey(key)
+	defer unlock()
+	jobs.workers <- struct{}{}
+	defer func() { <-jobs.workers }()
+	jobs.update(job.ID, func(j *archiveJob) { j.Status = archiveJobRunning })
+
+	reused, err := bh.buildArchive(jobs, job, s3Ctrl, key, permissions, fullAccess, urlExpiration)
+	var url string
+	if err == nil {
+		url, err = s3Ctrl.GetDownloadPresignedURLWithOptions(job.Bucket, key, DownloadURLOptions{Expiration: urlExpiration})
+	}
+
+	finishedAt := time.Now()
+	jobs.update(job.ID, func(j *archiveJob) {
+		j.FinishedAt = &finishedAt
+		if err != nil {
+			j.Status = archiveJobFailed
+			j.Error = err.Error()
+			return
+		}
+		j.Status = archiveJobCompleted
+		j.Key = key
+		j.Reused = reused
+		j.URL = url
+	})
+	if err != nil {
+		log.Errorf("archive job %s for prefix %s in bucket %s failed: %s", job.ID, job.Prefix, job.Bucket, err.Error())
+		return
+	}
+	log.Infof("archive job %s for prefix %s in bucket %s completed, reused: %v", job.ID, job.Prefix, job.Bucket, reused)
+}
+
+// buildArchive writes the archive of the job to key, or leaves the archive already at key in place
+// when it is still current and the temp janitor won't remove it before a URL valid for urlExpiration
+// expires, which is reported by the returned bool.
+func (bh *BlobHandler) buildArchive(jobs *archiveJobStore, job archiveJob, s3Ctrl *S3Controller, key string, permissions []string, fullAccess bool, urlExpiration time.Duration) (bool, error) {
+	if fullAccess {
+		notBefore := time.Now().Add(urlExpiration - bh.Config.TempMaxAge)
+		current, err := s3Ctrl.cachedArchive(job.Bucket, job.Prefix, key, notBefore)
+		if err != nil {
+			log.Errorf("error checking the cached archive %s, it will be rebuilt: %s", key, err.Error())
+		}
+		if current {
+			return true, nil
+		}
+	}
+
+	limit := uint64(bh.Config.DefaultArchiveJobSizeLimit) * 1024 * 1024 * 1024
+	entries, totalSize, err := s3Ctrl.listArchiveEntries(job.Bucket, job.Prefix, permissions, fullAccess, limit)
+	if err == errSizeLimit {
+		return false, fmt.Errorf("prefix is larger than %v GB, use /prefix/download/script instead", bh.Config.DefaultArchiveJobSizeLimit)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error listing objects: %s", err.Error())
+	}
+	if len(entries) == 0 {
+		return false, fmt.Errorf("the specified prefix %s does not exist or holds no objects you may read", job.Prefix)
+	}
+	jobs.update(job.ID, func(j *archiveJob) {
+		j.Objects = len(entries)
+		j.Bytes = totalSize
+	})
+
+	pr, pw := io.Pipe()
+	aw, err := newArchiveWriter(job.Format, pw)
+	if err != nil {
+		return false, err
+	}
+	tracked := &trackedArchiveWriter{archiveWriter: aw, track: func(objects int, bytes int64) {
+		jobs.update(job.ID, func(j *archiveJob) {
+			j.ObjectsDone += objects
+			j.BytesDone += uint64(bytes)
+		})
+	}}
+	go func() {
+		pw.CloseWithError(s3Ctrl.writeArchive(tracked, job.Bucket, job.Prefix, entries))
+	}()
+	if err := s3Ctrl.UploadS3Obj(job.Bucket, key, pr); err != nil {
+		// unblock the archive writer if the upload stopped reading
+		pr.CloseWithError(err)
+		return false, fmt.Errorf("error writing archive %s: %s", key, err.Error())
+	}
+	return false, nil
+}
+
+// HandleCreateArchiveJob starts packaging a prefix into the temp prefix and returns the job right away,
+// its progress and finally the download URL are reported by HandleGetArchiveJob.
+func (bh *BlobHandler) HandleCreateArchiveJob(c echo.Context) error {
+	prefix, format, err := archiveParams(c)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	// the URL can't outlive the archive, which the temp janitor removes once it is TempMaxAge old
+	urlExpiration, maxExp := bh.downloadURLExpiration(c)
+	if urlExpiration > bh.Config.TempMaxAge {
+		urlExpiration = bh.Config.TempMaxAge
+	}
+	if value := c.QueryParam("expires_in"); value != "" {
+		urlExpiration, err = parseExpiresIn(value, maxExp)
+		if err != nil {
+			log.Error(err.Error())
+			return c.JSON(http.StatusUnprocessableEntity, err.Error())
+		}
+		if urlExpiration > bh.Config.TempMaxAge {
+			errMsg := fmt.Errorf("`expires_in` can't be longer than %s, the age at which archives are removed from the temp prefix", bh.Config.TempMaxAge)
+			log.Error(errMsg.Error())
+			return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+		}
+	}
+
+	bucket := c.QueryParam("bucket")
+	s3Ctrl, err := bh.GetController(bucket)
+	if err != nil {
+		errMsg := fmt.Errorf("`bucket` %s is not available, %s", bucket, err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	permissions, fullAccess, statusCode, err := bh.GetS3ReadPermissions(c, bucket)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(statusCode, err.Error())
+	}
+
+	id, err := newRandomID()
+	if err != nil {
+		errMsg := fmt.Errorf("error creating archive job id: %s", err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusInternalServerError, errMsg.Error())
+	}
+	job := archiveJob{
+		ID:        id,
+		Bucket:    bucket,
+		Prefix:    prefix,
+		Format:    format,
+		Status:    archiveJobPending,
+		CreatedAt: time.Now(),
+		owner:     requestOwner(c),
+	}
+	jobs := bh.jobs()
+	retention := time.Duration(bh.Config.DefaultDownloadPresignedUrlExpiration) * 24 * time.Hour
+	registered := job
+	jobs.add(&registered, retention)
+	go bh.runArchiveJob(jobs, job, s3Ctrl, permissions, fullAccess, urlExpiration)
+
+	log.Infof("started archive job %s for prefix %s in bucket %s", id, prefix, bucket)
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// HandleGetArchiveJob reports the progress of an archive job, and the presigned URL of the archive once it is completed.
+func (bh *BlobHandler) HandleGetArchiveJob(c echo.Context) error {
+	id := c.QueryParam("job_id")
+	if id == "" {
+		errMsg := fmt.Errorf("request must include a `job_id` parameter")
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	job, ok := bh.jobs().get(id, requestOwner(c))
+	if !ok {
+		errMsg := fmt.Errorf("archive job %s not found", id)
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusNotFound, errMsg.Error())
+	}
+	return c.JSON(http.StatusOK, job)
+}
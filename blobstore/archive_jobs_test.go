@@ -0,0 +1,104 @@
+package blobstore_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+)
+
+type archiveJobStatus struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Key    string `json:"key"`
+	URL    string `json:"url"`
+	Error  string `json:"error"`
+}
+
+// waitForArchiveJob polls the status of a job until it is finished.
+func waitForArchiveJob(t *testing.T, handler func(id string) archiveJobStatus, id string) archiveJobStatus {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		job := handler(id)
+		if job.Status == "completed" || job.Status == "failed" {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("archive job %s did not finish", id)
+	return archiveJobStatus{}
+}
+
+func TestArchiveJobs(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	for _, key := range []string{"project/a.txt", "project/sub/b.txt", "project/sub/c.txt"} {
+		srv.PutObject("bkt", key, bytes.Repeat([]byte(key), 1000))
+	}
+	status := func(id string) archiveJobStatus {
+		var job archiveJobStatus
+		decode(t, serve(t, bh.HandleGetArchiveJob, http.MethodGet, "/prefix/download/archive/status?job_id="+id, nil), http.StatusOK, &job)
+		return job
+	}
+
+	// jobs for the same archive don't write it at the same time
+	var started []archiveJobStatus
+	for i := 0; i < 3; i++ {
+		var job archiveJobStatus
+		decode(t, serve(t, bh.HandleCreateArchiveJob, http.MethodPost, "/prefix/download/archive?bucket=bkt&prefix=project&expires_in=2h", nil), http.StatusAccepted, &job)
+		started = append(started, job)
+	}
+	for _, job := range started {
+		job = waitForArchiveJob(t, status, job.ID)
+		if job.Status != "completed" {
+			t.Fatalf("job %s: %s %s", job.ID, job.Status, job.Error)
+		}
+		u, err := url.Parse(job.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := u.Query().Get("X-Amz-Expires"); got != "7200" {
+			t.Fatalf("got a URL valid for %s seconds, want 7200", got)
+		}
+		resp, data := fetch(t, http.MethodGet, job.URL, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET archive: %d", resp.StatusCode)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("job %s handed out an invalid archive: %s", job.ID, err.Error())
+		}
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		sort.Strings(names)
+		if len(names) != 3 || names[0] != "a.txt" || names[2] != "sub/c.txt" {
+			t.Fatalf("got entries %v", names)
+		}
+	}
+
+	decode(t, serve(t, bh.HandleCreateArchiveJob, http.MethodPost, "/prefix/download/archive?bucket=bkt", nil), http.StatusUnprocessableEntity, nil)
+	for _, expiresIn := range []string{"forever", "-1h", "720h"} {
+		rec := serve(t, bh.HandleCreateArchiveJob, http.MethodPost, "/prefix/download/archive?bucket=bkt&prefix=project&expires_in="+expiresIn, nil)
+		decode(t, rec, http.StatusUnprocessableEntity, nil)
+	}
+
+	// the URL never outlives the archive in the temp prefix
+	bh.Config.TempMaxAge = time.Hour
+	decode(t, serve(t, bh.HandleCreateArchiveJob, http.MethodPost, "/prefix/download/archive?bucket=bkt&prefix=project&expires_in=2h", nil), http.StatusUnprocessableEntity, nil)
+	var job archiveJobStatus
+	decode(t, serve(t, bh.HandleCreateArchiveJob, http.MethodPost, "/prefix/download/archive?bucket=bkt&prefix=project", nil), http.StatusAccepted, &job)
+	job = waitForArchiveJob(t, status, job.ID)
+	u, err := url.Parse(job.URL)
+	if err != nil || job.Status != "completed" {
+		t.Fatalf("job %s: %s %s", job.ID, job.Status, job.Error)
+	}
+	if got := u.Query().Get("X-Amz-Expires"); got != "3600" {
+		t.Fatalf("got a URL valid for %s seconds, want 3600", got)
+	}
+	decode(t, serve(t, bh.HandleGetArchiveJob, http.MethodGet, "/prefix/download/archive/status?job_id=unknown", nil), http.StatusNotFound, nil)
+}
@@ -0,0 +1,151 @@
+package blobstore_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// archiveFixture puts objects under data/ and returns their contents by archive entry name.
+func archiveFixture(put func(bucket, key string, data []byte)) map[string]string {
+	files := map[string]string{
+		"a.txt":          "first file",
+		"public/b.csv":   "x,y\n1,2\n",
+		"public/c/d.txt": "nested",
+	}
+	for name, content := range files {
+		put("bkt", "data/"+name, []byte(content))
+	}
+	put("bkt", "data/public/", nil)
+	put("bkt", "other/e.txt", []byte("outside the prefix"))
+	return files
+}
+
+func readZip(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func readTarGz(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	files := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(content)
+	}
+	return files
+}
+
+func TestPrefixArchive(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	want := archiveFixture(srv.PutObject)
+
+	rec := serve(t, bh.HandleGetPrefixArchive, http.MethodGet, "/prefix/download?bucket=bkt&prefix=data", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("zip: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="data.zip"` {
+		t.Fatalf("got Content-Disposition %s", got)
+	}
+	if got := readZip(t, rec.Body.Bytes()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("zip holds %v, want %v", got, want)
+	}
+
+	rec = serve(t, bh.HandleGetPrefixArchive, http.MethodGet, "/prefix/download?bucket=bkt&prefix=data/&format=tar.gz", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("tar.gz: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if got := readTarGz(t, rec.Body.Bytes()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("tar.gz holds %v, want %v", got, want)
+	}
+
+	for target, status := range map[string]int{
+		"/prefix/download?bucket=bkt":                          http.StatusUnprocessableEntity,
+		"/prefix/download?bucket=bkt&prefix=data&format=rar":   http.StatusUnprocessableEntity,
+		"/prefix/download?bucket=missing&prefix=data":          http.StatusUnprocessableEntity,
+		"/prefix/download?bucket=bkt&prefix=empty":             http.StatusNotFound,
+		"/prefix/download?bucket=bkt&prefix=data/public/c/d.t": http.StatusNotFound,
+	} {
+		decode(t, serve(t, bh.HandleGetPrefixArchive, http.MethodGet, target, nil), status, nil)
+	}
+}
+
+func TestPrefixArchiveSizeLimit(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	archiveFixture(srv.PutObject)
+
+	// the limit is set in GB, so a limit of 0 leaves no room for any object
+	bh.Config.DefaultZipDownloadSizeLimit = 0
+	rec := serve(t, bh.HandleGetPrefixArchive, http.MethodGet, "/prefix/download?bucket=bkt&prefix=data", nil)
+	decode(t, rec, http.StatusRequestEntityTooLarge, nil)
+
+	// directory markers take no room
+	rec = serve(t, bh.HandleGetPrefixArchive, http.MethodGet, "/prefix/download?bucket=bkt&prefix=empty", nil)
+	decode(t, rec, http.StatusNotFound, nil)
+}
+
+func TestPrefixArchivePermissions(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	archiveFixture(srv.PutObject)
+	bh.Config.AuthLevel = 1
+	bh.Config.LimitedReaderRoleName = "s3_limited_reader"
+	bh.DB = prefixDB{prefixes: []string{"/bkt/data/public/"}}
+	handler := withClaims(bh.HandleGetPrefixArchive, "reader@example.com", "s3_limited_reader")
+
+	rec := serve(t, handler, http.MethodGet, "/prefix/download?bucket=bkt&prefix=data", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rec.Code, rec.Body.String())
+	}
+	var names []string
+	for name := range readZip(t, rec.Body.Bytes()) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"public/b.csv", "public/c/d.txt"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got entries %v, want %v", names, want)
+	}
+
+	// prefixes without readable objects look the same as empty ones
+	bh.DB = prefixDB{prefixes: []string{"/bkt/other/"}}
+	decode(t, serve(t, handler, http.MethodGet, "/prefix/download?bucket=bkt&prefix=data", nil), http.StatusNotFound, nil)
+	bh.DB = prefixDB{}
+	decode(t, serve(t, handler, http.MethodGet, "/prefix/download?bucket=bkt&prefix=data", nil), http.StatusForbidden, nil)
+}
@@ -1,17 +1,20 @@
 package blobstore
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net/http"
 	"os"
-	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Dewberry/s3api/auth"
-	envcheck "github.com/Dewberry/s3api/env-checker"
+	"github.com/Dewberry/s3api/config"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/labstack/echo/v4"
@@ -19,8 +22,9 @@ import (
 )
 
 type S3Controller struct {
-	Sess    *session.Session
-	S3Svc   *s3.S3
+	// Name identifies the account the controller was created from, see AWSCreds.Name
+	Name    string
+	Store   ObjectStore
 	Buckets []string
 	S3Mock  bool
 }
@@ -30,6 +34,9 @@ type Config struct {
 	// Only settings that are typically environment-specific and can be loaded from
 	// external sources like configuration files, environment variables should go here.
 	AuthLevel                             int
+	AdminRoleName                         string
+	WriterRoleName                        string
+	ReaderRoleName                        string
 	LimitedWriterRoleName                 string
 	LimitedReaderRoleName                 string
 	DefaultTempPrefix                     string
@@ -37,6 +44,22 @@ type Config struct {
 	DefaultUploadPresignedUrlExpiration   int
 	DefaultScriptDownloadSizeLimit        int
 	DefaultZipDownloadSizeLimit           int
+	DefaultArchiveJobSizeLimit            int
+	DefaultManifestEntryLimit             int
+	BucketRegionCacheTTL                  int
+	Port                                  int
+	// MaxDownloadPresignedUrlExpiration caps the download URL expiry of the roles it lists
+	MaxDownloadPresignedUrlExpiration map[string]time.Duration
+	// TempMaxAge is how long the objects under DefaultTempPrefix are kept
+	TempMaxAge time.Duration
+	// TempCleanupInterval is how often the temp janitor runs, 0 when it is disabled
+	TempCleanupInterval time.Duration
+	// PostUploadMaxSize is the largest object, in bytes, a presigned POST policy accepts
+	PostUploadMaxSize int64
+	// StaleUploadMaxAge is the age past which an incomplete multipart upload is aborted by the sweep
+	StaleUploadMaxAge time.Duration
+	// Storage selects the backend the controllers are built for
+	Storage config.Storage
 }
 
 // Store configuration for the handler
@@ -46,72 +69,181 @@ type BlobHandler struct {
 	AllowAllBuckets bool
 	DB              auth.Database
 	Config          *Config
+	// reloadMu serializes reloads so two of them never build controllers concurrently
+	reloadMu sync.Mutex
+	// router indexes S3Controllers by bucket, it is built lazily and replaced on reload
+	router *bucketRouter
+	// archiveJobs tracks the archives packaged in the background, it is created lazily
+	archiveJobs *archiveJobStore
 }
 
 // Initializes resources and return a new handler (errors are fatal)
-func NewBlobHandler(envJson string, authLvl int) (*BlobHandler, error) {
+func NewBlobHandler(cfg *config.Config) (*BlobHandler, error) {
 	// Create a new BlobHandler configuration
 	config := BlobHandler{
-		Config: newConfig(authLvl),
+		Config: NewConfig(cfg),
 	}
 
-	if authLvl > 0 {
-		if err := envcheck.CheckEnvVariablesExist([]string{"AUTH_LIMITED_WRITER_ROLE"}); err != nil {
-			log.Fatal(err)
-		}
-		config.Config.AuthLevel = authLvl
+	if config.Config.AuthLevel > 0 {
 		db, err := auth.NewPostgresDB()
 		if err != nil {
 			log.Fatal(err)
 		}
 		config.DB = db
 	}
-	s3MockStr := os.Getenv("S3_MOCK")
-	var s3Mock int
-	if s3MockStr == "" {
-		s3Mock = 0
+
+	controllers, allowAllBuckets, err := config.loadControllers()
+	if err != nil {
+		return nil, err
 	}
-	s3Mock, err := strconv.Atoi(s3MockStr)
+	config.S3Controllers = controllers
+	config.AllowAllBuckets = allowAllBuckets
+	config.router = newBucketRouter(controllers, config.regionCacheTTL())
+	config.router.resolveAll(controllers)
+
+	// Return the configured BlobHandler
+	return &config, nil
+}
+
+// Reload re-reads the accounts and bucket allow list from .env.json and swaps in the new set of controllers.
+// Requests that already hold a controller finish with it, and the current set is kept
+// when the new configuration can't be loaded.
+func (bh *BlobHandler) Reload() error {
+	bh.reloadMu.Lock()
+	defer bh.reloadMu.Unlock()
+
+	controllers, allowAllBuckets, err := bh.loadControllers()
 	if err != nil {
-		log.Fatalf("could not convert S3_MOCK env variable to integer: %v", err)
+		return err
 	}
-	// Check if the S3_MOCK environment variable is set to "true"
-	if s3Mock == 1 {
-		log.Info("Using MinIO")
 
-		// Load MinIO credentials from environment
-		creds := newMinioConfig()
+	router := newBucketRouter(controllers, bh.regionCacheTTL())
+	router.resolveAll(controllers)
+	bh.Mu.Lock()
+	bh.S3Controllers = controllers
+	bh.AllowAllBuckets = allowAllBuckets
+	bh.router = router
+	bh.Mu.Unlock()
+
+	log.Infof("reloaded %d controller(s) serving buckets: %v", len(controllers), bucketsOf(controllers))
+	return nil
+}
+
+// controllers returns the current set of controllers. Reload and /list_buckets replace the slice
+// rather than modifying it, so the result can be used after the lock is released and must not be
+// modified.
+func (bh *BlobHandler) controllers() []S3Controller {
+	bh.Mu.Lock()
+	defer bh.Mu.Unlock()
+	return bh.S3Controllers
+}
 
-		// Validate MinIO credentials, check if they are missing or incomplete
-		// if not then the s3api won't start
-		if err := creds.validateMinioConfig(); err != nil {
-			log.Fatalf("MINIO credentials are either not provided or contain missing variables: %s", err.Error())
+// forEachBucket calls fn for every bucket the controllers serve, with the controller GetController
+// resolves for it so the store is bound to the bucket's region. Buckets that can't be resolved are
+// logged and skipped.
+func (bh *BlobHandler) forEachBucket(fn func(s3Ctrl *S3Controller, bucket string)) {
+	for _, ctrl := range bh.controllers() {
+		for _, bucket := range ctrl.Buckets {
+			s3Ctrl, err := bh.GetController(bucket)
+			if err != nil {
+				log.Errorf("skipping bucket %s: %s", bucket, err.Error())
+				continue
+			}
+			fn(s3Ctrl, bucket)
 		}
+	}
+}
+
+// routes returns the current controllers along with the router indexing them.
+func (bh *BlobHandler) routes() ([]S3Controller, *bucketRouter) {
+	bh.Mu.Lock()
+	defer bh.Mu.Unlock()
+	if bh.router == nil {
+		bh.router = newBucketRouter(bh.S3Controllers, bh.regionCacheTTL())
+	}
+	return bh.S3Controllers, bh.router
+}
+
+func (bh *BlobHandler) regionCacheTTL() time.Duration {
+	if bh.Config == nil {
+		return time.Duration(config.Default().Limits.BucketRegionCacheTTLMinutes) * time.Minute
+	}
+	return time.Duration(bh.Config.BucketRegionCacheTTL) * time.Minute
+}
+
+func bucketsOf(controllers []S3Controller) []string {
+	var buckets []string
+	for _, s3Ctrl := range controllers {
+		buckets = append(buckets, s3Ctrl.Buckets...)
+	}
+	return buckets
+}
+
+// loadControllers builds the controllers for the storage backend selected by the environment:
+// a local directory tree, MinIO, or the AWS accounts listed in bh.EnvJson. The second return
+// value reports whether every bucket of the accounts is allowed.
+func (bh *BlobHandler) loadControllers() ([]S3Controller, bool, error) {
+	storage := bh.Config.Storage
+	// Serve a local directory tree instead of S3, used for offline development
+	if storage.LocalStore.Root != "" {
+		log.Infof("Using local storage at %s", storage.LocalStore.Root)
+		// keep the running store so the URLs it signed stay valid across reloads
+		store := bh.localStore()
+		if store == nil {
+			var err error
+			store, err = localStoreManager(storage.LocalStore, bh.Config.Port)
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to initialize local storage: %s", err.Error())
+			}
+		}
+
+		S3Ctrl := S3Controller{Store: store}
+		result, err := S3Ctrl.ListBuckets()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to retrieve list of local buckets: %s", err.Error())
+		}
+		var bucketNames []string
+		for _, bucket := range result.Buckets {
+			bucketNames = append(bucketNames, aws.StringValue(bucket.Name))
+		}
+		// every directory under the root is served, new ones are picked up by /list_buckets
+		return []S3Controller{{Store: store, Buckets: bucketNames}}, true, nil
+	}
+
+	// Check if the S3_MOCK setting is enabled
+	if storage.S3Mock {
+		log.Info("Using MinIO")
+
+		// MinIO credentials are checked by config.Validate at startup
+		creds := storage.MinIO
 
 		// Create a MinIO session and S3 client
-		s3SVC, sess, err := minIOSessionManager(creds)
+		store, err := minIOSessionManager(creds)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to create MinIO session: %s", err.Error())
+		}
+		// Create the missing buckets and seed them from the fixtures
+		bucketNames, allowAllBuckets, err := provisionMinioBuckets(store, creds)
 		if err != nil {
-			log.Fatalf("failed to create MinIO session: %s", err.Error())
+			return nil, false, fmt.Errorf("failed to provision MinIO buckets: %s", err.Error())
 		}
 
 		// Configure the BlobHandler with MinIO session and bucket information
-		config.S3Controllers = []S3Controller{{Sess: sess, S3Svc: s3SVC, Buckets: []string{creds.Bucket}, S3Mock: true}}
-		// Return the configured BlobHandler
-		return &config, nil
+		return []S3Controller{{Name: "minio", Store: store, Buckets: bucketNames, S3Mock: true}}, allowAllBuckets, nil
 	}
 
 	// Using AWS S3
 	// Load AWS credentials from the provided .env.json file
 	log.Debug("looking for .env.json")
-	awsConfig, err := newAWSConfig(envJson)
+	awsConfig, err := newAWSConfig(storage.EnvJson)
 
 	// Check if loading AWS credentials from .env.json failed
 	if err != nil {
-		return nil, fmt.Errorf("env.json credentials extraction failed, please check `.env.json.example` for reference on formatting, %s", err.Error())
+		return nil, false, fmt.Errorf("env.json credentials extraction failed, please check `.env.json.example` for reference on formatting, %s", err.Error())
 	}
 
 	//does it contain "*"
-	config.AllowAllBuckets = arrayContains("*", awsConfig.BucketAllowList)
+	allowAllBuckets := arrayContains("*", awsConfig.BucketAllowList)
 
 	// Convert allowed buckets to a map for efficient lookup
 	allowedBucketsMap := make(map[string]struct{})
@@ -119,26 +251,28 @@ func NewBlobHandler(envJson string, authLvl int) (*BlobHandler, error) {
 		allowedBucketsMap[bucket] = struct{}{}
 	}
 
+	var controllers []S3Controller
 	// Load AWS credentials for multiple accounts from .env.json
-	for _, creds := range awsConfig.Accounts {
+	for i, creds := range awsConfig.Accounts {
+		name := creds.displayName(i)
 		// Create an AWS session and S3 client for each account
-		s3SVC, sess, err := aWSSessionManager(creds)
+		store, err := aWSSessionManager(creds)
 		if err != nil {
-			errMsg := fmt.Errorf("failed to create AWS session: %s", err.Error())
+			errMsg := fmt.Errorf("failed to create AWS session for account `%s`: %s", name, err.Error())
 			log.Error(errMsg.Error())
-			return nil, errMsg
+			return nil, false, errMsg
 		}
 
-		S3Ctrl := S3Controller{Sess: sess, S3Svc: s3SVC}
+		S3Ctrl := S3Controller{Name: name, Store: store}
 		// Retrieve the list of buckets for each account
 		result, err := S3Ctrl.ListBuckets()
 		if err != nil {
-			errMsg := fmt.Errorf("failed to retrieve list of buckets with access key: %s, error: %s", creds.AWS_ACCESS_KEY_ID, err.Error())
-			return nil, errMsg
+			errMsg := fmt.Errorf("failed to retrieve list of buckets for account `%s` using %s credentials, error: %s", name, creds.source(), err.Error())
+			return nil, false, errMsg
 		}
 
 		var bucketNames []string
-		if config.AllowAllBuckets {
+		if allowAllBuckets {
 			// Directly add all bucket names if allowAllBucket is true
 			for _, bucket := range result.Buckets {
 				bucketNames = append(bucketNames, aws.StringValue(bucket.Name))
@@ -155,66 +289,159 @@ func NewBlobHandler(envJson string, authLvl int) (*BlobHandler, error) {
 		}
 
 		if len(bucketNames) > 0 {
-			config.S3Controllers = append(config.S3Controllers, S3Controller{Sess: sess, S3Svc: s3SVC, Buckets: bucketNames, S3Mock: false})
+			controllers = append(controllers, S3Controller{Name: name, Store: store, Buckets: bucketNames, S3Mock: false})
 		}
 	}
 
-	if !config.AllowAllBuckets && len(allowedBucketsMap) > 0 {
+	if !allowAllBuckets && len(allowedBucketsMap) > 0 {
 		missingBuckets := make([]string, 0, len(allowedBucketsMap))
 		for bucket := range allowedBucketsMap {
 			missingBuckets = append(missingBuckets, bucket)
 		}
-		return nil, fmt.Errorf("some buckets in the allow list were not found: %v", missingBuckets)
+		return nil, false, fmt.Errorf("some buckets in the allow list were not found: %v", missingBuckets)
 	}
 
-	// Return the configured BlobHandler
-	return &config, nil
+	return controllers, allowAllBuckets, nil
 }
 
-func aWSSessionManager(creds AWSCreds) (*s3.S3, *session.Session, error) {
-	log.Info("Using AWS S3")
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String("us-east-1"),
-		Credentials: credentials.NewStaticCredentials(creds.AWS_ACCESS_KEY_ID, creds.AWS_SECRET_ACCESS_KEY, ""),
-	})
+func aWSSessionManager(creds AWSCreds) (ObjectStore, error) {
+	region := creds.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	awsCfg := &aws.Config{
+		Region: aws.String(region),
+	}
+	if creds.Endpoint != "" {
+		awsCfg.Endpoint = aws.String(creds.Endpoint)
+	}
+	if creds.ForcePathStyle {
+		awsCfg.S3ForcePathStyle = aws.Bool(true)
+	}
+	if creds.DisableSSL {
+		awsCfg.DisableSSL = aws.Bool(true)
+	}
+	if creds.InsecureSkipVerify || creds.CABundle != "" {
+		httpClient, err := newTLSClient(creds.InsecureSkipVerify, creds.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		awsCfg.HTTPClient = httpClient
+	}
+
+	sess, err := newAccountSession(creds, awsCfg)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error creating s3 session: %s", err.Error())
+		return nil, fmt.Errorf("error creating s3 session: %s", err.Error())
+	}
+	store := NewS3ObjectStore(sess)
+	store.SetPublicEndpoint(creds.PublicEndpoint)
+	if creds.Endpoint == "" {
+		log.Infof("Using AWS S3 with %s credentials", creds.source())
+		return store, nil
 	}
-	return s3.New(sess), sess, nil
+	// S3-compatible services serve every bucket from the configured region, and often report
+	// a location that doesn't match it, so region detection is skipped for them
+	log.Infof("Using S3-compatible endpoint %s with %s credentials", creds.Endpoint, creds.source())
+	return fixedRegionStore{store}, nil
 }
 
-func minIOSessionManager(mc MinioConfig) (*s3.S3, *session.Session, error) {
+// newAccountSession creates the session for an account, resolving its credentials from the
+// account's credential source. Credentials other than static keys are refreshed by the SDK
+// before they expire, so the session can be kept for the lifetime of the handler.
+func newAccountSession(creds AWSCreds, awsCfg *aws.Config) (*session.Session, error) {
+	switch creds.source() {
+	case credentialSourceStatic:
+		awsCfg.Credentials = credentials.NewStaticCredentials(creds.AWS_ACCESS_KEY_ID, creds.AWS_SECRET_ACCESS_KEY, "")
+		return session.NewSession(awsCfg)
+	case credentialSourceDefault, credentialSourceProfile:
+		return session.NewSessionWithOptions(session.Options{
+			Config:            *awsCfg,
+			Profile:           creds.Profile,
+			SharedConfigState: session.SharedConfigEnable,
+		})
+	case credentialSourceAssumeRole:
+		duration, err := creds.sessionDuration()
+		if err != nil {
+			return nil, err
+		}
+		// the base session talks to STS, so it must not inherit the S3 endpoint of the account
+		baseCfg := aws.Config{Region: awsCfg.Region, HTTPClient: awsCfg.HTTPClient}
+		if creds.AWS_ACCESS_KEY_ID != "" {
+			baseCfg.Credentials = credentials.NewStaticCredentials(creds.AWS_ACCESS_KEY_ID, creds.AWS_SECRET_ACCESS_KEY, "")
+		}
+		baseSess, err := session.NewSessionWithOptions(session.Options{
+			Config:            baseCfg,
+			Profile:           creds.Profile,
+			SharedConfigState: session.SharedConfigEnable,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error creating base session to assume role %s: %s", creds.RoleARN, err.Error())
+		}
+		awsCfg.Credentials = stscreds.NewCredentials(baseSess, creds.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = "s3api"
+			if creds.ExternalID != "" {
+				p.ExternalID = aws.String(creds.ExternalID)
+			}
+			if duration > 0 {
+				p.Duration = duration
+			}
+			// refresh a little ahead of the expiry so in-flight requests don't use stale credentials
+			p.ExpiryWindow = time.Minute
+		})
+		return session.NewSession(awsCfg)
+	}
+	return nil, fmt.Errorf("unknown credential_source `%s`", creds.CredentialSource)
+}
+
+// newTLSClient returns an http client trusting the system roots plus the PEM certificates in
+// caBundle, or skipping certificate verification altogether for self-signed test setups.
+func newTLSClient(insecureSkipVerify bool, caBundle string) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caBundle != "" {
+		pem, err := os.ReadFile(caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("error reading ca bundle: %s", err.Error())
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca bundle %s", caBundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+func minIOSessionManager(mc config.MinIO) (*S3ObjectStore, error) {
 	sess, err := session.NewSession(&aws.Config{
-		Endpoint:         aws.String(mc.S3Endpoint),
+		Endpoint:         aws.String(mc.Endpoint),
 		Region:           aws.String("us-east-1"),
 		Credentials:      credentials.NewStaticCredentials(mc.AccessKeyID, mc.SecretAccessKey, ""),
-		S3ForcePathStyle: aws.Bool(true),
+		S3ForcePathStyle: aws.Bool(mc.ForcePathStyle),
+		DisableSSL:       aws.Bool(mc.DisableSSL),
 	})
 	if err != nil {
-		return nil, nil, fmt.Errorf("error connecting to minio session: %s", err.Error())
+		return nil, fmt.Errorf("error connecting to minio session: %s", err.Error())
 	}
 	log.Info("Using minio to mock s3")
 
-	// Check if the bucket exists
-	s3SVC := s3.New(sess)
-	_, err = s3SVC.HeadBucket(&s3.HeadBucketInput{
-		Bucket: aws.String(mc.Bucket),
-	})
-	if err != nil {
-		// Bucket does not exist, create it
-		_, err := s3SVC.CreateBucket(&s3.CreateBucketInput{
-			Bucket: aws.String(mc.Bucket),
-		})
-		if err != nil {
-			log.Errorf("Error creating bucket: %s", err.Error())
-			return nil, nil, nil
-		}
-		log.Info("Bucket created successfully")
-	} else {
-		log.Info("Bucket already exists")
-	}
+	store := NewS3ObjectStore(sess)
+	// presigned urls are used by the browser, so they have to point at the host-mapped port
+	store.SetPublicEndpoint(mc.PublicEndpoint)
 
-	return s3SVC, sess, nil
+	return store, nil
+}
+
+func localStoreManager(lc config.LocalStore, port int) (*LocalStore, error) {
+	baseURL := lc.URL
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("http://localhost:%d%s", port, LocalStoreRoutePrefix)
+	}
+	return NewLocalStore(lc.Root, baseURL, lc.Secret)
 }
 
 func (bh *BlobHandler) GetController(bucket string) (*S3Controller, error) {
@@ -224,50 +451,46 @@ func (bh *BlobHandler) GetController(bucket string) (*S3Controller, error) {
 		return nil, err
 	}
 	var s3Ctrl S3Controller
-	for i := 0; i < len(bh.S3Controllers); i++ {
-		for _, b := range bh.S3Controllers[i].Buckets {
-			if b == bucket {
-				s3Ctrl = bh.S3Controllers[i]
-
-				// Detect the bucket's region
-				region, err := getBucketRegion(bh.S3Controllers[i].S3Svc, b)
-				if err != nil {
-					log.Errorf("Failed to get region for bucket '%s': %s", b, err.Error())
-					continue
-				}
-				// Check if the region is the same. If not, update the session and client
-				currentRegion := *s3Ctrl.Sess.Config.Region
-				if currentRegion != region {
-					log.Debugf("current region: %s region of bucket: %s, attempting to create a new controller", currentRegion, region)
-
-					newSession, err := session.NewSession(&aws.Config{
-						Region:      aws.String(region),
-						Credentials: s3Ctrl.Sess.Config.Credentials,
-					})
-					if err != nil {
-						log.Errorf("Failed to create a new session for region '%s': %s", region, err.Error())
-						continue
-					}
-					s3Ctrl.Sess = newSession
-					s3Ctrl.S3Svc = s3.New(s3Ctrl.Sess)
-					bh.Mu.Lock()
-					bh.S3Controllers[i] = s3Ctrl
-					bh.Mu.Unlock()
-				}
-
-				return &s3Ctrl, nil
+	controllers, router := bh.routes()
+	i, store, ok := router.lookup(bucket)
+	if !ok {
+		// buckets picked up by /list_buckets after the router was built
+		i = -1
+		for j := range controllers {
+			if arrayContains(bucket, controllers[j].Buckets) {
+				i = j
+				break
 			}
 		}
+		if i < 0 {
+			return &s3Ctrl, fmt.Errorf("bucket '%s' not found", bucket)
+		}
+		router.add(bucket, i)
+	}
+	s3Ctrl = controllers[i]
+
+	if store != nil {
+		s3Ctrl.Store = store
+		return &s3Ctrl, nil
 	}
-	return &s3Ctrl, fmt.Errorf("bucket '%s' not found", bucket)
+	regional, ok := s3Ctrl.Store.(RegionalStore)
+	if !ok {
+		return &s3Ctrl, nil
+	}
+	// Detect the bucket's region, the store bound to it is cached until the TTL expires
+	store, err := router.resolve(bucket, i, s3Ctrl.Store, regional)
+	if err != nil {
+		log.Errorf("Failed to get region for bucket '%s': %s", bucket, err.Error())
+		return &s3Ctrl, fmt.Errorf("bucket '%s' not found", bucket)
+	}
+	s3Ctrl.Store = store
+	return &s3Ctrl, nil
 }
 
-func getBucketRegion(S3Svc *s3.S3, bucketName string) (string, error) {
-	req, output := S3Svc.GetBucketLocationRequest(&s3.GetBucketLocationInput{
+func getBucketRegion(store ObjectStore, bucketName string) (string, error) {
+	output, err := store.GetBucketLocation(&s3.GetBucketLocationInput{
 		Bucket: aws.String(bucketName),
 	})
-
-	err := req.Send()
 	if err != nil {
 		return "", err
 	}
@@ -288,9 +511,9 @@ func (bh *BlobHandler) PingWithAuth(c echo.Context) error {
 	bucketHealth := make(map[string]string)
 	var valid string
 
-	for _, s3Ctrl := range bh.S3Controllers {
+	for _, s3Ctrl := range bh.controllers() {
 		for _, b := range s3Ctrl.Buckets {
-			_, err := s3Ctrl.S3Svc.HeadBucket(&s3.HeadBucketInput{
+			_, err := s3Ctrl.Store.HeadBucket(&s3.HeadBucketInput{
 				Bucket: aws.String(b),
 			})
 			if err != nil {
@@ -308,6 +531,16 @@ func (bh *BlobHandler) PingWithAuth(c echo.Context) error {
 	return c.JSON(http.StatusOK, bucketHealth)
 }
 
+// HandleReload rebuilds the controllers from .env.json, the same as sending SIGHUP to the process.
+func (bh *BlobHandler) HandleReload(c echo.Context) error {
+	if err := bh.Reload(); err != nil {
+		errMsg := fmt.Errorf("error reloading configuration, the current configuration is kept: %s", err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusInternalServerError, errMsg.Error())
+	}
+	return c.JSON(http.StatusOK, bucketsOf(bh.controllers()))
+}
+
 func (bh *BlobHandler) GetS3ReadPermissions(c echo.Context, bucket string) ([]string, bool, int, error) {
 	permissions, fullAccess, err := bh.GetUserS3ReadListPermission(c, bucket)
 	if err != nil {
@@ -324,6 +557,21 @@ func (bh *BlobHandler) GetS3ReadPermissions(c echo.Context, bucket string) ([]st
 	return permissions, fullAccess, http.StatusOK, nil
 }
 
+func (bh *BlobHandler) GetS3WritePermissions(c echo.Context, bucket string) ([]string, bool, int, error) {
+	permissions, fullAccess, err := bh.GetUserS3WritePermission(c, bucket)
+	if err != nil {
+		httpStatus := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "this endpoint requires authentication information that is unavailable when authorization is disabled.") {
+			httpStatus = http.StatusForbidden
+		}
+		return nil, false, httpStatus, fmt.Errorf("error fetching user permissions: %s", err.Error())
+	}
+	if !fullAccess && len(permissions) == 0 {
+		return nil, false, http.StatusForbidden, fmt.Errorf("user does not have permission to write to the %s bucket", bucket)
+	}
+	return permissions, fullAccess, http.StatusOK, nil
+}
+
 func (bh *BlobHandler) HandleCheckS3UserPermission(c echo.Context) error {
 	if bh.Config.AuthLevel == 0 {
 		log.Info("Checked user permissions successfully")
@@ -341,7 +589,7 @@ func (bh *BlobHandler) HandleCheckS3UserPermission(c echo.Context) error {
 		log.Error(errMsg.Error())
 		return c.JSON(http.StatusInternalServerError, errMsg.Error())
 	}
-	isAdminOrWriter := auth.Overlap(claims.RealmAccess["roles"], []string{"s3_admin", "s3_writer"})
+	isAdminOrWriter := auth.Overlap(claims.RealmAccess["roles"], []string{bh.Config.AdminRoleName, bh.Config.WriterRoleName})
 	if isAdminOrWriter {
 		return c.JSON(http.StatusOK, true)
 	}
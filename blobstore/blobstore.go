@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/Dewberry/s3api/auth"
 	"github.com/Dewberry/s3api/utils"
@@ -16,7 +17,7 @@ import (
 
 func (s3Ctrl *S3Controller) KeyExists(bucket string, key string) (bool, error) {
 
-	_, err := s3Ctrl.S3Svc.HeadObject(&s3.HeadObjectInput{
+	_, err := s3Ctrl.Store.HeadObject(&s3.HeadObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	})
@@ -35,23 +36,24 @@ func (s3Ctrl *S3Controller) KeyExists(bucket string, key string) (bool, error) {
 }
 
 // function that will get the most recently uploaded file in a prefix
-// func (s3Ctrl *S3Controller) getMostRecentModTime(bucket, prefix string, permissions []string, fullAccess bool) (time.Time, error) {
-// 	// Initialize a time variable to store the most recent modification time
-// 	var mostRecent time.Time
-
-// 	// Call GetList to retrieve the list of objects with the specified prefix
-// 	response, err := s3Ctrl.GetList(bucket, prefix, false)
-// 	if err != nil {
-// 		return time.Time{}, err
-// 	}
-// 	// Iterate over the returned objects to find the most recent modification time
-// 	for _, item := range response.Contents {
-// 		if item.LastModified != nil && item.LastModified.After(mostRecent) {
-// 			mostRecent = *item.LastModified
-// 		}
-// 	}
-// 	return mostRecent, nil
-// }
+func (s3Ctrl *S3Controller) getMostRecentModTime(bucket, prefix string) (time.Time, error) {
+	// Initialize a time variable to store the most recent modification time
+	var mostRecent time.Time
+
+	// Iterate over every page of objects with the specified prefix to find the most recent modification time
+	err := s3Ctrl.GetListWithCallBack(bucket, prefix, false, func(page *s3.ListObjectsV2Output) error {
+		for _, item := range page.Contents {
+			if item.LastModified != nil && item.LastModified.After(mostRecent) {
+				mostRecent = *item.LastModified
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return mostRecent, nil
+}
 
 func arrayContains(a string, arr []string) bool {
 	for _, b := range arr {
@@ -113,6 +115,34 @@ func (bh *BlobHandler) CheckUserS3Permission(c echo.Context, bucket, prefix stri
 	return 0, nil
 }
 
+// GetUserS3WritePermission returns the prefixes of bucket the user may write to, or fullAccess when
+// the user isn't a limited writer and may write anywhere.
+func (bh *BlobHandler) GetUserS3WritePermission(c echo.Context, bucket string) ([]string, bool, error) {
+	permissions := make([]string, 0)
+
+	if bh.Config.AuthLevel > 0 {
+		initAuth := os.Getenv("INIT_AUTH")
+		if initAuth == "0" {
+			errMsg := fmt.Errorf("this endpoint requires authentication information that is unavailable when authorization is disabled. Please enable authorization to use this functionality")
+			return permissions, false, errMsg
+		}
+		claims, ok := c.Get("claims").(*auth.Claims)
+		if !ok {
+			return permissions, false, fmt.Errorf("could not get claims from request context")
+		}
+		if !utils.StringInSlice(bh.Config.LimitedWriterRoleName, claims.RealmAccess["roles"]) {
+			return permissions, true, nil
+		}
+		permissions, err := bh.DB.GetUserAccessiblePrefixes(claims.Email, bucket, []string{"write"})
+		if err != nil {
+			return permissions, false, err
+		}
+		return permissions, false, nil
+	}
+
+	return permissions, true, nil
+}
+
 func (bh *BlobHandler) GetUserS3ReadListPermission(c echo.Context, bucket string) ([]string, bool, error) {
 	permissions := make([]string, 0)
 
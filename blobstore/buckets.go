@@ -20,7 +20,7 @@ func (s3Ctrl *S3Controller) ListBuckets() (*s3.ListBucketsOutput, error) {
 	input := &s3.ListBucketsInput{}
 
 	// Retrieve the list of buckets
-	result, err = s3Ctrl.S3Svc.ListBuckets(input)
+	result, err = s3Ctrl.Store.ListBuckets(input)
 	if err != nil {
 		errMsg := fmt.Errorf("failed to call ListBuckets: %s", err.Error())
 		return nil, errMsg
@@ -78,14 +78,49 @@ func (s3Ctrl *S3Controller) ListBuckets() (*s3.ListBucketsOutput, error) {
 type BucketInfo struct {
 	ID      int    `json:"id"`
 	Name    string `json:"name"`
+	Account string `json:"account,omitempty"`
 	CanRead bool   `json:"can_read"`
 }
 
-func (bh *BlobHandler) HandleListBuckets(c echo.Context) error {
-	var allBuckets []BucketInfo
+// refreshBuckets replaces the bucket lists of the controllers with the buckets their accounts
+// currently hold and returns the new set of controllers. Like Reload it swaps in a new slice,
+// so requests holding the previous one keep reading consistent bucket lists.
+func (bh *BlobHandler) refreshBuckets() ([]S3Controller, error) {
+	bh.reloadMu.Lock()
+	defer bh.reloadMu.Unlock()
+
+	current := bh.controllers()
+	refreshed := make([]S3Controller, len(current))
+	changed := false
+	for i, controller := range current {
+		result, err := controller.ListBuckets()
+		if err != nil {
+			return nil, err
+		}
+		var mostRecentBucketList []string
+		for _, b := range result.Buckets {
+			mostRecentBucketList = append(mostRecentBucketList, *b.Name)
+		}
+		refreshed[i] = controller
+		if !isIdenticalArray(controller.Buckets, mostRecentBucketList) {
+			refreshed[i].Buckets = mostRecentBucketList
+			changed = true
+		}
+	}
+	if !changed {
+		return current, nil
+	}
 
+	// the controllers keep their positions, so the router indexing them stays valid and
+	// picks up new buckets when they are first requested
 	bh.Mu.Lock()
-	defer bh.Mu.Unlock()
+	bh.S3Controllers = refreshed
+	bh.Mu.Unlock()
+	return refreshed, nil
+}
+
+func (bh *BlobHandler) HandleListBuckets(c echo.Context) error {
+	var allBuckets []BucketInfo
 
 	// Check user's overall read access level
 	_, fullAccess, err := bh.GetUserS3ReadListPermission(c, "")
@@ -93,24 +128,20 @@ func (bh *BlobHandler) HandleListBuckets(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, fmt.Errorf("error fetching user permissions: %s", err.Error()))
 	}
 
-	for i := range bh.S3Controllers {
-		controller := &bh.S3Controllers[i]
-		if bh.AllowAllBuckets {
-			result, err := controller.ListBuckets()
-			if err != nil {
-				errMsg := fmt.Errorf("error returning list of buckets, error: %s", err)
-				log.Error(errMsg)
-				return c.JSON(http.StatusInternalServerError, errMsg)
-			}
-			var mostRecentBucketList []string
-			for _, b := range result.Buckets {
-				mostRecentBucketList = append(mostRecentBucketList, *b.Name)
-			}
-			if !isIdenticalArray(controller.Buckets, mostRecentBucketList) {
-				controller.Buckets = mostRecentBucketList
-			}
+	bh.Mu.Lock()
+	allowAllBuckets := bh.AllowAllBuckets
+	bh.Mu.Unlock()
+	controllers := bh.controllers()
+	if allowAllBuckets {
+		controllers, err = bh.refreshBuckets()
+		if err != nil {
+			errMsg := fmt.Errorf("error returning list of buckets, error: %s", err)
+			log.Error(errMsg)
+			return c.JSON(http.StatusInternalServerError, errMsg)
 		}
+	}
 
+	for _, controller := range controllers {
 		// Extract the bucket names from the response and append to allBuckets
 		for i, bucket := range controller.Buckets {
 			canRead := fullAccess
@@ -124,6 +155,7 @@ func (bh *BlobHandler) HandleListBuckets(c echo.Context) error {
 			allBuckets = append(allBuckets, BucketInfo{
 				ID:      i,
 				Name:    bucket,
+				Account: controller.Name,
 				CanRead: canRead,
 			})
 		}
@@ -0,0 +1,44 @@
+package blobstore_test
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/Dewberry/s3api/blobstore"
+)
+
+func TestListBucketsPicksUpNewBuckets(t *testing.T) {
+	srv, bh := newTestHandler(t, "first")
+	bh.AllowAllBuckets = true
+	srv.CreateBucket("second")
+	srv.PutObject("first", "a.txt", []byte("a"))
+
+	// the refresh runs alongside requests reading the controllers
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if rec := serve(t, bh.HandleListBuckets, http.MethodGet, "/list_buckets", nil); rec.Code != http.StatusOK {
+				t.Errorf("list_buckets: %d %s", rec.Code, rec.Body.String())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if rec := serve(t, bh.HandleListByPrefix, http.MethodGet, "/prefix/list?bucket=first&prefix=", nil); rec.Code != http.StatusOK {
+				t.Errorf("prefix/list: %d %s", rec.Code, rec.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+
+	var buckets []blobstore.BucketInfo
+	decode(t, serve(t, bh.HandleListBuckets, http.MethodGet, "/list_buckets", nil), http.StatusOK, &buckets)
+	if len(buckets) != 2 || buckets[0].Name != "first" || buckets[1].Name != "second" {
+		t.Fatalf("got buckets %+v", buckets)
+	}
+	if _, err := bh.GetController("second"); err != nil {
+		t.Fatalf("the new bucket is not routed: %s", err.Error())
+	}
+}
@@ -0,0 +1,308 @@
+package blobstore
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// md5MetadataKey is the user metadata holding the hex encoded md5 of an object when the client
+// supplied one, the ETag of a multipart object is not the md5 of its content.
+const md5MetadataKey = "md5"
+
+// stagedUploadPrefix marks the IDs of multipart uploads created with checksums. Those uploads are
+// written to a staging key under the temp prefix and copied to their key once they are verified, so
+// an object that doesn't match is never seen at its key. Clients use the ID as it is handed out.
+const stagedUploadPrefix = "staged:"
+
+// Copies of verified uploads
+const (
+	// maxCopyObjectSize is the largest object a single CopyObject copies, larger ones are copied part by part
+	maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+	// copyPartSize is the part size of those copies unless the part limit needs larger parts
+	copyPartSize = 1024 * 1024 * 1024
+	// copyConcurrency is how many parts of a copy are copied at the same time
+	copyConcurrency = 8
+)
+
+// uploadChecksums are the checksums a client supplied for an object or a part, empty when not supplied.
+type uploadChecksums struct {
+	// MD5 is base64 encoded, like the Content-MD5 header
+	MD5 string
+	// SHA256 is hex encoded, like the sha256 metadata
+	SHA256 string
+}
+
+// parseUploadChecksums reads the `content_md5` (base64) and `sha256` (hex) parameters, the
+// Content-MD5 header is used when `content_md5` is omitted.
+func parseUploadChecksums(c echo.Context) (uploadChecksums, error) {
+	checksums := uploadChecksums{
+		MD5:    c.QueryParam("content_md5"),
+		SHA256: strings.ToLower(c.QueryParam("sha256")),
+	}
+	if checksums.MD5 == "" {
+		checksums.MD5 = c.Request().Header.Get("Content-MD5")
+	}
+	if checksums.MD5 != "" {
+		sum, err := base64.StdEncoding.DecodeString(checksums.MD5)
+		if err != nil || len(sum) != md5.Size {
+			return checksums, fmt.Errorf("`content_md5` must be the base64 encoded md5 of the content, got `%s`", checksums.MD5)
+		}
+	}
+	if checksums.SHA256 != "" {
+		sum, err := hex.DecodeString(checksums.SHA256)
+		if err != nil || len(sum) != sha256.Size {
+			return checksums, fmt.Errorf("`sha256` must be the hex encoded sha256 of the content, got `%s`", checksums.SHA256)
+		}
+	}
+	return checksums, nil
+}
+
+func (u uploadChecksums) empty() bool {
+	return u.MD5 == "" && u.SHA256 == ""
+}
+
+// contentMD5 is the value of the Content-MD5 header, nil when no md5 was supplied.
+func (u uploadChecksums) contentMD5() *string {
+	if u.MD5 == "" {
+		return nil
+	}
+	return aws.String(u.MD5)
+}
+
+// checksumSHA256 is the value of the x-amz-checksum-sha256 header, which S3 expects base64 encoded,
+// nil when no sha256 was supplied.
+func (u uploadChecksums) checksumSHA256() *string {
+	if u.SHA256 == "" {
+		return nil
+	}
+	sum, _ := hex.DecodeString(u.SHA256)
+	return aws.String(base64.StdEncoding.EncodeToString(sum))
+}
+
+// metadata holds the supplied checksums as hex encoded user metadata, nil when none was supplied.
+func (u uploadChecksums) metadata() map[string]*string {
+	if u.empty() {
+		return nil
+	}
+	metadata := make(map[string]*string)
+	if u.SHA256 != "" {
+		metadata[sha256MetadataKey] = aws.String(u.SHA256)
+	}
+	if u.MD5 != "" {
+		sum, _ := base64.StdEncoding.DecodeString(u.MD5)
+		metadata[md5MetadataKey] = aws.String(hex.EncodeToString(sum))
+	}
+	return metadata
+}
+
+// verify compares the supplied checksums with the sums of the content that was received.
+func (u uploadChecksums) verify(md5Sum, sha256Sum []byte) error {
+	if u.MD5 != "" && base64.StdEncoding.EncodeToString(md5Sum) != u.MD5 {
+		return statusErrorf(http.StatusBadRequest, "checksum mismatch: the md5 of the content is %s, `content_md5` is %s", base64.StdEncoding.EncodeToString(md5Sum), u.MD5)
+	}
+	if u.SHA256 != "" && hex.EncodeToString(sha256Sum) != u.SHA256 {
+		return statusErrorf(http.StatusBadRequest, "checksum mismatch: the sha256 of the content is %s, `sha256` is %s", hex.EncodeToString(sha256Sum), u.SHA256)
+	}
+	return nil
+}
+
+// checksumReader computes the md5 and sha256 of what is read through it.
+type checksumReader struct {
+	r      io.Reader
+	md5    hash.Hash
+	sha256 hash.Hash
+}
+
+func newChecksumReader(r io.Reader) *checksumReader {
+	return &checksumReader{r: r, md5: md5.New(), sha256: sha256.New()}
+}
+
+func (cr *checksumReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.md5.Write(p[:n])
+	cr.sha256.Write(p[:n])
+	return n, err
+}
+
+// verify compares the checksums with the sums of everything read so far.
+func (cr *checksumReader) verify(checksums uploadChecksums) error {
+	return checksums.verify(cr.md5.Sum(nil), cr.sha256.Sum(nil))
+}
+
+// verifyObjectChecksums reads an object back and compares it with the supplied checksums. Multipart
+// uploads are sent by the client part by part, so only the stored object can be checked as a whole.
+func (s3Ctrl *S3Controller) verifyObjectChecksums(bucket, key string, checksums uploadChecksums) error {
+	output, err := s3Ctrl.Store.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer output.Body.Close()
+	cr := newChecksumReader(output.Body)
+	if _, err := io.Copy(io.Discard, cr); err != nil {
+		return fmt.Errorf("error reading %s: %s", key, err.Error())
+	}
+	return cr.verify(checksums)
+}
+
+// stagingKey is the key a multipart upload created with checksums for key is written to.
+func (bh *BlobHandler) stagingKey(key string) string {
+	return bh.tempPrefix() + "staged_uploads/" + key
+}
+
+// uploadTarget returns the key a multipart upload the client refers to by key and uploadID is written
+// to, the upload ID the store knows it by and whether it is staged.
+func (bh *BlobHandler) uploadTarget(key, uploadID string) (string, string, bool) {
+	if id := strings.TrimPrefix(uploadID, stagedUploadPrefix); id != uploadID {
+		return bh.stagingKey(key), id, true
+	}
+	return key, uploadID, false
+}
+
+// clientUpload returns the key and upload ID a client knows a multipart upload of the store by, the
+// inverse of uploadTarget.
+func (bh *BlobHandler) clientUpload(uploadKey, storeUploadID string) (string, string) {
+	if key := strings.TrimPrefix(uploadKey, bh.stagingKey("")); key != uploadKey {
+		return key, stagedUploadPrefix + storeUploadID
+	}
+	return uploadKey, storeUploadID
+}
+
+// copyVerifiedObject copies a staged object to key with its metadata and deletes the staged object.
+// The staged object is kept when the copy fails, the temp janitor removes it later.
+func (s3Ctrl *S3Controller) copyVerifiedObject(bucket, stagingKey, key string) error {
+	head, err := s3Ctrl.Store.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(stagingKey),
+	})
+	if err != nil {
+		return err
+	}
+	if size := aws.Int64Value(head.ContentLength); size > maxCopyObjectSize {
+		partSize := uploadPartSize(size, 1)
+		if partSize < copyPartSize {
+			partSize = copyPartSize
+		}
+		err = s3Ctrl.copyObjectParts(bucket, stagingKey, key, size, partSize, head)
+	} else {
+		_, err = s3Ctrl.Store.CopyObject(&s3.CopyObjectInput{
+			Bucket:     aws.String(bucket),
+			CopySource: aws.String(bucket + "/" + stagingKey),
+			Key:        aws.String(key),
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("error copying the verified upload to %s: %s", key, err.Error())
+	}
+	if _, err := s3Ctrl.Store.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(stagingKey)}); err != nil {
+		log.Errorf("error deleting staged upload %s: %s", stagingKey, err.Error())
+	}
+	return nil
+}
+
+// copyObjectParts copies an object of size bytes with a multipart upload whose parts of partSize bytes are
+// copied by the store, for objects too large for CopyObject. The upload is aborted when a part fails.
+func (s3Ctrl *S3Controller) copyObjectParts(bucket, srcKey, key string, size, partSize int64, head *s3.HeadObjectOutput) error {
+	resp, err := s3Ctrl.Store.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		ContentType: head.ContentType,
+		Metadata:    head.Metadata,
+	})
+	if err != nil {
+		return err
+	}
+
+	numParts := int((size + partSize - 1) / partSize)
+	parts := make([]*s3.CompletedPart, numParts)
+	errs := make(chan error, numParts)
+	sem := make(chan struct{}, copyConcurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < numParts; i++ {
+		start := int64(i) * partSize
+		end := start + partSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, start, end int64) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			result, err := s3Ctrl.Store.UploadPartCopy(&s3.UploadPartCopyInput{
+				Bucket:          aws.String(bucket),
+				Key:             aws.String(key),
+				UploadId:        resp.UploadId,
+				PartNumber:      aws.Int64(int64(i + 1)),
+				CopySource:      aws.String(bucket + "/" + srcKey),
+				CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+			})
+			if err != nil {
+				errs <- fmt.Errorf("part %d: %s", i+1, err.Error())
+				return
+			}
+			parts[i] = &s3.CompletedPart{ETag: result.CopyPartResult.ETag, PartNumber: aws.Int64(int64(i + 1))}
+		}(i, start, end)
+	}
+	wg.Wait()
+	close(errs)
+	err = <-errs
+	if err == nil {
+		_, err = s3Ctrl.Store.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(bucket),
+			Key:             aws.String(key),
+			UploadId:        resp.UploadId,
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+	}
+	if err != nil {
+		if _, abortErr := s3Ctrl.Store.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      aws.String(key),
+			UploadId: resp.UploadId,
+		}); abortErr != nil {
+			log.Errorf("error aborting the copy of %s to %s: %s", srcKey, key, abortErr.Error())
+		}
+		return err
+	}
+	return nil
+}
+
+// partContentMD5 is the Content-MD5 header of a part sent by the service, so the store rejects a part
+// that was corrupted on the way.
+func partContentMD5(data []byte) *string {
+	sum := md5.Sum(data)
+	return aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// presignedUpload is the response of a presigned upload URL that carries checksums. Headers lists
+// the headers the PUT must be sent with, the store refuses the upload otherwise.
+type presignedUpload struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+// presignedUploadHeaders are the headers a presigned PUT with the supplied checksums must be sent
+// with. Checksums and metadata other than the md5 are carried by the query of the URL.
+func (u uploadChecksums) presignedUploadHeaders() map[string]string {
+	headers := make(map[string]string)
+	if u.MD5 != "" {
+		headers["Content-MD5"] = u.MD5
+	}
+	return headers
+}
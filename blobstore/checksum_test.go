@@ -0,0 +1,154 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Dewberry/s3api/blobstore"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUploadChecksums(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	data := []byte("checked content")
+
+	rec := serve(t, bh.HandleMultipartUpload, http.MethodPost, "/object/upload?bucket=bkt&key=ok.txt&override=true&sha256="+sha256Hex(data), bytes.NewReader(data))
+	decode(t, rec, http.StatusOK, nil)
+	head, err := srv.Store().HeadObject(&s3.HeadObjectInput{Bucket: aws.String("bkt"), Key: aws.String("ok.txt")})
+	if err != nil || *head.Metadata["Sha256"] != sha256Hex(data) {
+		t.Fatalf("got metadata %v, %v", head, err)
+	}
+
+	rec = serve(t, bh.HandleMultipartUpload, http.MethodPost, "/object/upload?bucket=bkt&key=bad.txt&override=true&sha256="+sha256Hex([]byte("other")), bytes.NewReader(data))
+	decode(t, rec, http.StatusBadRequest, nil)
+	if _, ok := srv.GetObject("bkt", "bad.txt"); ok {
+		t.Fatal("a mismatching upload was stored")
+	}
+	rec = serve(t, bh.HandleMultipartUpload, http.MethodPost, "/object/upload?bucket=bkt&key=bad.txt&override=true&sha256=abc", bytes.NewReader(data))
+	decode(t, rec, http.StatusUnprocessableEntity, nil)
+}
+
+func TestPresignedUploadChecksums(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	data := []byte("presigned with md5")
+	sum := md5.Sum(data)
+	contentMD5 := base64.StdEncoding.EncodeToString(sum[:])
+
+	var presigned struct {
+		URL     string            `json:"url"`
+		Headers map[string]string `json:"headers"`
+	}
+	rec := serve(t, bh.HandleGetPresignedUploadURL, http.MethodGet, "/object/presigned_upload?bucket=bkt&key=a.txt&content_md5="+url.QueryEscape(contentMD5), nil)
+	decode(t, rec, http.StatusOK, &presigned)
+	if presigned.Headers["Content-MD5"] != contentMD5 {
+		t.Fatalf("got headers %v", presigned.Headers)
+	}
+	put := func(body []byte) int {
+		req, err := http.NewRequest(http.MethodPut, presigned.URL, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range presigned.Headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := put([]byte("tampered")); status != http.StatusBadRequest {
+		t.Fatalf("got %d for a mismatching PUT", status)
+	}
+	if status := put(data); status != http.StatusOK {
+		t.Fatalf("got %d for a matching PUT", status)
+	}
+	if got, _ := srv.GetObject("bkt", "a.txt"); !bytes.Equal(got, data) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+// stagedUpload uploads parts to a multipart upload created with query and completes it with complete.
+func stagedUpload(t *testing.T, bh *blobstore.BlobHandler, key, query string, complete map[string]interface{}, parts ...[]byte) (string, int) {
+	t.Helper()
+	var uploadID string
+	decode(t, serve(t, bh.HandleGetMultipartUploadID, http.MethodGet, "/object/multipart_upload_id?bucket=bkt&key="+key+query, nil), http.StatusOK, &uploadID)
+	var completed []map[string]interface{}
+	for i, data := range parts {
+		var partURL string
+		target := "/object/presigned_upload?bucket=bkt&key=" + key + "&upload_id=" + uploadID + "&part_number=" + strconv.Itoa(i+1)
+		decode(t, serve(t, bh.HandleGetPresignedUploadURL, http.MethodGet, target, nil), http.StatusOK, &partURL)
+		resp, body := fetch(t, http.MethodPut, partURL, bytes.NewReader(data))
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT part %d: %d %s", i+1, resp.StatusCode, body)
+		}
+		completed = append(completed, map[string]interface{}{"partNumber": i + 1, "eTag": resp.Header.Get("ETag")})
+	}
+	complete["uploadId"] = uploadID
+	complete["parts"] = completed
+	rec := serve(t, bh.HandleCompleteMultipartUpload, http.MethodPost, "/object/complete_multipart_upload?bucket=bkt&key="+key, complete)
+	return uploadID, rec.Code
+}
+
+func TestStagedMultipartUpload(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	first := bytes.Repeat([]byte("a"), 5*1024*1024)
+	second := []byte("tail")
+	whole := append(append([]byte(nil), first...), second...)
+
+	uploadID, status := stagedUpload(t, bh, "multi/ok.bin", "&sha256="+sha256Hex(whole), map[string]interface{}{}, first, second)
+	if !strings.HasPrefix(uploadID, "staged:") || status != http.StatusOK {
+		t.Fatalf("got upload %s and %d", uploadID, status)
+	}
+	if got, _ := srv.GetObject("bkt", "multi/ok.bin"); !bytes.Equal(got, whole) {
+		t.Fatalf("verified object has %d bytes, want %d", len(got), len(whole))
+	}
+	head, err := srv.Store().HeadObject(&s3.HeadObjectInput{Bucket: aws.String("bkt"), Key: aws.String("multi/ok.bin")})
+	if err != nil || *head.Metadata["Sha256"] != sha256Hex(whole) {
+		t.Fatalf("got metadata %v, %v", head, err)
+	}
+
+	// a mismatch is caught at the staging key and never reaches the requested key
+	_, status = stagedUpload(t, bh, "multi/bad.bin", "&sha256="+sha256Hex([]byte("other")), map[string]interface{}{}, first, second)
+	if status != http.StatusBadRequest {
+		t.Fatalf("got %d for a mismatching upload", status)
+	}
+	if _, ok := srv.GetObject("bkt", "multi/bad.bin"); ok {
+		t.Fatal("a mismatching upload was written to its key")
+	}
+	_, status = stagedUpload(t, bh, "multi/bad-body.bin", "&sha256="+sha256Hex(whole), map[string]interface{}{"sha256": sha256Hex([]byte("other"))}, first, second)
+	if status != http.StatusBadRequest {
+		t.Fatalf("got %d for a mismatching sha256 in the complete body", status)
+	}
+	for _, key := range srv.Keys("bkt") {
+		if key != "multi/ok.bin" {
+			t.Fatalf("%s was left in the bucket", key)
+		}
+	}
+
+	// uploads without checksums are written to their key, which a sha256 at completion can't protect
+	_, status = stagedUpload(t, bh, "multi/plain.bin", "", map[string]interface{}{"sha256": sha256Hex(whole)}, first, second)
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("got %d for a sha256 on an upload without checksums", status)
+	}
+
+	var aborted string
+	decode(t, serve(t, bh.HandleGetMultipartUploadID, http.MethodGet, "/object/multipart_upload_id?bucket=bkt&key=multi/aborted.bin&sha256="+sha256Hex(whole), nil), http.StatusOK, &aborted)
+	rec := serve(t, bh.HandleAbortMultipartUpload, http.MethodPost, "/object/abort_multipart_upload?bucket=bkt&key=multi/aborted.bin&upload_id="+aborted, nil)
+	decode(t, rec, http.StatusOK, nil)
+}
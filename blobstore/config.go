@@ -1,53 +1,41 @@
 package blobstore
 
 import (
-	"os"
-	"strconv"
+	"time"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/Dewberry/s3api/config"
 )
 
-// these are the default values of limits if not predefined by user from env
-const (
-	defaultZipDownloadSizeLimit           = 5                //gb
-	defaultScriptDownloadSizeLimit        = 50               //gb
-	defaultUploadPresignedUrlExpiration   = 15               //minutes
-	defaultDownloadPresignedUrlExpiration = 7                //days
-	defaultTempPrefix                     = "downloads-temp" //prefix
-)
-
-func newConfig(authLvl int) *Config {
+// NewConfig returns the handler configuration derived from the API configuration.
+func NewConfig(cfg *config.Config) *Config {
 	c := &Config{
-		AuthLevel:                             authLvl,
-		LimitedWriterRoleName:                 os.Getenv("AUTH_LIMITED_WRITER_ROLE"),
-		LimitedReaderRoleName:                 os.Getenv("AUTH_LIMITED_READER_ROLE"),
-		DefaultTempPrefix:                     getEnvOrDefault("TEMP_PREFIX", defaultTempPrefix),
-		DefaultDownloadPresignedUrlExpiration: getIntEnvOrDefault("DOWNLOAD_URL_EXP_DAYS", defaultDownloadPresignedUrlExpiration),
-		DefaultUploadPresignedUrlExpiration:   getIntEnvOrDefault("UPLOAD_URL_EXP_MIN", defaultUploadPresignedUrlExpiration),
-		DefaultScriptDownloadSizeLimit:        getIntEnvOrDefault("SCRIPT_DOWNLOAD_SIZE_LIMIT", defaultScriptDownloadSizeLimit),
-		DefaultZipDownloadSizeLimit:           getIntEnvOrDefault("ZIP_DOWNLOAD_SIZE_LIMIT", defaultZipDownloadSizeLimit),
-	}
-	return c
-}
-
-// getEnvOrDefault returns the value of an environment variable or a default value if not set
-func getEnvOrDefault(envKey string, defaultValue string) string {
-	if value, exists := os.LookupEnv(envKey); exists {
-		return value
+		AuthLevel:                             cfg.Auth.Level,
+		AdminRoleName:                         cfg.Auth.AdminRole,
+		WriterRoleName:                        cfg.Auth.WriterRole,
+		ReaderRoleName:                        cfg.Auth.ReaderRole,
+		LimitedWriterRoleName:                 cfg.Auth.LimitedWriterRole,
+		LimitedReaderRoleName:                 cfg.Auth.LimitedReaderRole,
+		DefaultTempPrefix:                     cfg.Limits.TempPrefix,
+		DefaultDownloadPresignedUrlExpiration: cfg.Limits.DownloadURLExpirationDays,
+		DefaultUploadPresignedUrlExpiration:   cfg.Limits.UploadURLExpirationMinutes,
+		DefaultScriptDownloadSizeLimit:        cfg.Limits.ScriptDownloadSizeLimitGB,
+		DefaultZipDownloadSizeLimit:           cfg.Limits.ZipDownloadSizeLimitGB,
+		DefaultArchiveJobSizeLimit:            cfg.Limits.ArchiveJobSizeLimitGB,
+		DefaultManifestEntryLimit:             cfg.Limits.ManifestEntryLimit,
+		BucketRegionCacheTTL:                  cfg.Limits.BucketRegionCacheTTLMinutes,
+		Port:                                  cfg.Server.Port,
+		TempMaxAge:                            time.Duration(cfg.Limits.TempMaxAgeHours) * time.Hour,
+		TempCleanupInterval:                   time.Duration(cfg.Limits.TempCleanupIntervalMinutes) * time.Minute,
+		PostUploadMaxSize:                     int64(cfg.Limits.PostUploadMaxSizeMB) * 1024 * 1024,
+		StaleUploadMaxAge:                     time.Duration(cfg.Limits.StaleUploadMaxAgeDays) * 24 * time.Hour,
+		Storage:                               cfg.Storage,
 	}
-	return defaultValue
-}
-
-// getIntEnvOrDefault does the same as getEnvOrDefault but for integer values
-func getIntEnvOrDefault(envKey string, defaultValue int) int {
-	valueStr, exists := os.LookupEnv(envKey)
-	if !exists {
-		return defaultValue
+	c.MaxDownloadPresignedUrlExpiration = make(map[string]time.Duration)
+	for role, value := range cfg.Limits.DownloadURLMaxExpiration {
+		// invalid durations are reported by config.Validate
+		if d, err := time.ParseDuration(value); err == nil {
+			c.MaxDownloadPresignedUrlExpiration[role] = d
+		}
 	}
-	value, err := strconv.Atoi(valueStr)
-	if err != nil {
-		log.Debugf("Error parsing %s, defaulting to %v: %v", envKey, defaultValue, err)
-		return defaultValue
-	}
-	return value
+	return c
 }
@@ -0,0 +1,61 @@
+package blobstore
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestCopyObjectParts(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "bkt"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ls, err := NewLocalStore(root, "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3Ctrl := &S3Controller{Store: ls, Buckets: []string{"bkt"}}
+	data := []byte("0123456789")
+	if _, err := ls.PutObject(&s3.PutObjectInput{
+		Bucket:   aws.String("bkt"),
+		Key:      aws.String("src"),
+		Body:     bytes.NewReader(data),
+		Metadata: map[string]*string{"Sha256": aws.String("abc")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	head, err := ls.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("bkt"), Key: aws.String("src")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// parts of 4 bytes leave a shorter last part
+	if err := s3Ctrl.copyObjectParts("bkt", "src", "dst", int64(len(data)), 4, head); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ls.GetObject(&s3.GetObjectInput{Bucket: aws.String("bkt"), Key: aws.String("dst")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Body.Close()
+	got, err := io.ReadAll(out.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) || aws.StringValue(out.Metadata["Sha256"]) != "abc" {
+		t.Fatalf("got %q with metadata %v", got, aws.StringValueMap(out.Metadata))
+	}
+
+	if err := s3Ctrl.copyObjectParts("bkt", "missing", "other", int64(len(data)), 4, head); err == nil {
+		t.Fatal("copying a missing object succeeded")
+	}
+	if _, err := ls.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("bkt"), Key: aws.String("other")}); err == nil {
+		t.Fatal("a failed copy left an object")
+	}
+}
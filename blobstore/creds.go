@@ -3,56 +3,94 @@ package blobstore
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
 	"strings"
+	"time"
 )
 
 type AWSCreds struct {
 	AWS_ACCESS_KEY_ID     string `json:"AWS_ACCESS_KEY_ID"`
 	AWS_SECRET_ACCESS_KEY string `json:"AWS_SECRET_ACCESS_KEY"`
 	AWS_S3_BUCKET         string `json:"AWS_S3_BUCKET"`
-}
 
-type AWSConfig struct {
-	Accounts        []AWSCreds `json:"accounts"`
-	BucketAllowList []string   `json:"bucket_allow_list"`
+	// Optional connection settings, when omitted the account is served from AWS S3 in us-east-1.
+	// Setting an endpoint allows S3-compatible services (MinIO, Ceph, Wasabi...) to be served
+	// side by side with AWS accounts.
+	Name               string `json:"name"`
+	Endpoint           string `json:"endpoint"`
+	Region             string `json:"region"`
+	ForcePathStyle     bool   `json:"force_path_style"`
+	DisableSSL         bool   `json:"disable_ssl"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	CABundle           string `json:"ca_bundle"`
+	// PublicEndpoint is the endpoint presigned URLs point at, for services reached through a
+	// proxy or a different host name than the one the API uses
+	PublicEndpoint string `json:"public_endpoint"`
+
+	// CredentialSource selects where the account's credentials come from, one of
+	// "static" (the default, AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY above), "default" (the AWS
+	// default provider chain: environment, shared config, ECS task or EC2 instance role),
+	// "profile" (a named profile from the shared config files) or "assume_role".
+	CredentialSource string `json:"credential_source"`
+	Profile          string `json:"profile"`
+	// The role assumed when CredentialSource is "assume_role", using the static keys if they
+	// are set, the named profile if it is set, otherwise the default provider chain.
+	RoleARN         string `json:"role_arn"`
+	ExternalID      string `json:"external_id"`
+	SessionDuration string `json:"session_duration"`
 }
 
-type MinioConfig struct {
-	S3Endpoint      string `json:"MINIO_S3_ENDPOINT"`
-	DisableSSL      string `json:"MINIO_S3_DISABLE_SSL"`
-	ForcePathStyle  string `json:"MINIO_S3_FORCE_PATH_STYLE"`
-	AccessKeyID     string `json:"MINIO_ACCESS_KEY_ID"`
-	SecretAccessKey string `json:"MINIO_SECRET_ACCESS_KEY"`
-	Bucket          string `json:"AWS_S3_BUCKET"`
-	S3Mock          string `json:"S3_MOCK"`
+const (
+	credentialSourceStatic     = "static"
+	credentialSourceDefault    = "default"
+	credentialSourceProfile    = "profile"
+	credentialSourceAssumeRole = "assume_role"
+)
+
+// source returns the credential source of the account, accounts without one use static keys.
+func (creds AWSCreds) source() string {
+	if creds.CredentialSource == "" {
+		return credentialSourceStatic
+	}
+	return creds.CredentialSource
 }
 
-func (creds MinioConfig) validateMinioConfig() error {
-	missingFields := []string{}
-	if creds.S3Endpoint == "" {
-		missingFields = append(missingFields, "S3Endpoint")
+// sessionDuration returns the parsed session_duration, zero if it is not set.
+func (creds AWSCreds) sessionDuration() (time.Duration, error) {
+	if creds.SessionDuration == "" {
+		return 0, nil
 	}
-	if creds.DisableSSL == "" {
-		missingFields = append(missingFields, "DisableSSL")
+	d, err := time.ParseDuration(creds.SessionDuration)
+	if err != nil {
+		return 0, fmt.Errorf("invalid session_duration `%s`, expected a duration such as 1h: %s", creds.SessionDuration, err.Error())
 	}
-	if creds.ForcePathStyle == "" {
-		missingFields = append(missingFields, "ForcePathStyle")
+	// limits enforced by STS AssumeRole
+	if d < 15*time.Minute || d > 12*time.Hour {
+		return 0, fmt.Errorf("session_duration must be between 15m and 12h, got %s", creds.SessionDuration)
 	}
-	if creds.AccessKeyID == "" {
-		missingFields = append(missingFields, "AccessKeyID")
+	return d, nil
+}
+
+// displayName returns the name used for the account in logs and responses, the name from
+// .env.json if set, otherwise the endpoint host or the account's position in the file.
+func (creds AWSCreds) displayName(i int) string {
+	if creds.Name != "" {
+		return creds.Name
 	}
-	if creds.SecretAccessKey == "" {
-		missingFields = append(missingFields, "SecretAccessKey")
+	if u, err := url.Parse(creds.Endpoint); err == nil && u.Host != "" {
+		return u.Host
 	}
+	return fmt.Sprintf("account %d", i+1)
+}
 
-	if len(missingFields) > 0 {
-		return fmt.Errorf("missing fields: %s", strings.Join(missingFields, ", "))
-	}
-	return nil
+type AWSConfig struct {
+	Accounts        []AWSCreds `json:"accounts"`
+	BucketAllowList []string   `json:"bucket_allow_list"`
 }
 
-func validateEnvJSON(filePath string) error {
+// ValidateEnvJSON checks that the .env.json file at filePath defines usable accounts and a bucket allow list.
+func ValidateEnvJSON(filePath string) error {
 	// Read the contents of the .env.json file
 	jsonData, err := os.ReadFile(filePath)
 	if err != nil {
@@ -73,16 +111,53 @@ func validateEnvJSON(filePath string) error {
 	// Check if each account has the required fields
 	for i, account := range awsConfig.Accounts {
 		missingFields := []string{}
-		if account.AWS_ACCESS_KEY_ID == "" {
-			missingFields = append(missingFields, "AWS_ACCESS_KEY_ID")
-		}
-		if account.AWS_SECRET_ACCESS_KEY == "" {
-			missingFields = append(missingFields, "AWS_SECRET_ACCESS_KEY")
+		switch account.source() {
+		case credentialSourceStatic:
+			if account.AWS_ACCESS_KEY_ID == "" {
+				missingFields = append(missingFields, "AWS_ACCESS_KEY_ID")
+			}
+			if account.AWS_SECRET_ACCESS_KEY == "" {
+				missingFields = append(missingFields, "AWS_SECRET_ACCESS_KEY")
+			}
+		case credentialSourceDefault:
+		case credentialSourceProfile:
+			if account.Profile == "" {
+				missingFields = append(missingFields, "profile")
+			}
+		case credentialSourceAssumeRole:
+			if account.RoleARN == "" {
+				missingFields = append(missingFields, "role_arn")
+			}
+			if (account.AWS_ACCESS_KEY_ID == "") != (account.AWS_SECRET_ACCESS_KEY == "") {
+				missingFields = append(missingFields, "AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set together")
+			}
+			if _, err := account.sessionDuration(); err != nil {
+				return fmt.Errorf("AWS account %d in envJson file: %s", i+1, err.Error())
+			}
+		default:
+			return fmt.Errorf("unknown credential_source `%s` for AWS account %d in envJson file, expected one of static, default, profile or assume_role", account.CredentialSource, i+1)
 		}
 
 		if len(missingFields) > 0 {
 			return fmt.Errorf("missing fields (%s) for AWS account %d in envJson file", strings.Join(missingFields, ", "), i+1)
 		}
+		if account.Endpoint != "" {
+			u, err := url.Parse(account.Endpoint)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("invalid endpoint `%s` for AWS account %d in envJson file, expected a URL such as https://s3.example.com", account.Endpoint, i+1)
+			}
+		}
+		if account.PublicEndpoint != "" {
+			u, err := url.Parse(account.PublicEndpoint)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("invalid public_endpoint `%s` for AWS account %d in envJson file, expected a URL such as https://s3.example.com", account.PublicEndpoint, i+1)
+			}
+		}
+		if account.CABundle != "" {
+			if _, err := os.Stat(account.CABundle); err != nil {
+				return fmt.Errorf("ca_bundle for AWS account %d in envJson file is not readable: %s", i+1, err.Error())
+			}
+		}
 	}
 	if len(awsConfig.BucketAllowList) == 0 {
 		return fmt.Errorf("no buckets in the `bucket_allow_list`, please provide required buckets, or `*` for access to all buckets")
@@ -93,7 +168,7 @@ func validateEnvJSON(filePath string) error {
 
 func newAWSConfig(envJson string) (AWSConfig, error) {
 	var awsConfig AWSConfig
-	err := validateEnvJSON(envJson)
+	err := ValidateEnvJSON(envJson)
 	if err != nil {
 		return awsConfig, fmt.Errorf(err.Error())
 	}
@@ -107,14 +182,3 @@ func newAWSConfig(envJson string) (AWSConfig, error) {
 	}
 	return awsConfig, nil
 }
-
-func newMinioConfig() MinioConfig {
-	var mc MinioConfig
-	mc.S3Endpoint = os.Getenv("MINIO_S3_ENDPOINT")
-	mc.DisableSSL = os.Getenv("MINIO_S3_DISABLE_SSL")
-	mc.ForcePathStyle = os.Getenv("MINIO_S3_FORCE_PATH_STYLE")
-	mc.AccessKeyID = os.Getenv("MINIO_ACCESS_KEY_ID")
-	mc.Bucket = os.Getenv("AWS_S3_BUCKET")
-	mc.SecretAccessKey = os.Getenv("MINIO_SECRET_ACCESS_KEY")
-	return mc
-}
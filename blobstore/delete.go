@@ -22,7 +22,7 @@ func (s3Ctrl *S3Controller) DeleteList(page *s3.ListObjectsV2Output, bucket stri
 	}
 
 	// Perform the delete operation for the current page
-	_, err := s3Ctrl.S3Svc.DeleteObjects(&s3.DeleteObjectsInput{
+	_, err := s3Ctrl.Store.DeleteObjects(&s3.DeleteObjectsInput{
 		Bucket: aws.String(bucket),
 		Delete: &s3.Delete{
 			Objects: objectsToDelete,
@@ -98,7 +98,7 @@ func (bh *BlobHandler) HandleDeleteObject(c echo.Context) error {
 		Key:    aws.String(key),
 	}
 
-	_, err = s3Ctrl.S3Svc.DeleteObject(deleteInput)
+	_, err = s3Ctrl.Store.DeleteObject(deleteInput)
 	if err != nil {
 		errMsg := fmt.Errorf("error deleting object. %s", err.Error())
 		log.Error(errMsg.Error())
@@ -168,7 +168,7 @@ func (s3Ctrl *S3Controller) DeleteKeys(bucket string, key []string) error {
 		},
 	}
 
-	_, err := s3Ctrl.S3Svc.DeleteObjects(input)
+	_, err := s3Ctrl.Store.DeleteObjects(input)
 	if err != nil {
 		return fmt.Errorf("error deleting objects: %s", err.Error())
 	}
@@ -0,0 +1,29 @@
+package blobstore_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestDeleteObjectsByList(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	for _, key := range []string{"a.txt", "dir/b.txt", "dir/c.txt"} {
+		srv.PutObject("bkt", key, []byte(key))
+	}
+
+	body := map[string][]string{"keys": {"/a.txt", "dir/b.txt"}}
+	decode(t, serve(t, bh.HandleDeleteObjectsByList, http.MethodDelete, "/delete_keys?bucket=bkt", body), http.StatusOK, nil)
+	if got := srv.Keys("bkt"); !reflect.DeepEqual(got, []string{"dir/c.txt"}) {
+		t.Fatalf("got keys %v after delete", got)
+	}
+
+	// nothing is deleted when one of the keys is missing
+	body = map[string][]string{"keys": {"dir/c.txt", "dir/missing.txt"}}
+	decode(t, serve(t, bh.HandleDeleteObjectsByList, http.MethodDelete, "/delete_keys?bucket=bkt", body), http.StatusNotFound, nil)
+	if got := srv.Keys("bkt"); len(got) != 1 {
+		t.Fatalf("got keys %v after a failed delete", got)
+	}
+	body = map[string][]string{"keys": {}}
+	decode(t, serve(t, bh.HandleDeleteObjectsByList, http.MethodDelete, "/delete_keys?bucket=bkt", body), http.StatusUnprocessableEntity, nil)
+}
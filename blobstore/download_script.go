@@ -0,0 +1,183 @@
+package blobstore
+
+import (
+	"fmt"
+	"strings"
+)
+
+// scriptFormat renders a download script for one platform or tool. Every format skips files that
+// were already downloaded completely, resumes the ones that were cut short and, except for the plain
+// URL list, checks the size and sha256 of the manifest entry once a file is downloaded.
+type scriptFormat struct {
+	// suffix is appended to the prefix to name the script
+	suffix      string
+	contentType string
+	header      func(name string) string
+	// file downloads the object of entry to path, relative to where the script is run
+	file   func(path string, entry manifestEntry) string
+	footer string
+	// crlf ends the lines with \r\n, cmd.exe can't find the labels of a batch file otherwise
+	crlf bool
+}
+
+// render returns the script built from the header, file lines and footer in script.
+func (f scriptFormat) render(script string) []byte {
+	if f.crlf {
+		script = strings.ReplaceAll(script, "\n", "\r\n")
+	}
+	return []byte(script)
+}
+
+const defaultScriptFormat = "bat"
+
+var scriptFormats = map[string]scriptFormat{
+	"bash": {
+		suffix:      "_download_script.sh",
+		contentType: "text/x-shellscript",
+		header: func(name string) string {
+			return "#!/usr/bin/env bash\n" +
+				"# Download Instructions\n" +
+				"# Move this script to the directory the files should be downloaded to and run:\n" +
+				fmt.Sprintf("#   bash %s\n", bashQuote(name)) +
+				"# Files that already exist are skipped, partial files are resumed, so the script can be run again after an interruption.\n\n" +
+				"set -e\n" +
+				"failed=0\n\n" +
+				"verify() {\n" +
+				"  size=\"$(wc -c < \"$1\" | tr -d ' ')\"\n" +
+				"  if [ \"$size\" -ne \"$2\" ]; then\n" +
+				"    echo \"size mismatch for $1: expected $2 bytes, got $size\" >&2\n" +
+				"    return 1\n" +
+				"  fi\n" +
+				"  if [ -n \"$3\" ]; then\n" +
+				"    if command -v sha256sum > /dev/null; then sum=\"$(sha256sum \"$1\" | cut -d ' ' -f 1)\"; else sum=\"$(shasum -a 256 \"$1\" | cut -d ' ' -f 1)\"; fi\n" +
+				"    if [ \"$sum\" != \"$3\" ]; then\n" +
+				"      echo \"checksum mismatch for $1\" >&2\n" +
+				"      return 1\n" +
+				"    fi\n" +
+				"  fi\n" +
+				"}\n\n" +
+				"download() {\n" +
+				"  if [ -f \"$1\" ] && [ \"$(wc -c < \"$1\" | tr -d ' ')\" -eq \"$2\" ]; then\n" +
+				"    echo \"skipping existing file $1\"\n" +
+				"    return\n" +
+				"  fi\n" +
+				"  curl -fL --retry 3 --create-dirs -C - -o \"$1\" \"$4\"\n" +
+				"  verify \"$1\" \"$2\" \"$3\" || failed=$((failed + 1))\n" +
+				"}\n\n"
+		},
+		file: func(path string, entry manifestEntry) string {
+			return fmt.Sprintf("download %s %d %s %s\n", bashQuote(path), entry.Size, bashQuote(entry.SHA256), bashQuote(entry.URL))
+		},
+		footer: "\nif [ \"$failed\" -gt 0 ]; then\n" +
+			"  echo \"$failed file(s) failed verification\" >&2\n" +
+			"  exit 1\n" +
+			"fi\n",
+	},
+	"powershell": {
+		suffix:      "_download_script.ps1",
+		contentType: "text/plain; charset=utf-8",
+		header: func(name string) string {
+			return "# Download Instructions\n" +
+				"# Move this script to the directory the files should be downloaded to and run:\n" +
+				fmt.Sprintf("#   powershell -ExecutionPolicy Bypass -File \".\\%s\"\n", name) +
+				"# Files that already exist are skipped, partial files are resumed, so the script can be run again after an interruption.\n\n" +
+				"$ErrorActionPreference = 'Stop'\n" +
+				"$failed = 0\n\n" +
+				"function Save-Object([string]$Path, [long]$Size, [string]$Sha256, [string]$Url) {\n" +
+				"    if ((Test-Path -LiteralPath $Path) -and ((Get-Item -LiteralPath $Path).Length -eq $Size)) {\n" +
+				"        Write-Host \"skipping existing file $Path\"\n" +
+				"        return\n" +
+				"    }\n" +
+				"    curl.exe -fL --retry 3 --create-dirs -C - -o $Path $Url\n" +
+				"    if ($LASTEXITCODE -ne 0) { throw \"error downloading $Path\" }\n" +
+				"    $actual = (Get-Item -LiteralPath $Path).Length\n" +
+				"    if ($actual -ne $Size) {\n" +
+				"        Write-Warning \"size mismatch for ${Path}: expected $Size bytes, got $actual\"\n" +
+				"        $script:failed++\n" +
+				"    } elseif ($Sha256 -and (Get-FileHash -LiteralPath $Path -Algorithm SHA256).Hash -ne $Sha256) {\n" +
+				"        Write-Warning \"checksum mismatch for $Path\"\n" +
+				"        $script:failed++\n" +
+				"    }\n" +
+				"}\n\n"
+		},
+		file: func(path string, entry manifestEntry) string {
+			return fmt.Sprintf("Save-Object %s %d %s %s\n", powershellQuote(path), entry.Size, powershellQuote(entry.SHA256), powershellQuote(entry.URL))
+		},
+		footer: "\nif ($failed -gt 0) {\n" +
+			"    Write-Error \"$failed file(s) failed verification\"\n" +
+			"    exit 1\n" +
+			"}\n",
+	},
+	"bat": {
+		suffix:      "_download_script.bat",
+		contentType: "application/x-bat",
+		header: func(name string) string {
+			return "@echo off\n" +
+				"REM Download Instructions\n" +
+				"REM 1. Move this script to the directory the files should be downloaded to.\n" +
+				fmt.Sprintf("REM 2. Double-click %s to start the download. If Windows Defender SmartScreen prevents it from starting, click \"More info\" and then \"Run anyway\".\n", name) +
+				"REM Files that already exist are skipped, partial files are resumed, so the script can be run again after an interruption.\n\n" +
+				"set failed=0\n"
+		},
+		file: func(path string, entry manifestEntry) string {
+			path = batchQuote(strings.ReplaceAll(path, "/", "\\"))
+			return fmt.Sprintf("call :skip %s %d || curl -fL --retry 3 --create-dirs -C - -o %s %s && call :verify %s %d \"%s\"\n",
+				path, entry.Size, path, batchQuote(entry.URL), path, entry.Size, entry.SHA256)
+		},
+		footer: "if %failed% gtr 0 (echo %failed% files failed verification& exit /b 1)\n" +
+			"exit /b 0\n\n" +
+			":skip\n" +
+			"if exist \"%~1\" if \"%~z1\"==\"%~2\" (echo skipping existing file %~1& exit /b 0)\n" +
+			"exit /b 1\n\n" +
+			":verify\n" +
+			"if not \"%~z1\"==\"%~2\" (echo size mismatch for %~1& set /a failed+=1& exit /b 1)\n" +
+			"if \"%~3\"==\"\" exit /b 0\n" +
+			"set \"hash=\"\n" +
+			"for /f \"delims=\" %%h in ('certutil -hashfile \"%~1\" SHA256 ^| findstr /v \":\"') do if not defined hash set \"hash=%%h\"\n" +
+			"set \"hash=%hash: =%\"\n" +
+			"if /i not \"%hash%\"==\"%~3\" (echo checksum mismatch for %~1& set /a failed+=1& exit /b 1)\n" +
+			"exit /b 0\n",
+		crlf: true,
+	},
+	"aria2": {
+		suffix:      "_aria2_input.txt",
+		contentType: "text/plain; charset=utf-8",
+		header: func(name string) string {
+			return "# Download Instructions\n" +
+				"# In the directory the files should be downloaded to, run:\n" +
+				fmt.Sprintf("#   aria2c --input-file=\"%s\" --continue=true --auto-file-renaming=false\n", name) +
+				"# Files that already exist are skipped, partial files are resumed and files with a sha256 are verified.\n\n"
+		},
+		file: func(path string, entry manifestEntry) string {
+			line := fmt.Sprintf("%s\n  out=%s\n", entry.URL, path)
+			if entry.SHA256 != "" {
+				line += fmt.Sprintf("  checksum=sha-256=%s\n", entry.SHA256)
+			}
+			return line
+		},
+	},
+	"urls": {
+		suffix:      "_urls.txt",
+		contentType: "text/plain; charset=utf-8",
+		header:      func(name string) string { return "" },
+		file: func(path string, entry manifestEntry) string {
+			return entry.URL + "\n"
+		},
+	},
+}
+
+// scriptFormatNames lists the formats in the order they are documented.
+var scriptFormatNames = []string{"bash", "powershell", "bat", "aria2", "urls"}
+
+func bashQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func powershellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// batchQuote quotes s for a batch file, where % starts a variable even inside quotes.
+func batchQuote(s string) string {
+	return "\"" + strings.ReplaceAll(s, "%", "%%") + "\""
+}
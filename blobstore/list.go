@@ -238,7 +238,7 @@ func (s3Ctrl *S3Controller) GetList(bucket, prefix string, delimiter bool) (*s3.
 	}
 	// Retrieve the list of objects in the bucket with the specified prefix
 	var response *s3.ListObjectsV2Output
-	err := s3Ctrl.S3Svc.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, _ bool) bool {
+	err := s3Ctrl.Store.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, _ bool) bool {
 		if response == nil {
 			response = page
 		} else {
@@ -277,7 +277,7 @@ func (s3Ctrl *S3Controller) GetListWithCallBack(bucket, prefix string, delimiter
 	var lastError error // Variable to capture the last error
 
 	// Iterate over the pages of results
-	err := s3Ctrl.S3Svc.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, _ bool) bool {
+	err := s3Ctrl.Store.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, _ bool) bool {
 		lastError = processPage(page)
 		return lastError == nil && *page.IsTruncated // Continue if no error and more pages are available
 	})
@@ -0,0 +1,56 @@
+package blobstore_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestListByPrefixContinuation(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	// more keys than fit in one page of ListObjectsV2
+	const n = 1203
+	for i := 0; i < n; i++ {
+		srv.PutObject("bkt", fmt.Sprintf("data/file-%04d.txt", i), []byte("x"))
+	}
+	srv.PutObject("bkt", "data/nested/a.txt", []byte("a"))
+	srv.PutObject("bkt", "other/b.txt", []byte("b"))
+
+	var keys []string
+	decode(t, serve(t, bh.HandleListByPrefix, http.MethodGet, "/prefix/list?bucket=bkt&prefix=data&delimiter=false", nil), http.StatusOK, &keys)
+	if len(keys) != n+1 {
+		t.Fatalf("got %d keys, want %d", len(keys), n+1)
+	}
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if seen[k] {
+			t.Fatalf("%s listed twice", k)
+		}
+		seen[k] = true
+	}
+	if !seen["data/file-1202.txt"] || !seen["data/nested/a.txt"] || seen["other/b.txt"] {
+		t.Fatalf("unexpected listing, last keys %v", keys[len(keys)-3:])
+	}
+
+	keys = nil
+	decode(t, serve(t, bh.HandleListByPrefix, http.MethodGet, "/prefix/list?bucket=bkt&prefix=data/", nil), http.StatusOK, &keys)
+	seen = make(map[string]bool)
+	for _, k := range keys {
+		seen[k] = true
+	}
+	if len(keys) != n+1 || !seen["data/nested/"] || seen["data/nested/a.txt"] {
+		t.Fatalf("got %d entries, want %d with the common prefix data/nested/ in place of its keys", len(keys), n+1)
+	}
+}
+
+func TestListByPrefixErrors(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	srv.PutObject("bkt", "data/a.txt", []byte("a"))
+
+	rec := serve(t, bh.HandleListByPrefix, http.MethodGet, "/prefix/list?bucket=missing&prefix=data", nil)
+	decode(t, rec, http.StatusUnprocessableEntity, nil)
+	rec = serve(t, bh.HandleListByPrefix, http.MethodGet, "/prefix/list?bucket=bkt&prefix=data/a.txt", nil)
+	decode(t, rec, http.StatusTeapot, nil)
+	rec = serve(t, bh.HandleListByPrefix, http.MethodGet, "/prefix/list?bucket=bkt&prefix=data&delimiter=maybe", nil)
+	decode(t, rec, http.StatusUnprocessableEntity, nil)
+}
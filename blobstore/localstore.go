@@ -0,0 +1,1387 @@
+package blobstore
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// LocalStoreRoutePrefix is the route under which the API serves the presigned URLs of a LocalStore.
+const LocalStoreRoutePrefix = "/local_store"
+
+const (
+	// localStoreSysDir holds object metadata, multipart sessions and temp files. It is hidden so it is never listed as a bucket
+	localStoreSysDir = ".s3api"
+	// query parameters carried by the presigned URLs of a LocalStore
+	localExpiresParam   = "X-S3api-Expires"
+	localSignatureParam = "X-S3api-Signature"
+)
+
+// LocalStore is an ObjectStore that serves a plain directory tree, meant for offline development.
+// Every top level directory under Root is a bucket and the files below it are the objects.
+// Presigned URLs point back at the API (see ServeHTTP) and are signed with a secret only the API knows.
+type LocalStore struct {
+	Root string
+	// BaseURL is the public URL the store's ServeHTTP is mounted at, used to build presigned URLs
+	BaseURL string
+	secret  []byte
+	mu      sync.Mutex
+}
+
+// localObjectMeta is the sidecar record kept for every object written through the store.
+type localObjectMeta struct {
+	ContentType string            `json:"content_type,omitempty"`
+	ETag        string            `json:"etag,omitempty"`
+	ModTime     time.Time         `json:"mod_time"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// localUpload describes a multipart upload session.
+type localUpload struct {
+	Bucket      string            `json:"bucket"`
+	Key         string            `json:"key"`
+	Initiated   time.Time         `json:"initiated"`
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// localEntry is an object found while walking a bucket.
+type localEntry struct {
+	key  string
+	info fs.FileInfo
+}
+
+func NewLocalStore(root, baseURL, secret string) (*LocalStore, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving local storage root %s: %s", root, err.Error())
+	}
+	info, err := os.Stat(absRoot)
+	if err != nil {
+		return nil, fmt.Errorf("error reading local storage root %s: %s", absRoot, err.Error())
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("local storage root %s is not a directory", absRoot)
+	}
+	if baseURL == "" {
+		return nil, fmt.Errorf("a base URL is required to presign local storage URLs")
+	}
+
+	ls := &LocalStore{Root: absRoot, BaseURL: strings.TrimSuffix(baseURL, "/")}
+	if secret != "" {
+		ls.secret = []byte(secret)
+	} else {
+		// URLs signed with a random secret stop working when the API restarts, which is fine for development
+		ls.secret = make([]byte, 32)
+		if _, err := rand.Read(ls.secret); err != nil {
+			return nil, fmt.Errorf("error generating local storage secret: %s", err.Error())
+		}
+	}
+	for _, dir := range []string{"meta", "uploads", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(absRoot, localStoreSysDir, dir), 0755); err != nil {
+			return nil, fmt.Errorf("error creating local storage directories: %s", err.Error())
+		}
+	}
+	return ls, nil
+}
+
+func localError(code string, status int, format string, a ...interface{}) error {
+	return awserr.NewRequestFailure(awserr.New(code, fmt.Sprintf(format, a...), nil), status, "")
+}
+
+// digestReader hashes what is read through it and fails with BadDigest at the end of the body
+// when it doesn't match the base64 Content-MD5 or x-amz-checksum-sha256 the client sent.
+type digestReader struct {
+	r          io.Reader
+	md5        hash.Hash
+	sha256     hash.Hash
+	wantMD5    string
+	wantSHA256 string
+}
+
+// newDigestReader returns body unchanged when no checksum was sent.
+func newDigestReader(body io.Reader, contentMD5, checksumSHA256 string) io.Reader {
+	if contentMD5 == "" && checksumSHA256 == "" {
+		return body
+	}
+	if body == nil {
+		body = strings.NewReader("")
+	}
+	return &digestReader{r: body, md5: md5.New(), sha256: sha256.New(), wantMD5: contentMD5, wantSHA256: checksumSHA256}
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.md5.Write(p[:n])
+	d.sha256.Write(p[:n])
+	if err == io.EOF {
+		if d.wantMD5 != "" && base64.StdEncoding.EncodeToString(d.md5.Sum(nil)) != d.wantMD5 {
+			return n, localError("BadDigest", http.StatusBadRequest, "the Content-MD5 you specified did not match what we received")
+		}
+		if d.wantSHA256 != "" && base64.StdEncoding.EncodeToString(d.sha256.Sum(nil)) != d.wantSHA256 {
+			return n, localError("BadDigest", http.StatusBadRequest, "the SHA256 you specified did not match what we received")
+		}
+	}
+	return n, err
+}
+
+// sizeRangeReader fails once more than max bytes were read, or at the end of the body when fewer
+// than min were, a negative max doesn't limit the size.
+type sizeRangeReader struct {
+	r        io.Reader
+	n        int64
+	min, max int64
+}
+
+func (s *sizeRangeReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.n += int64(n)
+	if s.max >= 0 && s.n > s.max {
+		return n, localError("EntityTooLarge", http.StatusBadRequest, "your proposed upload exceeds the maximum allowed size of %d bytes", s.max)
+	}
+	if err == io.EOF && s.n < s.min {
+		return n, localError("EntityTooSmall", http.StatusBadRequest, "your proposed upload is smaller than the minimum allowed size of %d bytes", s.min)
+	}
+	return n, err
+}
+
+func (ls *LocalStore) bucketPath(bucket string) (string, error) {
+	if bucket == "" || strings.HasPrefix(bucket, ".") || strings.ContainsAny(bucket, `/\`) {
+		return "", localError(s3.ErrCodeNoSuchBucket, http.StatusNotFound, "invalid bucket name %s", bucket)
+	}
+	p := filepath.Join(ls.Root, bucket)
+	info, err := os.Stat(p)
+	if err != nil || !info.IsDir() {
+		return "", localError(s3.ErrCodeNoSuchBucket, http.StatusNotFound, "bucket %s does not exist", bucket)
+	}
+	return p, nil
+}
+
+// objectPath maps a key onto the filesystem, refusing keys that would escape the bucket directory.
+func (ls *LocalStore) objectPath(bucket, key string) (string, error) {
+	bucketDir, err := ls.bucketPath(bucket)
+	if err != nil {
+		return "", err
+	}
+	if key == "" || !safeKeyPath(key) {
+		return "", localError("InvalidArgument", http.StatusBadRequest, "invalid key %q", key)
+	}
+	return filepath.Join(bucketDir, filepath.FromSlash(key)), nil
+}
+
+// safeKeyPath reports whether a key, or the directory part of a prefix, stays inside the bucket
+// directory once it is mapped onto the filesystem.
+func safeKeyPath(key string) bool {
+	if strings.Contains(key, `\`) {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." || segment == "." {
+			return false
+		}
+	}
+	return true
+}
+
+func (ls *LocalStore) metaPath(bucket, key string) string {
+	return filepath.Join(ls.Root, localStoreSysDir, "meta", bucket, filepath.FromSlash(key)+".json")
+}
+
+func (ls *LocalStore) uploadDir(uploadID string) (string, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", localError(s3.ErrCodeNoSuchUpload, http.StatusNotFound, "upload %s does not exist", uploadID)
+	}
+	return filepath.Join(ls.Root, localStoreSysDir, "uploads", uploadID), nil
+}
+
+// statObject returns the file info of an object together with its metadata.
+func (ls *LocalStore) statObject(bucket, key string) (string, fs.FileInfo, localObjectMeta, error) {
+	var meta localObjectMeta
+	p, err := ls.objectPath(bucket, key)
+	if err != nil {
+		return "", nil, meta, err
+	}
+	info, err := os.Stat(p)
+	if err != nil || info.IsDir() {
+		return "", nil, meta, localError("NotFound", http.StatusNotFound, "object %s does not exist", key)
+	}
+	return p, info, ls.readMeta(bucket, key, info), nil
+}
+
+// readMeta loads the sidecar metadata of an object, falling back to values derived
+// from the file itself for files that were not written through the store.
+func (ls *LocalStore) readMeta(bucket, key string, info fs.FileInfo) localObjectMeta {
+	var meta localObjectMeta
+	if data, err := os.ReadFile(ls.metaPath(bucket, key)); err == nil {
+		if err := json.Unmarshal(data, &meta); err != nil {
+			log.Warnf("ignoring unreadable metadata of %s/%s: %s", bucket, key, err.Error())
+			meta = localObjectMeta{}
+		}
+	}
+	// the file was replaced outside of the store, the recorded ETag no longer applies
+	if !meta.ModTime.Equal(info.ModTime()) {
+		meta.ETag = ""
+	}
+	if meta.ETag == "" {
+		meta.ETag = fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size())
+	}
+	if meta.ContentType == "" {
+		meta.ContentType = mime.TypeByExtension(filepath.Ext(key))
+		if meta.ContentType == "" {
+			meta.ContentType = "binary/octet-stream"
+		}
+	}
+	return meta
+}
+
+// writeObject atomically writes an object from the content produced by write and records its metadata.
+func (ls *LocalStore) writeObject(bucket, key string, meta localObjectMeta, write func(io.Writer) error) (localObjectMeta, error) {
+	p, err := ls.objectPath(bucket, key)
+	if err != nil {
+		return meta, err
+	}
+	// a key with a trailing slash is a directory marker
+	if strings.HasSuffix(key, "/") {
+		return meta, os.MkdirAll(p, 0755)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(ls.Root, localStoreSysDir, "tmp"), "object-")
+	if err != nil {
+		return meta, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := md5.New()
+	if err := write(io.MultiWriter(tmp, hash)); err != nil {
+		tmp.Close()
+		return meta, err
+	}
+	if err := tmp.Close(); err != nil {
+		return meta, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return meta, err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return meta, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return meta, err
+	}
+
+	meta.ETag = fmt.Sprintf("\"%s\"", hex.EncodeToString(hash.Sum(nil)))
+	meta.ModTime = info.ModTime()
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return meta, err
+	}
+	mp := ls.metaPath(bucket, key)
+	if err := os.MkdirAll(filepath.Dir(mp), 0755); err != nil {
+		return meta, err
+	}
+	if err := os.WriteFile(mp, data, 0644); err != nil {
+		return meta, err
+	}
+	return ls.readMeta(bucket, key, info), nil
+}
+
+// removeEmptyParents deletes the directories left empty after removing a file, so that prefixes
+// disappear with their last object like they do in S3.
+func removeEmptyParents(dir, stop string) {
+	for dir != stop && strings.HasPrefix(dir, stop) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// walkKeys returns the objects of a bucket that start with prefix, sorted by key.
+func (ls *LocalStore) walkKeys(bucketDir, prefix string) ([]localEntry, error) {
+	var entries []localEntry
+	startDir := bucketDir
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		if !safeKeyPath(prefix[:i]) {
+			return nil, localError("InvalidArgument", http.StatusBadRequest, "invalid prefix %q", prefix)
+		}
+		startDir = filepath.Join(bucketDir, filepath.FromSlash(prefix[:i]))
+	}
+	// the walk never leaves the bucket, whatever the prefix resolved to
+	if rel, err := filepath.Rel(bucketDir, startDir); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, localError("InvalidArgument", http.StatusBadRequest, "invalid prefix %q", prefix)
+	}
+	if _, err := os.Stat(startDir); err != nil {
+		return entries, nil
+	}
+
+	err := filepath.WalkDir(startDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(bucketDir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if d.IsDir() {
+			if p != startDir && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, localEntry{key: key, info: info})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return entries, nil
+}
+
+func (ls *LocalStore) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	bucket := aws.StringValue(input.Bucket)
+	bucketDir, err := ls.bucketPath(bucket)
+	if err != nil {
+		return err
+	}
+	prefix := aws.StringValue(input.Prefix)
+	delimiter := aws.StringValue(input.Delimiter)
+	maxKeys := int(aws.Int64Value(input.MaxKeys))
+	if maxKeys <= 0 || maxKeys > 1000 {
+		maxKeys = 1000
+	}
+
+	entries, err := ls.walkKeys(bucketDir, prefix)
+	if _, ok := err.(awserr.Error); ok {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("error walking bucket %s: %s", bucket, err.Error())
+	}
+
+	// Fold keys into common prefixes, keeping everything in key order so a name can act as a continuation token
+	type listItem struct {
+		name   string
+		object *localEntry
+	}
+	var items []listItem
+	for i := range entries {
+		if delimiter != "" {
+			rest := strings.TrimPrefix(entries[i].key, prefix)
+			if idx := strings.Index(rest, delimiter); idx >= 0 {
+				cp := prefix + rest[:idx+len(delimiter)]
+				if len(items) == 0 || items[len(items)-1].name != cp {
+					items = append(items, listItem{name: cp})
+				}
+				continue
+			}
+		}
+		items = append(items, listItem{name: entries[i].key, object: &entries[i]})
+	}
+
+	startAfter := aws.StringValue(input.StartAfter)
+	if token := aws.StringValue(input.ContinuationToken); token != "" {
+		startAfter = token
+	}
+	start := sort.Search(len(items), func(i int) bool { return items[i].name > startAfter })
+	items = items[start:]
+
+	token := aws.StringValue(input.ContinuationToken)
+	for {
+		n := len(items)
+		if n > maxKeys {
+			n = maxKeys
+		}
+		page := &s3.ListObjectsV2Output{
+			Name:        aws.String(bucket),
+			Prefix:      aws.String(prefix),
+			MaxKeys:     aws.Int64(int64(maxKeys)),
+			KeyCount:    aws.Int64(int64(n)),
+			IsTruncated: aws.Bool(len(items) > n),
+		}
+		if delimiter != "" {
+			page.Delimiter = aws.String(delimiter)
+		}
+		if token != "" {
+			page.ContinuationToken = aws.String(token)
+		}
+		for _, item := range items[:n] {
+			if item.object == nil {
+				page.CommonPrefixes = append(page.CommonPrefixes, &s3.CommonPrefix{Prefix: aws.String(item.name)})
+				continue
+			}
+			meta := ls.readMeta(bucket, item.object.key, item.object.info)
+			page.Contents = append(page.Contents, &s3.Object{
+				Key:          aws.String(item.object.key),
+				Size:         aws.Int64(item.object.info.Size()),
+				LastModified: aws.Time(item.object.info.ModTime()),
+				ETag:         aws.String(meta.ETag),
+				StorageClass: aws.String("STANDARD"),
+			})
+		}
+		lastPage := len(items) <= n
+		if !lastPage {
+			token = items[n-1].name
+			page.NextContinuationToken = aws.String(token)
+		}
+		if !fn(page, lastPage) || lastPage {
+			return nil
+		}
+		items = items[n:]
+	}
+}
+
+func (ls *LocalStore) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	_, info, meta, err := ls.statObject(aws.StringValue(input.Bucket), aws.StringValue(input.Key))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPreconditions(meta.ETag, info.ModTime(), input.IfMatch, input.IfNoneMatch, input.IfModifiedSince, input.IfUnmodifiedSince); err != nil {
+		return nil, err
+	}
+	return &s3.HeadObjectOutput{
+		AcceptRanges:  aws.String("bytes"),
+		ContentLength: aws.Int64(info.Size()),
+		ContentType:   aws.String(meta.ContentType),
+		ETag:          aws.String(meta.ETag),
+		LastModified:  aws.Time(info.ModTime()),
+		Metadata:      aws.StringMap(meta.Metadata),
+		StorageClass:  aws.String("STANDARD"),
+	}, nil
+}
+
+func (ls *LocalStore) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	key := aws.StringValue(input.Key)
+	p, info, meta, err := ls.statObject(aws.StringValue(input.Bucket), key)
+	if err != nil {
+		return nil, localError(s3.ErrCodeNoSuchKey, http.StatusNotFound, "object %s does not exist", key)
+	}
+	if err := checkPreconditions(meta.ETag, info.ModTime(), input.IfMatch, input.IfNoneMatch, input.IfModifiedSince, input.IfUnmodifiedSince); err != nil {
+		return nil, err
+	}
+	start, length, partial, err := parseByteRange(aws.StringValue(input.Range), info.Size())
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	output := &s3.GetObjectOutput{
+		AcceptRanges:  aws.String("bytes"),
+		Body:          f,
+		ContentLength: aws.Int64(info.Size()),
+		ContentType:   aws.String(meta.ContentType),
+		ETag:          aws.String(meta.ETag),
+		LastModified:  aws.Time(info.ModTime()),
+		Metadata:      aws.StringMap(meta.Metadata),
+		StorageClass:  aws.String("STANDARD"),
+	}
+	if partial {
+		if _, err := f.Seek(start, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		output.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(f, length), f}
+		output.ContentLength = aws.Int64(length)
+		output.ContentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, info.Size()))
+	}
+	return output, nil
+}
+
+// checkPreconditions evaluates conditional request headers against an object the way S3 does:
+// If-Match and If-Unmodified-Since failures answer 412, If-None-Match and If-Modified-Since
+// matches answer 304. A date condition is ignored when its ETag counterpart is present.
+func checkPreconditions(etag string, modTime time.Time, ifMatch, ifNoneMatch *string, ifModifiedSince, ifUnmodifiedSince *time.Time) error {
+	// HTTP dates have a one second resolution
+	modTime = modTime.Truncate(time.Second)
+	if ifMatch != nil {
+		if !etagListMatches(*ifMatch, etag) {
+			return localError("PreconditionFailed", http.StatusPreconditionFailed, "At least one of the pre-conditions you specified did not hold")
+		}
+	} else if ifUnmodifiedSince != nil && modTime.After(*ifUnmodifiedSince) {
+		return localError("PreconditionFailed", http.StatusPreconditionFailed, "At least one of the pre-conditions you specified did not hold")
+	}
+	if ifNoneMatch != nil {
+		if etagListMatches(*ifNoneMatch, etag) {
+			return localError("NotModified", http.StatusNotModified, "Not Modified")
+		}
+	} else if ifModifiedSince != nil && !modTime.After(*ifModifiedSince) {
+		return localError("NotModified", http.StatusNotModified, "Not Modified")
+	}
+	return nil
+}
+
+// etagListMatches reports whether etag is in the comma separated list of an If-Match or
+// If-None-Match header, using the weak comparison S3 applies.
+func etagListMatches(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag || strings.Trim(candidate, `"`) == strings.Trim(etag, `"`) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseByteRange parses a single range Range header against an object of the given size.
+// Like S3, a header that can't be parsed is ignored and the whole object is returned.
+func parseByteRange(spec string, size int64) (start, length int64, partial bool, err error) {
+	unsatisfiable := localError("InvalidRange", http.StatusRequestedRangeNotSatisfiable, "The requested range is not satisfiable")
+	if !strings.HasPrefix(spec, "bytes=") || strings.Contains(spec, ",") {
+		return 0, size, false, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimPrefix(spec, "bytes="), "-")
+	if !ok {
+		return 0, size, false, nil
+	}
+	first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+	if first == "" {
+		// suffix range, the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, size, false, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, unsatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, false, nil
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, size, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, false, unsatisfiable
+	}
+	return start, end - start + 1, true, nil
+}
+
+func (ls *LocalStore) putObject(bucket, key string, body io.Reader, contentType string, metadata map[string]string) (localObjectMeta, error) {
+	meta := localObjectMeta{ContentType: contentType, Metadata: metadata}
+	return ls.writeObject(bucket, key, meta, func(w io.Writer) error {
+		if body == nil {
+			return nil
+		}
+		_, err := io.Copy(w, body)
+		return err
+	})
+}
+
+func (ls *LocalStore) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	var body io.Reader
+	if input.Body != nil {
+		body = input.Body
+	}
+	body = newDigestReader(body, aws.StringValue(input.ContentMD5), aws.StringValue(input.ChecksumSHA256))
+	meta, err := ls.putObject(aws.StringValue(input.Bucket), aws.StringValue(input.Key), body, aws.StringValue(input.ContentType), aws.StringValueMap(input.Metadata))
+	if err != nil {
+		return nil, err
+	}
+	return &s3.PutObjectOutput{ETag: aws.String(meta.ETag)}, nil
+}
+
+// statCopySource returns the path and metadata of the object named by the bucket/key copy source of a copy.
+func (ls *LocalStore) statCopySource(copySource string) (string, fs.FileInfo, localObjectMeta, error) {
+	source, err := url.PathUnescape(strings.TrimPrefix(copySource, "/"))
+	if err != nil {
+		return "", nil, localObjectMeta{}, localError("InvalidArgument", http.StatusBadRequest, "invalid copy source %s", copySource)
+	}
+	srcBucket, srcKey, found := strings.Cut(source, "/")
+	if !found {
+		return "", nil, localObjectMeta{}, localError("InvalidArgument", http.StatusBadRequest, "invalid copy source %s", source)
+	}
+	p, info, meta, err := ls.statObject(srcBucket, srcKey)
+	if err != nil {
+		return "", nil, meta, localError(s3.ErrCodeNoSuchKey, http.StatusNotFound, "object %s does not exist", srcKey)
+	}
+	return p, info, meta, nil
+}
+
+func (ls *LocalStore) CopyObject(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	p, _, meta, err := ls.statCopySource(aws.StringValue(input.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(input.MetadataDirective) == s3.MetadataDirectiveReplace {
+		meta.ContentType = aws.StringValue(input.ContentType)
+		meta.Metadata = aws.StringValueMap(input.Metadata)
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	newMeta, err := ls.writeObject(aws.StringValue(input.Bucket), aws.StringValue(input.Key), meta, func(w io.Writer) error {
+		_, err := io.Copy(w, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3.CopyObjectOutput{CopyObjectResult: &s3.CopyObjectResult{
+		ETag:         aws.String(newMeta.ETag),
+		LastModified: aws.Time(newMeta.ModTime),
+	}}, nil
+}
+
+func (ls *LocalStore) deleteObject(bucket, key string) error {
+	p, err := ls.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	bucketDir := filepath.Join(ls.Root, bucket)
+	// deleting a missing key is not an error in S3
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	removeEmptyParents(filepath.Dir(p), bucketDir)
+
+	mp := ls.metaPath(bucket, key)
+	if err := os.Remove(mp); err == nil {
+		removeEmptyParents(filepath.Dir(mp), filepath.Join(ls.Root, localStoreSysDir, "meta"))
+	}
+	return nil
+}
+
+func (ls *LocalStore) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	if err := ls.deleteObject(aws.StringValue(input.Bucket), aws.StringValue(input.Key)); err != nil {
+		return nil, err
+	}
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (ls *LocalStore) DeleteObjects(input *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	bucket := aws.StringValue(input.Bucket)
+	if _, err := ls.bucketPath(bucket); err != nil {
+		return nil, err
+	}
+	output := &s3.DeleteObjectsOutput{}
+	if input.Delete == nil {
+		return output, nil
+	}
+	for _, obj := range input.Delete.Objects {
+		key := aws.StringValue(obj.Key)
+		if err := ls.deleteObject(bucket, key); err != nil {
+			output.Errors = append(output.Errors, &s3.Error{Key: aws.String(key), Code: aws.String("InternalError"), Message: aws.String(err.Error())})
+			continue
+		}
+		if !aws.BoolValue(input.Delete.Quiet) {
+			output.Deleted = append(output.Deleted, &s3.DeletedObject{Key: aws.String(key)})
+		}
+	}
+	return output, nil
+}
+
+func (ls *LocalStore) readUpload(uploadID, bucket, key string) (string, localUpload, error) {
+	var upload localUpload
+	dir, err := ls.uploadDir(uploadID)
+	if err != nil {
+		return "", upload, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "upload.json"))
+	if err != nil {
+		return "", upload, localError(s3.ErrCodeNoSuchUpload, http.StatusNotFound, "upload %s does not exist", uploadID)
+	}
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return "", upload, err
+	}
+	if upload.Bucket != bucket || upload.Key != key {
+		return "", upload, localError(s3.ErrCodeNoSuchUpload, http.StatusNotFound, "upload %s does not exist for %s/%s", uploadID, bucket, key)
+	}
+	return dir, upload, nil
+}
+
+func (ls *LocalStore) CreateMultipartUpload(input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	bucket, key := aws.StringValue(input.Bucket), aws.StringValue(input.Key)
+	if _, err := ls.objectPath(bucket, key); err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	uploadID := hex.EncodeToString(id)
+	dir, err := ls.uploadDir(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(localUpload{
+		Bucket:      bucket,
+		Key:         key,
+		Initiated:   time.Now().UTC(),
+		ContentType: aws.StringValue(input.ContentType),
+		Metadata:    aws.StringValueMap(input.Metadata),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "upload.json"), data, 0644); err != nil {
+		return nil, err
+	}
+	return &s3.CreateMultipartUploadOutput{Bucket: input.Bucket, Key: input.Key, UploadId: aws.String(uploadID)}, nil
+}
+
+func (ls *LocalStore) uploadPart(bucket, key, uploadID string, partNumber int64, body io.Reader) (string, error) {
+	if partNumber < 1 || partNumber > 10000 {
+		return "", localError("InvalidArgument", http.StatusBadRequest, "part number must be between 1 and 10000")
+	}
+	dir, _, err := ls.readUpload(uploadID, bucket, key)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dir, "part-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := md5.New()
+	if body != nil {
+		if _, err := io.Copy(io.MultiWriter(tmp, hash), body); err != nil {
+			tmp.Close()
+			return "", err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	etag := fmt.Sprintf("\"%s\"", hex.EncodeToString(hash.Sum(nil)))
+	partPath := filepath.Join(dir, fmt.Sprintf("%05d", partNumber))
+	if err := os.Rename(tmp.Name(), partPath+".part"); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(partPath+".etag", []byte(etag), 0644); err != nil {
+		return "", err
+	}
+	return etag, nil
+}
+
+func (ls *LocalStore) UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	var body io.Reader
+	if input.Body != nil {
+		body = input.Body
+	}
+	body = newDigestReader(body, aws.StringValue(input.ContentMD5), aws.StringValue(input.ChecksumSHA256))
+	etag, err := ls.uploadPart(aws.StringValue(input.Bucket), aws.StringValue(input.Key), aws.StringValue(input.UploadId), aws.Int64Value(input.PartNumber), body)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.UploadPartOutput{ETag: aws.String(etag)}, nil
+}
+
+// UploadPartCopy copies the CopySourceRange of an object, or all of it, into a part.
+func (ls *LocalStore) UploadPartCopy(input *s3.UploadPartCopyInput) (*s3.UploadPartCopyOutput, error) {
+	p, info, _, err := ls.statCopySource(aws.StringValue(input.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	start, end := int64(0), info.Size()-1
+	if r := aws.StringValue(input.CopySourceRange); r != "" {
+		if _, err := fmt.Sscanf(r, "bytes=%d-%d", &start, &end); err != nil || start < 0 || end < start || end >= info.Size() {
+			return nil, localError("InvalidArgument", http.StatusBadRequest, "invalid copy source range %s", r)
+		}
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	etag, err := ls.uploadPart(aws.StringValue(input.Bucket), aws.StringValue(input.Key), aws.StringValue(input.UploadId), aws.Int64Value(input.PartNumber), io.NewSectionReader(f, start, end-start+1))
+	if err != nil {
+		return nil, err
+	}
+	return &s3.UploadPartCopyOutput{CopyPartResult: &s3.CopyPartResult{ETag: aws.String(etag)}}, nil
+}
+
+func (ls *LocalStore) CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	bucket, key, uploadID := aws.StringValue(input.Bucket), aws.StringValue(input.Key), aws.StringValue(input.UploadId)
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	dir, upload, err := ls.readUpload(uploadID, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if input.MultipartUpload == nil || len(input.MultipartUpload.Parts) == 0 {
+		return nil, localError("MalformedXML", http.StatusBadRequest, "at least one part is required to complete the upload")
+	}
+
+	var partFiles []string
+	var lastPart int64
+	for _, part := range input.MultipartUpload.Parts {
+		partNumber := aws.Int64Value(part.PartNumber)
+		if partNumber <= lastPart {
+			return nil, localError("InvalidPartOrder", http.StatusBadRequest, "parts must be listed in ascending order")
+		}
+		lastPart = partNumber
+		partPath := filepath.Join(dir, fmt.Sprintf("%05d", partNumber))
+		etag, err := os.ReadFile(partPath + ".etag")
+		if err != nil || strings.Trim(string(etag), "\"") != strings.Trim(aws.StringValue(part.ETag), "\"") {
+			return nil, localError("InvalidPart", http.StatusBadRequest, "part %d was not uploaded or its ETag does not match", partNumber)
+		}
+		partFiles = append(partFiles, partPath+".part")
+	}
+
+	meta, err := ls.writeObject(bucket, key, localObjectMeta{ContentType: upload.ContentType, Metadata: upload.Metadata}, func(w io.Writer) error {
+		for _, partFile := range partFiles {
+			f, err := os.Open(partFile)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(w, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Warnf("error cleaning up multipart upload %s: %s", uploadID, err.Error())
+	}
+	return &s3.CompleteMultipartUploadOutput{
+		Bucket:   input.Bucket,
+		Key:      input.Key,
+		ETag:     aws.String(meta.ETag),
+		Location: aws.String(ls.BaseURL + "/" + bucket + "/" + escapeKey(key)),
+	}, nil
+}
+
+func (ls *LocalStore) AbortMultipartUpload(input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	dir, _, err := ls.readUpload(aws.StringValue(input.UploadId), aws.StringValue(input.Bucket), aws.StringValue(input.Key))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (ls *LocalStore) ListPartsPages(input *s3.ListPartsInput, fn func(*s3.ListPartsOutput, bool) bool) error {
+	dir, _, err := ls.readUpload(aws.StringValue(input.UploadId), aws.StringValue(input.Bucket), aws.StringValue(input.Key))
+	if err != nil {
+		return err
+	}
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	marker := aws.Int64Value(input.PartNumberMarker)
+	// the part files are zero padded, so ReadDir returns them in part number order
+	var parts []*s3.Part
+	for _, d := range dirEntries {
+		partNumber, err := strconv.ParseInt(strings.TrimSuffix(d.Name(), ".part"), 10, 64)
+		if !strings.HasSuffix(d.Name(), ".part") || err != nil || partNumber <= marker {
+			continue
+		}
+		// a part is listed once its ETag is written, after the part itself
+		etag, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%05d.etag", partNumber)))
+		if err != nil {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		parts = append(parts, &s3.Part{
+			PartNumber:   aws.Int64(partNumber),
+			ETag:         aws.String(string(etag)),
+			Size:         aws.Int64(info.Size()),
+			LastModified: aws.Time(info.ModTime()),
+		})
+	}
+
+	maxParts := aws.Int64Value(input.MaxParts)
+	if maxParts <= 0 || maxParts > 1000 {
+		maxParts = 1000
+	}
+	for start := 0; ; start += int(maxParts) {
+		end := start + int(maxParts)
+		if end > len(parts) {
+			end = len(parts)
+		}
+		page := &s3.ListPartsOutput{
+			Bucket:           input.Bucket,
+			Key:              input.Key,
+			UploadId:         input.UploadId,
+			MaxParts:         aws.Int64(maxParts),
+			PartNumberMarker: aws.Int64(marker),
+			Parts:            parts[start:end],
+			IsTruncated:      aws.Bool(end < len(parts)),
+		}
+		if end > start {
+			marker = aws.Int64Value(parts[end-1].PartNumber)
+			page.NextPartNumberMarker = aws.Int64(marker)
+		}
+		if !fn(page, end == len(parts)) || end == len(parts) {
+			return nil
+		}
+	}
+}
+
+func (ls *LocalStore) ListMultipartUploadsPages(input *s3.ListMultipartUploadsInput, fn func(*s3.ListMultipartUploadsOutput, bool) bool) error {
+	bucket, prefix := aws.StringValue(input.Bucket), aws.StringValue(input.Prefix)
+	if _, err := ls.bucketPath(bucket); err != nil {
+		return err
+	}
+	uploadsDir := filepath.Join(ls.Root, localStoreSysDir, "uploads")
+	dirEntries, err := os.ReadDir(uploadsDir)
+	if err != nil {
+		return err
+	}
+	var uploads []*s3.MultipartUpload
+	for _, d := range dirEntries {
+		// uploads completed or aborted meanwhile are skipped
+		data, err := os.ReadFile(filepath.Join(uploadsDir, d.Name(), "upload.json"))
+		if err != nil {
+			continue
+		}
+		var upload localUpload
+		if err := json.Unmarshal(data, &upload); err != nil {
+			continue
+		}
+		if upload.Bucket != bucket || !strings.HasPrefix(upload.Key, prefix) {
+			continue
+		}
+		uploads = append(uploads, &s3.MultipartUpload{
+			Key:       aws.String(upload.Key),
+			UploadId:  aws.String(d.Name()),
+			Initiated: aws.Time(upload.Initiated),
+		})
+	}
+	// like S3, uploads are listed by key and then by the time they were started
+	sort.Slice(uploads, func(i, j int) bool {
+		ki, kj := aws.StringValue(uploads[i].Key), aws.StringValue(uploads[j].Key)
+		if ki != kj {
+			return ki < kj
+		}
+		return aws.TimeValue(uploads[i].Initiated).Before(aws.TimeValue(uploads[j].Initiated))
+	})
+	if keyMarker := aws.StringValue(input.KeyMarker); keyMarker != "" {
+		start := sort.Search(len(uploads), func(i int) bool { return aws.StringValue(uploads[i].Key) > keyMarker })
+		if uploadIDMarker := aws.StringValue(input.UploadIdMarker); uploadIDMarker != "" {
+			for i, upload := range uploads {
+				if aws.StringValue(upload.Key) == keyMarker && aws.StringValue(upload.UploadId) == uploadIDMarker {
+					start = i + 1
+					break
+				}
+			}
+		}
+		uploads = uploads[start:]
+	}
+
+	maxUploads := aws.Int64Value(input.MaxUploads)
+	if maxUploads <= 0 || maxUploads > 1000 {
+		maxUploads = 1000
+	}
+	for start := 0; ; start += int(maxUploads) {
+		end := start + int(maxUploads)
+		if end > len(uploads) {
+			end = len(uploads)
+		}
+		page := &s3.ListMultipartUploadsOutput{
+			Bucket:      input.Bucket,
+			Prefix:      input.Prefix,
+			MaxUploads:  aws.Int64(maxUploads),
+			Uploads:     uploads[start:end],
+			IsTruncated: aws.Bool(end < len(uploads)),
+		}
+		if end > start {
+			page.NextKeyMarker = uploads[end-1].Key
+			page.NextUploadIdMarker = uploads[end-1].UploadId
+		}
+		if !fn(page, end == len(uploads)) || end == len(uploads) {
+			return nil
+		}
+	}
+}
+
+func (ls *LocalStore) ListBuckets(input *s3.ListBucketsInput) (*s3.ListBucketsOutput, error) {
+	dirEntries, err := os.ReadDir(ls.Root)
+	if err != nil {
+		return nil, err
+	}
+	output := &s3.ListBucketsOutput{}
+	for _, d := range dirEntries {
+		if !d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+		bucket := &s3.Bucket{Name: aws.String(d.Name())}
+		if info, err := d.Info(); err == nil {
+			bucket.CreationDate = aws.Time(info.ModTime())
+		}
+		output.Buckets = append(output.Buckets, bucket)
+	}
+	return output, nil
+}
+
+func (ls *LocalStore) HeadBucket(input *s3.HeadBucketInput) (*s3.HeadBucketOutput, error) {
+	if _, err := ls.bucketPath(aws.StringValue(input.Bucket)); err != nil {
+		return nil, err
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (ls *LocalStore) GetBucketLocation(input *s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error) {
+	if _, err := ls.bucketPath(aws.StringValue(input.Bucket)); err != nil {
+		return nil, err
+	}
+	return &s3.GetBucketLocationOutput{}, nil
+}
+
+// escapeKey escapes every segment of a key for use in a URL path.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// sign computes the signature of a presigned URL over its method, object and every other query parameter.
+func (ls *LocalStore) sign(method, bucket, key string, params url.Values) string {
+	signed := url.Values{}
+	for k, v := range params {
+		if k != localSignatureParam {
+			signed[k] = v
+		}
+	}
+	mac := hmac.New(sha256.New, ls.secret)
+	mac.Write([]byte(method + "\n" + bucket + "/" + key + "\n" + signed.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (ls *LocalStore) presign(method, bucket, key string, params url.Values, expire time.Duration) (string, error) {
+	if _, err := ls.objectPath(bucket, key); err != nil {
+		return "", err
+	}
+	params.Set(localExpiresParam, strconv.FormatInt(time.Now().Add(expire).Unix(), 10))
+	params.Set(localSignatureParam, ls.sign(method, bucket, key, params))
+	return ls.BaseURL + "/" + bucket + "/" + escapeKey(key) + "?" + params.Encode(), nil
+}
+
+func (ls *LocalStore) PresignGetObject(input *s3.GetObjectInput, expire time.Duration) (string, error) {
+	params := url.Values{}
+	// the response overrides use the query parameters of S3 and are covered by the signature
+	if input.ResponseContentDisposition != nil {
+		params.Set("response-content-disposition", aws.StringValue(input.ResponseContentDisposition))
+	}
+	if input.ResponseContentType != nil {
+		params.Set("response-content-type", aws.StringValue(input.ResponseContentType))
+	}
+	return ls.presign(http.MethodGet, aws.StringValue(input.Bucket), aws.StringValue(input.Key), params, expire)
+}
+
+// presignDigest adds the checksums a presigned PUT must match to its signed parameters.
+func presignDigest(params url.Values, contentMD5, checksumSHA256 *string) {
+	if contentMD5 != nil {
+		params.Set("content-md5", aws.StringValue(contentMD5))
+	}
+	if checksumSHA256 != nil {
+		params.Set("x-amz-checksum-sha256", aws.StringValue(checksumSHA256))
+	}
+}
+
+func (ls *LocalStore) PresignPutObject(input *s3.PutObjectInput, expire time.Duration) (string, error) {
+	params := url.Values{}
+	presignDigest(params, input.ContentMD5, input.ChecksumSHA256)
+	for k, v := range input.Metadata {
+		params.Set("x-amz-meta-"+strings.ToLower(k), aws.StringValue(v))
+	}
+	return ls.presign(http.MethodPut, aws.StringValue(input.Bucket), aws.StringValue(input.Key), params, expire)
+}
+
+func (ls *LocalStore) PresignUploadPart(input *s3.UploadPartInput, expire time.Duration) (string, error) {
+	params := url.Values{}
+	presignDigest(params, input.ContentMD5, input.ChecksumSHA256)
+	params.Set("uploadId", aws.StringValue(input.UploadId))
+	params.Set("partNumber", strconv.FormatInt(aws.Int64Value(input.PartNumber), 10))
+	return ls.presign(http.MethodPut, aws.StringValue(input.Bucket), aws.StringValue(input.Key), params, expire)
+}
+
+// signPolicy computes the signature of the policy of a presigned POST.
+func (ls *LocalStore) signPolicy(policy string) string {
+	mac := hmac.New(sha256.New, ls.secret)
+	mac.Write([]byte(http.MethodPost + "\n" + policy))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (ls *LocalStore) PresignPostObject(input *PostPolicyInput, expire time.Duration) (*PresignedPost, error) {
+	if _, err := ls.bucketPath(input.Bucket); err != nil {
+		return nil, err
+	}
+	if !input.KeyPrefix {
+		if _, err := ls.objectPath(input.Bucket, input.Key); err != nil {
+			return nil, err
+		}
+	}
+	fields, conditions := postPolicyFields(input)
+	expires := time.Now().Add(expire)
+	policy, err := encodePostPolicy(expires, conditions)
+	if err != nil {
+		return nil, err
+	}
+	fields["policy"] = policy
+	fields[localSignatureParam] = ls.signPolicy(policy)
+	return &PresignedPost{URL: ls.BaseURL + "/" + input.Bucket, Fields: fields, Expires: expires}, nil
+}
+
+// servePost stores the file of a presigned POST once its form fields are checked against the signed policy.
+// As on S3 the fields after the file are ignored, so the file is streamed to disk.
+func (ls *LocalStore) servePost(w http.ResponseWriter, r *http.Request, bucket string) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "expected a multipart/form-data POST", http.StatusBadRequest)
+		return
+	}
+	fields := make(map[string]string)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			http.Error(w, "the POST has no file field", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name := strings.ToLower(part.FormName())
+		if name != "file" {
+			value, err := io.ReadAll(io.LimitReader(part, 64*1024))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			fields[name] = string(value)
+			continue
+		}
+
+		policy := fields["policy"]
+		if policy == "" || !hmac.Equal([]byte(fields[strings.ToLower(localSignatureParam)]), []byte(ls.signPolicy(policy))) {
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			return
+		}
+		key := strings.ReplaceAll(fields["key"], "${filename}", part.FileName())
+		fields["key"] = key
+		fields["bucket"] = bucket
+		minSize, maxSize, err := checkPostPolicy(policy, fields, time.Now())
+		if err != nil {
+			http.Error(w, "Invalid according to Policy: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		metadata := make(map[string]string)
+		for k, v := range fields {
+			if strings.HasPrefix(k, "x-amz-meta-") {
+				metadata[http.CanonicalHeaderKey(strings.TrimPrefix(k, "x-amz-meta-"))] = v
+			}
+		}
+		meta, err := ls.putObject(bucket, key, &sizeRangeReader{r: part, min: minSize, max: maxSize}, fields["content-type"], metadata)
+		if err != nil {
+			writeLocalError(w, err)
+			return
+		}
+		w.Header().Set("ETag", meta.ETag)
+		w.Header().Set("Location", ls.BaseURL+"/"+bucket+"/"+escapeKey(key))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+}
+
+// ServeHTTP serves the presigned URLs issued by the store; it expects to be mounted at BaseURL
+// with the mount prefix stripped from the request path.
+func (ls *LocalStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	params := r.URL.Query()
+	// presigned POSTs carry their signature in the form instead of the query
+	if r.Method == http.MethodPost && key == "" {
+		ls.servePost(w, r, bucket)
+		return
+	}
+
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	expires, err := strconv.ParseInt(params.Get(localExpiresParam), 10, 64)
+	if err != nil || !hmac.Equal([]byte(params.Get(localSignatureParam)), []byte(ls.sign(method, bucket, key, params))) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	if time.Now().Unix() > expires {
+		http.Error(w, "request has expired", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		p, info, meta, err := ls.statObject(bucket, key)
+		if err != nil {
+			writeLocalError(w, err)
+			return
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			writeLocalError(w, err)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("ETag", meta.ETag)
+		w.Header().Set("Content-Type", meta.ContentType)
+		for k, v := range meta.Metadata {
+			w.Header().Set("X-Amz-Meta-"+k, v)
+		}
+		if disposition := params.Get("response-content-disposition"); disposition != "" {
+			w.Header().Set("Content-Disposition", disposition)
+		}
+		if contentType := params.Get("response-content-type"); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		http.ServeContent(w, r, filepath.Base(p), info.ModTime(), f)
+
+	case http.MethodPut:
+		// the signed checksums are the ones the body must match, like signed headers on S3
+		contentMD5, checksumSHA256 := params.Get("content-md5"), params.Get("x-amz-checksum-sha256")
+		if header := r.Header.Get("Content-MD5"); header != "" {
+			if contentMD5 != "" && header != contentMD5 {
+				http.Error(w, "Content-MD5 does not match the signed value", http.StatusForbidden)
+				return
+			}
+			contentMD5 = header
+		}
+		if header := r.Header.Get("X-Amz-Checksum-Sha256"); header != "" {
+			if checksumSHA256 != "" && header != checksumSHA256 {
+				http.Error(w, "x-amz-checksum-sha256 does not match the signed value", http.StatusForbidden)
+				return
+			}
+			checksumSHA256 = header
+		}
+		body := newDigestReader(r.Body, contentMD5, checksumSHA256)
+
+		var etag string
+		if uploadID := params.Get("uploadId"); uploadID != "" {
+			partNumber, err := strconv.ParseInt(params.Get("partNumber"), 10, 64)
+			if err != nil {
+				http.Error(w, "invalid part number", http.StatusBadRequest)
+				return
+			}
+			etag, err = ls.uploadPart(bucket, key, uploadID, partNumber, body)
+			if err != nil {
+				writeLocalError(w, err)
+				return
+			}
+		} else {
+			metadata := make(map[string]string)
+			for k, v := range r.Header {
+				if strings.HasPrefix(k, "X-Amz-Meta-") && len(v) > 0 {
+					metadata[strings.TrimPrefix(k, "X-Amz-Meta-")] = v[0]
+				}
+			}
+			for k := range params {
+				if strings.HasPrefix(k, "x-amz-meta-") {
+					metadata[http.CanonicalHeaderKey(strings.TrimPrefix(k, "x-amz-meta-"))] = params.Get(k)
+				}
+			}
+			meta, err := ls.putObject(bucket, key, body, r.Header.Get("Content-Type"), metadata)
+			if err != nil {
+				writeLocalError(w, err)
+				return
+			}
+			etag = meta.ETag
+		}
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeLocalError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		status = reqErr.StatusCode()
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// HandleLocalStore serves the presigned URLs of the local storage backend.
+func (bh *BlobHandler) HandleLocalStore(c echo.Context) error {
+	ls := bh.localStore()
+	if ls == nil {
+		return c.JSON(http.StatusNotFound, "local storage is not enabled")
+	}
+	http.StripPrefix(LocalStoreRoutePrefix, ls).ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// localStore returns the local storage backend of the handler, nil if it isn't enabled.
+func (bh *BlobHandler) localStore() *LocalStore {
+	for _, s3Ctrl := range bh.controllers() {
+		if ls, ok := s3Ctrl.Store.(*LocalStore); ok {
+			return ls
+		}
+	}
+	return nil
+}
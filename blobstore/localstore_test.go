@@ -0,0 +1,224 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dewberry/s3api/blobstore"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// newLocalStore returns a store rooted in a temporary directory holding the given buckets.
+func newLocalStore(t *testing.T, buckets ...string) (*blobstore.LocalStore, string) {
+	t.Helper()
+	root := t.TempDir()
+	for _, bucket := range buckets {
+		if err := os.Mkdir(filepath.Join(root, bucket), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ls, err := blobstore.NewLocalStore(root, "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ls, root
+}
+
+func putLocal(t *testing.T, ls *blobstore.LocalStore, bucket, key, content string) {
+	t.Helper()
+	_, err := ls.PutObject(&s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: strings.NewReader(content)})
+	if err != nil {
+		t.Fatalf("put %s: %s", key, err.Error())
+	}
+}
+
+// listLocal returns the sorted keys and common prefixes listed under prefix.
+func listLocal(ls *blobstore.LocalStore, bucket, prefix, delimiter string) ([]string, error) {
+	var names []string
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix), MaxKeys: aws.Int64(2)}
+	if delimiter != "" {
+		input.Delimiter = aws.String(delimiter)
+	}
+	err := ls.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, cp := range page.CommonPrefixes {
+			names = append(names, aws.StringValue(cp.Prefix))
+		}
+		for _, obj := range page.Contents {
+			names = append(names, aws.StringValue(obj.Key))
+		}
+		return true
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+func TestLocalStoreObjects(t *testing.T) {
+	ls, _ := newLocalStore(t, "bkt")
+	for _, key := range []string{"a.txt", "dir/b.txt", "dir/c.txt", "dir/sub/d.txt", "other/e.txt"} {
+		putLocal(t, ls, "bkt", key, key)
+	}
+
+	// pages of two keys, continued until the listing is complete
+	keys, err := listLocal(ls, "bkt", "dir/", "")
+	if err != nil || !reflect.DeepEqual(keys, []string{"dir/b.txt", "dir/c.txt", "dir/sub/d.txt"}) {
+		t.Fatalf("got %v, %v", keys, err)
+	}
+	keys, err = listLocal(ls, "bkt", "", "/")
+	if err != nil || !reflect.DeepEqual(keys, []string{"a.txt", "dir/", "other/"}) {
+		t.Fatalf("got %v, %v with a delimiter", keys, err)
+	}
+
+	output, err := ls.GetObject(&s3.GetObjectInput{Bucket: aws.String("bkt"), Key: aws.String("dir/b.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(output.Body)
+	output.Body.Close()
+	if string(data) != "dir/b.txt" {
+		t.Fatalf("got %q", data)
+	}
+
+	_, err = ls.CopyObject(&s3.CopyObjectInput{Bucket: aws.String("bkt"), Key: aws.String("copy.txt"), CopySource: aws.String("bkt/a.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ls.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String("bkt"), Key: aws.String("dir/sub/d.txt")}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ls.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("bkt"), Key: aws.String("dir/sub/d.txt")})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "NotFound" {
+		t.Fatalf("got %v for a deleted key", err)
+	}
+	keys, _ = listLocal(ls, "bkt", "", "/")
+	if !reflect.DeepEqual(keys, []string{"a.txt", "copy.txt", "dir/", "other/"}) {
+		t.Fatalf("got %v after copy and delete", keys)
+	}
+}
+
+func TestLocalStoreMultipartUpload(t *testing.T) {
+	ls, _ := newLocalStore(t, "bkt")
+	created, err := ls.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: aws.String("bkt"), Key: aws.String("big.bin")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts []*s3.CompletedPart
+	for i, content := range []string{"first ", "second"} {
+		output, err := ls.UploadPart(&s3.UploadPartInput{
+			Bucket:     aws.String("bkt"),
+			Key:        aws.String("big.bin"),
+			UploadId:   created.UploadId,
+			PartNumber: aws.Int64(int64(i + 1)),
+			Body:       strings.NewReader(content),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, &s3.CompletedPart{PartNumber: aws.Int64(int64(i + 1)), ETag: output.ETag})
+	}
+	_, err = ls.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String("bkt"),
+		Key:             aws.String("big.bin"),
+		UploadId:        created.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := ls.GetObject(&s3.GetObjectInput{Bucket: aws.String("bkt"), Key: aws.String("big.bin")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Body.Close()
+	if data, _ := io.ReadAll(output.Body); string(data) != "first second" {
+		t.Fatalf("got %q", data)
+	}
+	_, err = ls.AbortMultipartUpload(&s3.AbortMultipartUploadInput{Bucket: aws.String("bkt"), Key: aws.String("big.bin"), UploadId: created.UploadId})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != s3.ErrCodeNoSuchUpload {
+		t.Fatalf("got %v aborting a completed upload", err)
+	}
+}
+
+func TestLocalStorePathsStayInBucket(t *testing.T) {
+	ls, root := newLocalStore(t, "bkt", "secret")
+	putLocal(t, ls, "bkt", "dir/a.txt", "a")
+	putLocal(t, ls, "secret", "passwd.txt", "hidden")
+	if err := os.WriteFile(filepath.Join(filepath.Dir(root), "outside.txt"), []byte("outside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(filepath.Join(filepath.Dir(root), "outside.txt")) })
+
+	for _, prefix := range []string{"../secret/", "../secret/passwd", "dir/../../secret/", "../../", "./dir/", "dir/./"} {
+		keys, err := listLocal(ls, "bkt", prefix, "")
+		aerr, ok := err.(awserr.Error)
+		if !ok || aerr.Code() != "InvalidArgument" || len(keys) != 0 {
+			t.Errorf("prefix %s: got %v, %v", prefix, keys, err)
+		}
+	}
+	// a partial last segment isn't resolved, it only matches key names
+	if keys, err := listLocal(ls, "bkt", "..", ""); err != nil || len(keys) != 0 {
+		t.Errorf("prefix ..: got %v, %v", keys, err)
+	}
+
+	for _, key := range []string{"../secret/passwd.txt", "dir/../../outside.txt", "./a.txt"} {
+		_, err := ls.GetObject(&s3.GetObjectInput{Bucket: aws.String("bkt"), Key: aws.String(key)})
+		if err == nil {
+			t.Errorf("key %s was read", key)
+		}
+		_, err = ls.PutObject(&s3.PutObjectInput{Bucket: aws.String("bkt"), Key: aws.String(key), Body: bytes.NewReader([]byte("x"))})
+		if err == nil {
+			t.Errorf("key %s was written", key)
+		}
+	}
+}
+
+func TestLocalStorePresignedURLs(t *testing.T) {
+	ls, _ := newLocalStore(t, "bkt")
+	srv := httptest.NewServer(ls)
+	defer srv.Close()
+	ls.BaseURL = srv.URL
+
+	url, err := ls.PresignPutObject(&s3.PutObjectInput{Bucket: aws.String("bkt"), Key: aws.String("up/a.txt")}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader("uploaded"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT: %v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	url, err = ls.PresignGetObject(&s3.GetObjectInput{Bucket: aws.String("bkt"), Key: aws.String("up/a.txt")}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != "uploaded" {
+		t.Fatalf("GET: %d %q", resp.StatusCode, data)
+	}
+	// the signature covers the key
+	resp, err = http.Get(strings.Replace(url, "up/a.txt", "up/b.txt", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("got %d for a URL signed for another key", resp.StatusCode)
+	}
+}
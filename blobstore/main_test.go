@@ -0,0 +1,111 @@
+package blobstore_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Dewberry/s3api/auth"
+	"github.com/Dewberry/s3api/blobstore"
+	"github.com/Dewberry/s3api/s3test"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestHandler starts an s3test stand-in serving buckets and returns a handler built against it.
+func newTestHandler(t *testing.T, buckets ...string) (*s3test.Server, *blobstore.BlobHandler) {
+	t.Helper()
+	srv := s3test.NewServer(buckets...)
+	t.Cleanup(srv.Close)
+	return srv, srv.BlobHandler()
+}
+
+// serve calls handler with a request for target, a non-nil body is sent as JSON.
+func serve(t *testing.T, handler echo.HandlerFunc, method, target string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		reader = b
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = strings.NewReader(string(data))
+	}
+	req := httptest.NewRequest(method, target, reader)
+	if _, ok := body.(io.Reader); !ok && body != nil {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	rec := httptest.NewRecorder()
+	if err := handler(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("%s %s: %s", method, target, err.Error())
+	}
+	return rec
+}
+
+// decode checks the status of a response and decodes its JSON body into v.
+func decode(t *testing.T, rec *httptest.ResponseRecorder, status int, v interface{}) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, status, rec.Body.String())
+	}
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("error decoding %s: %s", rec.Body.String(), err.Error())
+		}
+	}
+}
+
+// fetch sends a request to a presigned URL and returns the status and body of the response.
+func fetch(t *testing.T, method, url string, body io.Reader) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+// withClaims runs handler as a user holding roles, the same as auth.Authorize does.
+func withClaims(handler echo.HandlerFunc, email string, roles ...string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Set("claims", &auth.Claims{Email: email, RealmAccess: map[string][]string{"roles": roles}})
+		return handler(c)
+	}
+}
+
+// prefixDB is an auth.Database granting every user the same prefixes, in the /<bucket>/<prefix>
+// form of the permissions table.
+type prefixDB struct {
+	auth.Database
+	prefixes []string
+}
+
+func (db prefixDB) GetUserAccessiblePrefixes(userEmail, bucket string, operations []string) ([]string, error) {
+	return db.prefixes, nil
+}
+
+func (db prefixDB) CheckUserPermission(userEmail, bucket, prefix string, operations []string) bool {
+	return blobstore.IsPermittedPrefix(bucket, prefix, db.prefixes)
+}
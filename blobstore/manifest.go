@@ -0,0 +1,241 @@
+package blobstore
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// sha256MetadataKey is the user metadata holding the hex encoded sha256 of an object.
+const sha256MetadataKey = "sha256"
+
+// manifestHeadConcurrency is how many objects are read at the same time to look up their sha256
+const manifestHeadConcurrency = 16
+
+// errManifestLimit stops a listing once it holds more objects than a manifest may list
+var errManifestLimit = fmt.Errorf("manifest entry limit exceeded")
+
+// manifestEntry describes an object of a prefix, with the path it is delivered under.
+type manifestEntry struct {
+	Path         string    `json:"path"`
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+	StorageClass string    `json:"storage_class"`
+	SHA256       string    `json:"sha256,omitempty"`
+	URL          string    `json:"url,omitempty"`
+}
+
+// manifest lists what is delivered for a prefix.
+type manifest struct {
+	Bucket      string          `json:"bucket"`
+	Prefix      string          `json:"prefix"`
+	GeneratedAt time.Time       `json:"generated_at"`
+	Objects     int             `json:"objects"`
+	TotalSize   uint64          `json:"total_size"`
+	Entries     []manifestEntry `json:"entries"`
+}
+
+var manifestCSVHeader = []string{"path", "key", "size", "etag", "last_modified", "storage_class", "sha256", "url"}
+
+// metadataValue looks up a user metadata value, whose keys S3 returns canonicalized.
+func metadataValue(metadata map[string]*string, name string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, name) {
+			return aws.StringValue(v)
+		}
+	}
+	return ""
+}
+
+// walkManifest calls fn with the entry of every object under prefix the caller may read, in listing order.
+// With withSHA256 the sha256 of every object is read from its metadata, which takes a HEAD request per
+// object, sent manifestHeadConcurrency at a time. When urlExpiration is greater than 0 every entry carries
+// a presigned URL valid for that long.
+func (s3Ctrl *S3Controller) walkManifest(bucket, prefix string, permissions []string, fullAccess bool, urlExpiration time.Duration, withSHA256 bool, fn func(entry manifestEntry) error) error {
+	return s3Ctrl.GetListWithCallBack(bucket, prefix, false, func(page *s3.ListObjectsV2Output) error {
+		var entries []manifestEntry
+		for _, item := range page.Contents {
+			key := aws.StringValue(item.Key)
+			// directory markers are not delivered
+			if strings.HasSuffix(key, "/") {
+				continue
+			}
+			if !fullAccess && !IsPermittedPrefix(bucket, key, permissions) {
+				continue
+			}
+			entries = append(entries, manifestEntry{
+				Path:         strings.TrimPrefix(key, prefix),
+				Key:          key,
+				Size:         aws.Int64Value(item.Size),
+				ETag:         strings.Trim(aws.StringValue(item.ETag), "\""),
+				LastModified: aws.TimeValue(item.LastModified),
+				StorageClass: aws.StringValue(item.StorageClass),
+			})
+		}
+		if withSHA256 {
+			if err := s3Ctrl.readSHA256(bucket, entries); err != nil {
+				return err
+			}
+		}
+		for _, entry := range entries {
+			if urlExpiration > 0 {
+				var err error
+				entry.URL, err = s3Ctrl.GetDownloadPresignedURLWithOptions(bucket, entry.Key, DownloadURLOptions{Expiration: urlExpiration})
+				if err != nil {
+					return fmt.Errorf("error generating presigned URL for object %s: %s", entry.Key, err.Error())
+				}
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// readSHA256 fills in the sha256 of the entries from the metadata of their objects, which the listing
+// doesn't return. The objects are read manifestHeadConcurrency at a time.
+func (s3Ctrl *S3Controller) readSHA256(bucket string, entries []manifestEntry) error {
+	errs := make([]error, len(entries))
+	sem := make(chan struct{}, manifestHeadConcurrency)
+	var wg sync.WaitGroup
+	for i := range entries {
+		wg.Add(1)
+		go func(entry *manifestEntry, errp *error) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			head, err := s3Ctrl.Store.HeadObject(&s3.HeadObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(entry.Key),
+			})
+			if err != nil {
+				*errp = fmt.Errorf("error getting metadata of object %s: %s", entry.Key, err.Error())
+				return
+			}
+			entry.SHA256 = strings.ToLower(metadataValue(head.Metadata, sha256MetadataKey))
+		}(&entries[i], &errs[i])
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *manifest) csv() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(manifestCSVHeader); err != nil {
+		return nil, err
+	}
+	for _, e := range m.Entries {
+		err := w.Write([]string{e.Path, e.Key, strconv.FormatInt(e.Size, 10), e.ETag, e.LastModified.UTC().Format(time.RFC3339), e.StorageClass, e.SHA256, e.URL})
+		if err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// HandleGetPrefixManifest lists the objects under a prefix the caller may read as JSON or CSV, with presigned
+// URLs when `presign` is true, so the delivered files can be checked against it.
+func (bh *BlobHandler) HandleGetPrefixManifest(c echo.Context) error {
+	prefix := c.QueryParam("prefix")
+	if prefix == "" {
+		errMsg := fmt.Errorf("request must include a `prefix` parameter")
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		errMsg := fmt.Errorf("`format` must be json or csv")
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	presign := false
+	if c.QueryParam("presign") != "" {
+		var err error
+		presign, err = strconv.ParseBool(c.QueryParam("presign"))
+		if err != nil {
+			errMsg := fmt.Errorf("error parsing `presign` parameter: %s", err.Error())
+			log.Error(errMsg.Error())
+			return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+		}
+	}
+
+	bucket := c.QueryParam("bucket")
+	s3Ctrl, err := bh.GetController(bucket)
+	if err != nil {
+		errMsg := fmt.Errorf("`bucket` %s is not available, %s", bucket, err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	permissions, fullAccess, statusCode, err := bh.GetS3ReadPermissions(c, bucket)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(statusCode, err.Error())
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+
+	var urlExpiration time.Duration
+	if presign {
+		urlExpiration, _ = bh.downloadURLExpiration(c)
+	}
+	m := manifest{Bucket: bucket, Prefix: prefix, GeneratedAt: time.Now().UTC(), Entries: []manifestEntry{}}
+	err = s3Ctrl.walkManifest(bucket, prefix, permissions, fullAccess, urlExpiration, true, func(entry manifestEntry) error {
+		// the manifest is built in memory, so its size is bounded by the number of entries
+		if len(m.Entries) == bh.Config.DefaultManifestEntryLimit {
+			return errManifestLimit
+		}
+		m.Entries = append(m.Entries, entry)
+		m.TotalSize += uint64(entry.Size)
+		return nil
+	})
+	if err == errManifestLimit {
+		errMsg := fmt.Errorf("prefix %s holds more than %d objects, request a manifest of each of its sub prefixes instead", prefix, bh.Config.DefaultManifestEntryLimit)
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusRequestEntityTooLarge, errMsg.Error())
+	}
+	if err != nil {
+		errMsg := fmt.Errorf("error building manifest: %s", err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusInternalServerError, errMsg.Error())
+	}
+	m.Objects = len(m.Entries)
+
+	log.Infof("successfully generated manifest of %d object(s) for prefix %s in bucket %s", m.Objects, prefix, bucket)
+	if format == "json" {
+		return c.JSON(http.StatusOK, m)
+	}
+	body, err := m.csv()
+	if err != nil {
+		errMsg := fmt.Errorf("error writing manifest: %s", err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusInternalServerError, errMsg.Error())
+	}
+	filename := fmt.Sprintf("%s_manifest.csv", path.Base(strings.TrimSuffix(prefix, "/")))
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	return c.Blob(http.StatusOK, "text/csv; charset=utf-8", body)
+}
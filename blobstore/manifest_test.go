@@ -0,0 +1,114 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Dewberry/s3api/s3test"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// putWithSHA256 puts data with its sha256 in the user metadata and returns the sha256.
+func putWithSHA256(t *testing.T, srv *s3test.Server, key string, data []byte) string {
+	t.Helper()
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	_, err := srv.Store().PutObject(&s3.PutObjectInput{
+		Bucket:   aws.String("bkt"),
+		Key:      aws.String(key),
+		Body:     bytes.NewReader(data),
+		Metadata: map[string]*string{"sha256": aws.String(digest)},
+	})
+	if err != nil {
+		t.Fatalf("put %s: %s", key, err.Error())
+	}
+	return digest
+}
+
+func TestPrefixManifest(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	// more objects than are looked up at the same time
+	digests := make(map[string]string)
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprintf("delivery/part-%02d.bin", i)
+		digests[key] = putWithSHA256(t, srv, key, []byte(key))
+	}
+	srv.PutObject("bkt", "delivery/plain.txt", []byte("no checksum"))
+	srv.PutObject("bkt", "delivery/dir/", nil)
+
+	var m struct {
+		Objects int `json:"objects"`
+		Entries []struct {
+			Path   string `json:"path"`
+			Key    string `json:"key"`
+			Size   int64  `json:"size"`
+			SHA256 string `json:"sha256"`
+			URL    string `json:"url"`
+		} `json:"entries"`
+	}
+	decode(t, serve(t, bh.HandleGetPrefixManifest, http.MethodGet, "/prefix/manifest?bucket=bkt&prefix=delivery&presign=true", nil), http.StatusOK, &m)
+	if m.Objects != 41 || len(m.Entries) != 41 {
+		t.Fatalf("got %d entries, want 41", len(m.Entries))
+	}
+	for i, e := range m.Entries {
+		if i > 0 && e.Key < m.Entries[i-1].Key {
+			t.Fatalf("%s listed after %s", e.Key, m.Entries[i-1].Key)
+		}
+		if e.SHA256 != digests[e.Key] {
+			t.Fatalf("%s: got sha256 %q, want %q", e.Key, e.SHA256, digests[e.Key])
+		}
+		if e.URL == "" || e.Path != strings.TrimPrefix(e.Key, "delivery/") {
+			t.Fatalf("unexpected entry %+v", e)
+		}
+	}
+
+	rec := serve(t, bh.HandleGetPrefixManifest, http.MethodGet, "/prefix/manifest?bucket=bkt&prefix=delivery&format=csv", nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "part-07.bin,delivery/part-07.bin,20,") {
+		t.Fatalf("unexpected CSV manifest %d: %.200s", rec.Code, rec.Body.String())
+	}
+	decode(t, serve(t, bh.HandleGetPrefixManifest, http.MethodGet, "/prefix/manifest?bucket=bkt&prefix=delivery&format=xml", nil), http.StatusUnprocessableEntity, nil)
+}
+
+func TestPrefixManifestEntryLimit(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	for i := 0; i < 5; i++ {
+		srv.PutObject("bkt", fmt.Sprintf("delivery/%d.txt", i), []byte("x"))
+	}
+	bh.Config.DefaultManifestEntryLimit = 5
+	decode(t, serve(t, bh.HandleGetPrefixManifest, http.MethodGet, "/prefix/manifest?bucket=bkt&prefix=delivery", nil), http.StatusOK, nil)
+	bh.Config.DefaultManifestEntryLimit = 4
+	for _, format := range []string{"json", "csv"} {
+		rec := serve(t, bh.HandleGetPrefixManifest, http.MethodGet, "/prefix/manifest?bucket=bkt&prefix=delivery&format="+format, nil)
+		decode(t, rec, http.StatusRequestEntityTooLarge, nil)
+	}
+}
+
+func TestDownloadScriptChecksums(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	digest := putWithSHA256(t, srv, "delivery/a.txt", []byte("checked"))
+	srv.PutObject("bkt", "delivery/b.txt", []byte("unchecked"))
+
+	script := func(query string) string {
+		var url string
+		decode(t, serve(t, bh.HandleGenerateDownloadScript, http.MethodGet, "/prefix/download/script?bucket=bkt&prefix=delivery&format=bash"+query, nil), http.StatusOK, &url)
+		resp, body := fetch(t, http.MethodGet, url, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET script: %d %s", resp.StatusCode, body)
+		}
+		return string(body)
+	}
+	// without checksums the script works from the listing alone
+	if s := script(""); strings.Contains(s, digest) || !strings.Contains(s, "download 'delivery/a.txt' 7 ") {
+		t.Fatalf("unexpected script:\n%s", s)
+	}
+	if s := script("&checksums=true"); !strings.Contains(s, digest) {
+		t.Fatalf("script with checksums lacks the sha256 of delivery/a.txt:\n%s", s)
+	}
+	decode(t, serve(t, bh.HandleGenerateDownloadScript, http.MethodGet, "/prefix/download/script?bucket=bkt&prefix=delivery&checksums=maybe", nil), http.StatusUnprocessableEntity, nil)
+}
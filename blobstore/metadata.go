@@ -184,7 +184,7 @@ func (s3Ctrl *S3Controller) GetMetaData(bucket, key string) (*s3.HeadObjectOutpu
 		Key:    aws.String(key),
 	}
 
-	result, err := s3Ctrl.S3Svc.HeadObject(input)
+	result, err := s3Ctrl.Store.HeadObject(input)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,149 @@
+package blobstore
+
+import (
+	"fmt"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+
+	"github.com/Dewberry/s3api/config"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// provisionMinioBuckets creates the buckets listed in the MinIO configuration and the buckets
+// of its fixtures directory when they are missing, and seeds them from the fixtures. It returns
+// the buckets to serve and whether every bucket of the server is allowed ("*" in the list).
+func provisionMinioBuckets(store *S3ObjectStore, mc config.MinIO) ([]string, bool, error) {
+	var buckets []string
+	allowAllBuckets := false
+	seen := make(map[string]bool)
+	add := func(bucket string) {
+		if bucket == "*" {
+			allowAllBuckets = true
+			return
+		}
+		if bucket != "" && !seen[bucket] {
+			seen[bucket] = true
+			buckets = append(buckets, bucket)
+		}
+	}
+	add(mc.Bucket)
+	for _, bucket := range mc.Buckets {
+		add(bucket)
+	}
+
+	var fixtureBuckets []string
+	if mc.FixturesDir != "" {
+		entries, err := os.ReadDir(mc.FixturesDir)
+		if err != nil {
+			return nil, false, fmt.Errorf("error reading fixtures directory: %s", err.Error())
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				fixtureBuckets = append(fixtureBuckets, entry.Name())
+				add(entry.Name())
+			}
+		}
+	}
+
+	for _, bucket := range buckets {
+		if err := ensureBucket(store, bucket); err != nil {
+			return nil, false, err
+		}
+	}
+	for _, bucket := range fixtureBuckets {
+		if err := seedBucket(store, bucket, filepath.Join(mc.FixturesDir, bucket)); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if !allowAllBuckets {
+		return buckets, false, nil
+	}
+	result, err := store.ListBuckets(&s3.ListBucketsInput{})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to retrieve list of MinIO buckets: %s", err.Error())
+	}
+	buckets = buckets[:0]
+	for _, bucket := range result.Buckets {
+		buckets = append(buckets, aws.StringValue(bucket.Name))
+	}
+	return buckets, true, nil
+}
+
+func ensureBucket(store *S3ObjectStore, bucket string) error {
+	_, err := store.HeadBucket(&s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err == nil {
+		log.Debugf("Bucket %s already exists", bucket)
+		return nil
+	}
+	// Bucket does not exist, create it
+	_, err = store.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return fmt.Errorf("error creating bucket %s: %s", bucket, err.Error())
+	}
+	log.Infof("Bucket %s created successfully", bucket)
+	return nil
+}
+
+// seedBucket uploads every file under dir to bucket, keyed by its path relative to dir.
+// Objects that already exist are left untouched, so the fixtures never overwrite changes
+// made while testing.
+func seedBucket(store *S3ObjectStore, bucket, dir string) error {
+	seeded := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+
+		_, err = store.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err == nil {
+			return nil
+		}
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "NotFound" {
+			return fmt.Errorf("error checking fixture %s: %s", key, err.Error())
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input := &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   f,
+		}
+		if contentType := mime.TypeByExtension(filepath.Ext(path)); contentType != "" {
+			input.ContentType = aws.String(contentType)
+		}
+		if _, err := store.PutObject(input); err != nil {
+			return fmt.Errorf("error uploading fixture %s: %s", key, err.Error())
+		}
+		seeded++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error seeding bucket %s: %s", bucket, err.Error())
+	}
+	if seeded > 0 {
+		log.Infof("Seeded %d object(s) into bucket %s", seeded, bucket)
+	}
+	return nil
+}
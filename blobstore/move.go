@@ -65,7 +65,7 @@ func (s3Ctrl *S3Controller) MovePrefix(bucket, srcPrefix, destPrefix string) err
 				CopySource: aws.String(bucket + "/" + srcObjectKey),
 				Key:        aws.String(destObjectKey),
 			}
-			_, err := s3Ctrl.S3Svc.CopyObject(copyInput)
+			_, err := s3Ctrl.Store.CopyObject(copyInput)
 			if err != nil {
 				return fmt.Errorf("error copying object %s to %s: %v", srcObjectKey, destObjectKey, err)
 			}
@@ -159,13 +159,13 @@ func (s3Ctrl *S3Controller) CopyObject(bucket, srcObjectKey, destObjectKey strin
 	}
 
 	// Copy the object to the new key (effectively renaming)
-	_, err = s3Ctrl.S3Svc.CopyObject(copyInput)
+	_, err = s3Ctrl.Store.CopyObject(copyInput)
 	if err != nil {
 		return fmt.Errorf("error copying object" + srcObjectKey + "with the new key" + destObjectKey + ", " + err.Error())
 	}
 
 	// Delete the source object
-	_, err = s3Ctrl.S3Svc.DeleteObject(&s3.DeleteObjectInput{
+	_, err = s3Ctrl.Store.DeleteObject(&s3.DeleteObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(srcObjectKey),
 	})
@@ -0,0 +1,47 @@
+package blobstore_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestMoveObject(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	srv.PutObject("bkt", "src/a.txt", []byte("a"))
+	srv.PutObject("bkt", "src/b.txt", []byte("b"))
+
+	rec := serve(t, bh.HandleMoveObject, http.MethodPut, "/object/move?bucket=bkt&src_key=src/a.txt&dest_key=dst/a.txt", nil)
+	decode(t, rec, http.StatusOK, nil)
+	if got, ok := srv.GetObject("bkt", "dst/a.txt"); !ok || string(got) != "a" {
+		t.Fatalf("got %q at the destination", got)
+	}
+	if _, ok := srv.GetObject("bkt", "src/a.txt"); ok {
+		t.Fatal("the source of a move was kept")
+	}
+
+	for target, status := range map[string]int{
+		"/object/move?bucket=bkt&src_key=src/b.txt&dest_key=src/b.txt": http.StatusBadRequest,
+		"/object/move?bucket=bkt&src_key=src/b.txt&dest_key=dst/a.txt": http.StatusConflict,
+		"/object/move?bucket=bkt&src_key=src/none&dest_key=dst/none":   http.StatusNotFound,
+		"/object/move?bucket=bkt&src_key=src/b.txt":                    http.StatusUnprocessableEntity,
+	} {
+		decode(t, serve(t, bh.HandleMoveObject, http.MethodPut, target, nil), status, nil)
+	}
+}
+
+func TestMovePrefix(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	srv.PutObject("bkt", "src/a.txt", []byte("a"))
+	srv.PutObject("bkt", "src/sub/b.txt", []byte("b"))
+	srv.PutObject("bkt", "srcother/c.txt", []byte("c"))
+
+	rec := serve(t, bh.HandleMovePrefix, http.MethodPut, "/prefix/move?bucket=bkt&src_prefix=src&dest_prefix=dst", nil)
+	decode(t, rec, http.StatusOK, nil)
+	want := []string{"dst/a.txt", "dst/sub/b.txt", "srcother/c.txt"}
+	if got := srv.Keys("bkt"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got keys %v, want %v", got, want)
+	}
+	rec = serve(t, bh.HandleMovePrefix, http.MethodPut, "/prefix/move?bucket=bkt&src_prefix=src&dest_prefix=dst", nil)
+	decode(t, rec, http.StatusNotFound, nil)
+}
@@ -0,0 +1,260 @@
+package blobstore
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// multipartUpload is a multipart upload that was started and is neither completed nor aborted.
+type multipartUpload struct {
+	Key       string    `json:"key"`
+	UploadID  string    `json:"uploadId"`
+	Initiated time.Time `json:"initiated"`
+}
+
+// multipartUploadParts lists the parts of a multipart upload the store already holds.
+type multipartUploadParts struct {
+	UploadID string         `json:"uploadId"`
+	Key      string         `json:"key"`
+	Parts    []uploadedPart `json:"parts"`
+}
+
+// staleUploadReport lists the incomplete multipart uploads aborted in a bucket, or the ones that
+// would be aborted for a dry run.
+type staleUploadReport struct {
+	Bucket  string            `json:"bucket"`
+	DryRun  bool              `json:"dry_run"`
+	Aborted []multipartUpload `json:"aborted"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// ListMultipartUploads returns the multipart uploads in progress under prefix, by key and then by
+// the time they were started.
+func (s3Ctrl *S3Controller) ListMultipartUploads(bucket string, prefix string) ([]*s3.MultipartUpload, error) {
+	var uploads []*s3.MultipartUpload
+	err := s3Ctrl.Store.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		uploads = append(uploads, page.Uploads...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
+
+// listClientUploads returns the multipart uploads in progress under prefix by the key and upload ID
+// clients know them by. Staged uploads are listed under their key rather than their staging key.
+func (bh *BlobHandler) listClientUploads(s3Ctrl *S3Controller, bucket string, prefix string) ([]multipartUpload, error) {
+	uploads, err := s3Ctrl.ListMultipartUploads(bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	// the staged uploads under prefix are listed on their own unless prefix already covers them
+	if stagingPrefix := bh.stagingKey(prefix); !strings.HasPrefix(stagingPrefix, prefix) {
+		staged, err := s3Ctrl.ListMultipartUploads(bucket, stagingPrefix)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, staged...)
+	}
+	result := []multipartUpload{}
+	for _, upload := range uploads {
+		key, uploadID := bh.clientUpload(aws.StringValue(upload.Key), aws.StringValue(upload.UploadId))
+		if strings.HasPrefix(key, prefix) {
+			result = append(result, multipartUpload{Key: key, UploadID: uploadID, Initiated: aws.TimeValue(upload.Initiated)})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Key != result[j].Key {
+			return result[i].Key < result[j].Key
+		}
+		return result[i].Initiated.Before(result[j].Initiated)
+	})
+	return result, nil
+}
+
+// HandleListMultipartUploads lists the multipart uploads in progress under `prefix` that the user may
+// write to, so a client that lost an upload ID can find it and resume the upload.
+func (bh *BlobHandler) HandleListMultipartUploads(c echo.Context) error {
+	prefix := c.QueryParam("prefix")
+	bucket := c.QueryParam("bucket")
+	s3Ctrl, err := bh.GetController(bucket)
+	if err != nil {
+		errMsg := fmt.Errorf("`bucket` %s is not available, %s", bucket, err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	permissions, fullAccess, statusCode, err := bh.GetS3WritePermissions(c, bucket)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(statusCode, err.Error())
+	}
+
+	uploads, err := bh.listClientUploads(s3Ctrl, bucket, prefix)
+	if err != nil {
+		errMsg := fmt.Errorf("error listing the multipart uploads of %s: %s", prefix, err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusInternalServerError, errMsg.Error())
+	}
+	result := []multipartUpload{}
+	for _, upload := range uploads {
+		if fullAccess || IsPermittedPrefix(bucket, upload.Key, permissions) {
+			result = append(result, upload)
+		}
+	}
+	log.Infof("successfully listed %d multipart uploads in progress under prefix: %s", len(result), prefix)
+	return c.JSON(http.StatusOK, result)
+}
+
+// HandleListMultipartUploadParts lists the parts of `upload_id` the store already holds, so a client
+// resuming the upload only sends the missing parts before completing it.
+func (bh *BlobHandler) HandleListMultipartUploadParts(c echo.Context) error {
+	key := c.QueryParam("key")
+	uploadID := c.QueryParam("upload_id")
+	if key == "" || uploadID == "" {
+		errMsg := fmt.Errorf("`key` and `upload_id` parameters are required")
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	bucket := c.QueryParam("bucket")
+	s3Ctrl, err := bh.GetController(bucket)
+	if err != nil {
+		errMsg := fmt.Errorf("`bucket` %s is not available, %s", bucket, err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	httpCode, err := bh.CheckUserS3Permission(c, bucket, key, []string{"write"})
+	if err != nil {
+		errMsg := fmt.Errorf("error while checking for user permission: %s", err)
+		log.Error(errMsg.Error())
+		return c.JSON(httpCode, errMsg.Error())
+	}
+
+	uploadKey, storeUploadID, _ := bh.uploadTarget(key, uploadID)
+	existing, err := s3Ctrl.ListUploadedParts(bucket, uploadKey, storeUploadID)
+	if err != nil {
+		errMsg := fmt.Errorf("error listing the parts of upload %s: %s", uploadID, err.Error())
+		log.Error(errMsg.Error())
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchUpload {
+			return c.JSON(http.StatusNotFound, errMsg.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, errMsg.Error())
+	}
+	parts := []uploadedPart{}
+	for _, part := range existing {
+		parts = append(parts, uploadedPart{
+			PartNumber: aws.Int64Value(part.PartNumber),
+			ETag:       aws.StringValue(part.ETag),
+			Size:       aws.Int64Value(part.Size),
+		})
+	}
+	log.Infof("successfully listed %d uploaded parts of upload %s for key: %s", len(parts), uploadID, key)
+	return c.JSON(http.StatusOK, multipartUploadParts{UploadID: uploadID, Key: key, Parts: parts})
+}
+
+// abortStaleUploads aborts the multipart uploads of bucket that were started before cutoff.
+func (s3Ctrl *S3Controller) abortStaleUploads(bucket string, cutoff time.Time, dryRun bool) (staleUploadReport, error) {
+	report := staleUploadReport{Bucket: bucket, DryRun: dryRun, Aborted: []multipartUpload{}}
+	uploads, err := s3Ctrl.ListMultipartUploads(bucket, "")
+	if err != nil {
+		return report, fmt.Errorf("error listing multipart uploads: %s", err.Error())
+	}
+
+	var failed []string
+	for _, upload := range uploads {
+		initiated := aws.TimeValue(upload.Initiated)
+		if !initiated.Before(cutoff) {
+			continue
+		}
+		key := aws.StringValue(upload.Key)
+		uploadID := aws.StringValue(upload.UploadId)
+		if !dryRun {
+			err := s3Ctrl.AbortMultipartUpload(bucket, key, uploadID)
+			// an upload completed or aborted since it was listed is gone already
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchUpload {
+				continue
+			}
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("%s (%s): %s", key, uploadID, err.Error()))
+				continue
+			}
+		}
+		report.Aborted = append(report.Aborted, multipartUpload{Key: key, UploadID: uploadID, Initiated: initiated})
+	}
+	if len(failed) > 0 {
+		return report, fmt.Errorf("%d upload(s) could not be aborted: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return report, nil
+}
+
+// abortStale aborts the incomplete uploads of bucket older than maxAge and logs what was aborted.
+func (bh *BlobHandler) abortStale(s3Ctrl *S3Controller, bucket string, maxAge time.Duration, dryRun bool) staleUploadReport {
+	report, err := s3Ctrl.abortStaleUploads(bucket, time.Now().Add(-maxAge), dryRun)
+	for i, upload := range report.Aborted {
+		report.Aborted[i].Key, report.Aborted[i].UploadID = bh.clientUpload(upload.Key, upload.UploadID)
+	}
+	if err != nil {
+		report.Error = err.Error()
+		log.Errorf("error aborting the stale multipart uploads of bucket %s: %s", bucket, err.Error())
+	}
+	if len(report.Aborted) > 0 && !dryRun {
+		log.Infof("aborted %d multipart upload(s) older than %s in bucket %s: %v", len(report.Aborted), maxAge, bucket, report.Aborted)
+	}
+	return report
+}
+
+// HandleAbortStaleUploads aborts the multipart uploads of `bucket`, or of every bucket when it is
+// omitted, that were started more than StaleUploadMaxAge ago and were never completed, freeing the
+// storage their parts hold. `older_than_days` overrides the configured age and `dry_run` only reports
+// what would be aborted.
+func (bh *BlobHandler) HandleAbortStaleUploads(c echo.Context) error {
+	maxAge := bh.Config.StaleUploadMaxAge
+	if value := c.QueryParam("older_than_days"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days <= 0 {
+			errMsg := fmt.Errorf("`older_than_days` must be a positive number of days, got `%s`", value)
+			log.Error(errMsg.Error())
+			return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+		}
+		maxAge = time.Duration(days) * 24 * time.Hour
+	}
+	dryRun := false
+	if value := c.QueryParam("dry_run"); value != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			errMsg := fmt.Errorf("error parsing `dry_run` parameter: %s", err.Error())
+			log.Error(errMsg.Error())
+			return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+		}
+	}
+
+	bucket := c.QueryParam("bucket")
+	if bucket == "" {
+		reports := []staleUploadReport{}
+		bh.forEachBucket(func(s3Ctrl *S3Controller, bucket string) {
+			reports = append(reports, bh.abortStale(s3Ctrl, bucket, maxAge, dryRun))
+		})
+		return c.JSON(http.StatusOK, reports)
+	}
+	s3Ctrl, err := bh.GetController(bucket)
+	if err != nil {
+		errMsg := fmt.Errorf("`bucket` %s is not available, %s", bucket, err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	return c.JSON(http.StatusOK, []staleUploadReport{bh.abortStale(s3Ctrl, bucket, maxAge, dryRun)})
+}
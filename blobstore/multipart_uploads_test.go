@@ -0,0 +1,129 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type listedUpload struct {
+	Key      string `json:"key"`
+	UploadID string `json:"uploadId"`
+}
+
+func TestResumeMultipartUpload(t *testing.T) {
+	_, bh := newTestHandler(t, "bkt")
+	key := "resume/big.bin"
+
+	var uploadID, other string
+	decode(t, serve(t, bh.HandleGetMultipartUploadID, http.MethodGet, "/object/multipart_upload_id?bucket=bkt&key="+key, nil), http.StatusOK, &uploadID)
+	decode(t, serve(t, bh.HandleGetMultipartUploadID, http.MethodGet, "/object/multipart_upload_id?bucket=bkt&key=elsewhere/c.bin", nil), http.StatusOK, &other)
+
+	var uploads []listedUpload
+	decode(t, serve(t, bh.HandleListMultipartUploads, http.MethodGet, "/prefix/multipart_uploads?bucket=bkt&prefix=resume/", nil), http.StatusOK, &uploads)
+	if len(uploads) != 1 || uploads[0].Key != key || uploads[0].UploadID != uploadID {
+		t.Fatalf("got uploads %+v, want only %s", uploads, uploadID)
+	}
+
+	// send the first part only, as if the client was interrupted
+	var url string
+	target := "/object/presigned_upload?bucket=bkt&key=" + key + "&upload_id=" + uploadID + "&part_number=1"
+	decode(t, serve(t, bh.HandleGetPresignedUploadURL, http.MethodGet, target, nil), http.StatusOK, &url)
+	if resp, body := fetch(t, http.MethodPut, url, bytes.NewReader(bytes.Repeat([]byte("a"), 1024))); resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT part 1: %d %s", resp.StatusCode, body)
+	}
+
+	var listed struct {
+		UploadID string `json:"uploadId"`
+		Parts    []struct {
+			PartNumber int64  `json:"partNumber"`
+			ETag       string `json:"eTag"`
+			Size       int64  `json:"size"`
+		} `json:"parts"`
+	}
+	target = "/object/multipart_upload_parts?bucket=bkt&key=" + key + "&upload_id=" + uploadID
+	decode(t, serve(t, bh.HandleListMultipartUploadParts, http.MethodGet, target, nil), http.StatusOK, &listed)
+	if listed.UploadID != uploadID || len(listed.Parts) != 1 || listed.Parts[0].PartNumber != 1 || listed.Parts[0].Size != 1024 || listed.Parts[0].ETag == "" {
+		t.Fatalf("unexpected parts %+v", listed)
+	}
+
+	decode(t, serve(t, bh.HandleListMultipartUploadParts, http.MethodGet, "/object/multipart_upload_parts?bucket=bkt&key="+key+"&upload_id=unknown", nil), http.StatusNotFound, nil)
+	decode(t, serve(t, bh.HandleListMultipartUploadParts, http.MethodGet, "/object/multipart_upload_parts?bucket=bkt&key="+key, nil), http.StatusUnprocessableEntity, nil)
+}
+
+func TestAbortStaleUploads(t *testing.T) {
+	_, bh := newTestHandler(t, "a", "b")
+	// a served bucket the store no longer has is skipped
+	bh.S3Controllers[0].Buckets = append(bh.S3Controllers[0].Buckets, "gone")
+	for _, target := range []string{
+		"/object/multipart_upload_id?bucket=a&key=x.bin",
+		"/object/multipart_upload_id?bucket=b&key=y.bin",
+	} {
+		decode(t, serve(t, bh.HandleGetMultipartUploadID, http.MethodGet, target, nil), http.StatusOK, nil)
+	}
+
+	type report struct {
+		Bucket  string         `json:"bucket"`
+		Aborted []listedUpload `json:"aborted"`
+		Error   string         `json:"error"`
+	}
+	var reports []report
+	// the uploads were just started, so none is older than the configured age
+	decode(t, serve(t, bh.HandleAbortStaleUploads, http.MethodPost, "/admin/abort_stale_uploads", nil), http.StatusOK, &reports)
+	if len(reports) != 2 || len(reports[0].Aborted)+len(reports[1].Aborted) != 0 {
+		t.Fatalf("got reports %+v, want nothing aborted in a and b", reports)
+	}
+
+	bh.Config.StaleUploadMaxAge = time.Nanosecond
+	reports = nil
+	decode(t, serve(t, bh.HandleAbortStaleUploads, http.MethodPost, "/admin/abort_stale_uploads?dry_run=true", nil), http.StatusOK, &reports)
+	if len(reports) != 2 || len(reports[0].Aborted) != 1 || len(reports[1].Aborted) != 1 {
+		t.Fatalf("got reports %+v, want one upload in each of a and b", reports)
+	}
+	reports = nil
+	decode(t, serve(t, bh.HandleAbortStaleUploads, http.MethodPost, "/admin/abort_stale_uploads?bucket=a", nil), http.StatusOK, &reports)
+	if len(reports) != 1 || reports[0].Bucket != "a" || len(reports[0].Aborted) != 1 || reports[0].Error != "" {
+		t.Fatalf("unexpected reports %+v", reports)
+	}
+
+	var uploads []listedUpload
+	decode(t, serve(t, bh.HandleListMultipartUploads, http.MethodGet, "/prefix/multipart_uploads?bucket=a", nil), http.StatusOK, &uploads)
+	if len(uploads) != 0 {
+		t.Fatalf("uploads %+v left in a", uploads)
+	}
+	decode(t, serve(t, bh.HandleListMultipartUploads, http.MethodGet, "/prefix/multipart_uploads?bucket=b", nil), http.StatusOK, &uploads)
+	if len(uploads) != 1 || uploads[0].Key != "y.bin" {
+		t.Fatalf("got uploads %+v in b, want y.bin still in progress", uploads)
+	}
+
+	decode(t, serve(t, bh.HandleAbortStaleUploads, http.MethodPost, "/admin/abort_stale_uploads?older_than_days=0", nil), http.StatusUnprocessableEntity, nil)
+}
+
+func TestListStagedMultipartUploads(t *testing.T) {
+	_, bh := newTestHandler(t, "bkt")
+	var uploadID string
+	target := "/object/multipart_upload_id?bucket=bkt&key=resume/staged.bin&sha256=" + sha256Hex([]byte("data"))
+	decode(t, serve(t, bh.HandleGetMultipartUploadID, http.MethodGet, target, nil), http.StatusOK, &uploadID)
+
+	// a staged upload is listed by the key and ID the client holds, not by its staging key
+	for _, prefix := range []string{"", "resume/"} {
+		var uploads []listedUpload
+		decode(t, serve(t, bh.HandleListMultipartUploads, http.MethodGet, "/prefix/multipart_uploads?bucket=bkt&prefix="+prefix, nil), http.StatusOK, &uploads)
+		if len(uploads) != 1 || uploads[0].Key != "resume/staged.bin" || uploads[0].UploadID != uploadID {
+			t.Fatalf("prefix %q: got uploads %+v, want %s", prefix, uploads, uploadID)
+		}
+	}
+	target = "/object/multipart_upload_parts?bucket=bkt&key=resume/staged.bin&upload_id=" + uploadID
+	decode(t, serve(t, bh.HandleListMultipartUploadParts, http.MethodGet, target, nil), http.StatusOK, nil)
+
+	type report struct {
+		Aborted []listedUpload `json:"aborted"`
+	}
+	var reports []report
+	bh.Config.StaleUploadMaxAge = time.Nanosecond
+	decode(t, serve(t, bh.HandleAbortStaleUploads, http.MethodPost, "/admin/abort_stale_uploads?bucket=bkt", nil), http.StatusOK, &reports)
+	if len(reports) != 1 || len(reports[0].Aborted) != 1 || reports[0].Aborted[0].Key != "resume/staged.bin" || reports[0].Aborted[0].UploadID != uploadID {
+		t.Fatalf("got reports %+v", reports)
+	}
+}
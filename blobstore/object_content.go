@@ -4,34 +4,93 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/labstack/echo/v4"
 	log "github.com/sirupsen/logrus"
 )
 
 func (s3Ctrl *S3Controller) FetchObjectContent(bucket string, key string) (io.ReadCloser, error) {
-	keyExist, err := s3Ctrl.KeyExists(bucket, key)
-	if err != nil {
-		return nil, err
-	}
-	if !keyExist {
-		return nil, fmt.Errorf("object %s not found", key)
-	}
 	input := &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	}
-	output, err := s3Ctrl.S3Svc.GetObject(input)
+	output, err := s3Ctrl.Store.GetObject(input)
 	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, fmt.Errorf("object %s not found", key)
+		}
 		return nil, err
 	}
 
 	return output.Body, nil
 }
 
+// objectContentHeaders holds the object headers passed through by /object/content.
+type objectContentHeaders struct {
+	ContentType     *string
+	ContentLength   *int64
+	ContentRange    *string
+	ContentEncoding *string
+	CacheControl    *string
+	ETag            *string
+	LastModified    *time.Time
+}
+
+func (h objectContentHeaders) write(header http.Header) {
+	contentType := aws.StringValue(h.ContentType)
+	if contentType == "" {
+		contentType = echo.MIMEOctetStream
+	}
+	header.Set(echo.HeaderContentType, contentType)
+	header.Set("Accept-Ranges", "bytes")
+	if h.ContentLength != nil {
+		header.Set(echo.HeaderContentLength, strconv.FormatInt(*h.ContentLength, 10))
+	}
+	if h.ContentRange != nil {
+		header.Set("Content-Range", *h.ContentRange)
+	}
+	if h.ContentEncoding != nil {
+		header.Set(echo.HeaderContentEncoding, *h.ContentEncoding)
+	}
+	if h.CacheControl != nil {
+		header.Set("Cache-Control", *h.CacheControl)
+	}
+	if h.ETag != nil {
+		header.Set("ETag", *h.ETag)
+	}
+	if h.LastModified != nil {
+		header.Set(echo.HeaderLastModified, h.LastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// conditionalTimeHeader parses an HTTP date header, invalid dates are ignored as RFC 7232 requires.
+func conditionalTimeHeader(r *http.Request, name string) *time.Time {
+	v := r.Header.Get(name)
+	if v == "" {
+		return nil
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+func optionalHeader(r *http.Request, name string) *string {
+	if v := r.Header.Get(name); v != "" {
+		return aws.String(v)
+	}
+	return nil
+}
+
+// HandleObjectContents streams an object. The Range, If-Match, If-None-Match, If-Modified-Since
+// and If-Unmodified-Since headers are forwarded to the store, which answers with partial content,
+// 304 or 412 the same way S3 does. HEAD requests return the headers only.
 func (bh *BlobHandler) HandleObjectContents(c echo.Context) error {
 	key := c.QueryParam("key")
 	if key == "" {
@@ -58,21 +117,79 @@ func (bh *BlobHandler) HandleObjectContents(c echo.Context) error {
 		log.Error(errMsg.Error())
 		return c.JSON(http.StatusForbidden, errMsg.Error())
 	}
-	outPutBody, err := s3Ctrl.FetchObjectContent(bucket, key)
-	if err != nil {
-		errMsg := fmt.Errorf("error fetching object's content: %s", err.Error())
-		log.Error(errMsg.Error())
-		if strings.Contains(err.Error(), "not found") {
-			return c.JSON(http.StatusNotFound, errMsg.Error())
-		} else {
-			return c.JSON(http.StatusInternalServerError, errMsg.Error())
+
+	req := c.Request()
+	var headers objectContentHeaders
+	var body io.ReadCloser
+	if req.Method == http.MethodHead {
+		output, err := s3Ctrl.Store.HeadObject(&s3.HeadObjectInput{
+			Bucket:            aws.String(bucket),
+			Key:               aws.String(key),
+			IfMatch:           optionalHeader(req, "If-Match"),
+			IfNoneMatch:       optionalHeader(req, "If-None-Match"),
+			IfModifiedSince:   conditionalTimeHeader(req, "If-Modified-Since"),
+			IfUnmodifiedSince: conditionalTimeHeader(req, "If-Unmodified-Since"),
+		})
+		if err != nil {
+			return objectContentError(c, key, err)
 		}
+		headers = objectContentHeaders{output.ContentType, output.ContentLength, nil, output.ContentEncoding, output.CacheControl, output.ETag, output.LastModified}
+	} else {
+		output, err := s3Ctrl.Store.GetObject(&s3.GetObjectInput{
+			Bucket:            aws.String(bucket),
+			Key:               aws.String(key),
+			Range:             optionalHeader(req, "Range"),
+			IfMatch:           optionalHeader(req, "If-Match"),
+			IfNoneMatch:       optionalHeader(req, "If-None-Match"),
+			IfModifiedSince:   conditionalTimeHeader(req, "If-Modified-Since"),
+			IfUnmodifiedSince: conditionalTimeHeader(req, "If-Unmodified-Since"),
+		})
+		if err != nil {
+			return objectContentError(c, key, err)
+		}
+		body = output.Body
+		defer body.Close()
+		headers = objectContentHeaders{output.ContentType, output.ContentLength, output.ContentRange, output.ContentEncoding, output.CacheControl, output.ETag, output.LastModified}
 	}
-	body, err := io.ReadAll(outPutBody)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, err.Error())
+
+	status := http.StatusOK
+	if headers.ContentRange != nil {
+		status = http.StatusPartialContent
 	}
+	headers.write(c.Response().Header())
+	c.Response().WriteHeader(status)
 	log.Info("HandleObjectContents: Successfully fetched object data for key:", key)
-	//TODO: add contentType
-	return c.Blob(http.StatusOK, "", body)
+	if body == nil {
+		return nil
+	}
+	if _, err := io.Copy(c.Response(), body); err != nil {
+		// the status line is already sent, all that is left is to log the aborted transfer
+		log.Errorf("error streaming content of %s: %s", key, err.Error())
+	}
+	return nil
+}
+
+// objectContentError answers a failed GET or HEAD of /object/content, relaying the
+// conditional and range statuses of the store.
+func objectContentError(c echo.Context, key string, err error) error {
+	status := http.StatusInternalServerError
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		switch reqErr.StatusCode() {
+		case http.StatusNotModified:
+			return c.NoContent(http.StatusNotModified)
+		case http.StatusNotFound, http.StatusPreconditionFailed, http.StatusRequestedRangeNotSatisfiable:
+			status = reqErr.StatusCode()
+		}
+	} else if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound") {
+		status = http.StatusNotFound
+	}
+	errMsg := fmt.Errorf("error fetching object's content: %s", err.Error())
+	if status == http.StatusNotFound {
+		errMsg = fmt.Errorf("error fetching object's content: object %s not found", key)
+	}
+	log.Error(errMsg.Error())
+	if c.Request().Method == http.MethodHead {
+		return c.NoContent(status)
+	}
+	return c.JSON(status, errMsg.Error())
 }
@@ -0,0 +1,43 @@
+package blobstore_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestObjectContentRange(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	srv.PutObject("bkt", "data.txt", []byte("0123456789"))
+
+	get := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/object/content?bucket=bkt&key=data.txt", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		if err := bh.HandleObjectContents(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	rec := get("", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "0123456789" {
+		t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+	}
+	rec = get("Range", "bytes=2-5")
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "2345" || rec.Header().Get("Content-Range") != "bytes 2-5/10" {
+		t.Fatalf("got %d %q %q", rec.Code, rec.Body.String(), rec.Header().Get("Content-Range"))
+	}
+	rec = get("If-None-Match", rec.Header().Get("ETag"))
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("got %d for a matching If-None-Match", rec.Code)
+	}
+	rec = get("Range", "bytes=20-30")
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("got %d for a range past the end", rec.Code)
+	}
+}
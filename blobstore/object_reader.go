@@ -0,0 +1,145 @@
+package blobstore
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// readerAtBlockSize is the smallest range objectReaderAt fetches, zip readers issue many small
+// reads next to each other and would otherwise send a request for each of them
+const readerAtBlockSize = 64 * 1024
+
+// objectReaderAt reads an object with ranged GETs, so the parts of an archive that are needed
+// can be read without downloading all of it. Every range is requested with the ETag the object
+// had when the reader was created, a reader fails rather than mixing two versions of an object.
+type objectReaderAt struct {
+	store  ObjectStore
+	bucket string
+	key    string
+	etag   string
+	size   int64
+
+	mu sync.Mutex
+	// budget is how many more bytes may be fetched, reads fail with errSizeLimit once it is spent
+	budget int64
+	// exceeded is set when a read failed because of the budget, the error may be wrapped by then
+	exceeded   bool
+	blockStart int64
+	block      []byte
+}
+
+// newObjectReaderAt returns a reader for the object at key that fetches at most budget bytes.
+func (s3Ctrl *S3Controller) newObjectReaderAt(bucket, key string, budget int64) (*objectReaderAt, error) {
+	head, err := s3Ctrl.Store.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &objectReaderAt{
+		store:  s3Ctrl.Store,
+		bucket: bucket,
+		key:    key,
+		etag:   aws.StringValue(head.ETag),
+		size:   aws.Int64Value(head.ContentLength),
+		budget: budget,
+	}, nil
+}
+
+func (r *objectReaderAt) Size() int64 {
+	return r.size
+}
+
+// Exceeded reports whether a read failed because the budget was spent.
+func (r *objectReaderAt) Exceeded() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.exceeded
+}
+
+func (r *objectReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.size {
+			return n, io.EOF
+		}
+		if pos >= r.blockStart && pos < r.blockStart+int64(len(r.block)) {
+			n += copy(p[n:], r.block[pos-r.blockStart:])
+			continue
+		}
+		if err := r.fetch(pos, int64(len(p)-n)); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// fetch replaces the cached block with the range starting at pos, at least want bytes long unless the object ends first.
+func (r *objectReaderAt) fetch(pos, want int64) error {
+	length := want
+	if length < readerAtBlockSize {
+		length = readerAtBlockSize
+	}
+	if pos+length > r.size {
+		length = r.size - pos
+	}
+	if length > r.budget {
+		r.exceeded = true
+		return errSizeLimit
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(r.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", pos, pos+length-1)),
+	}
+	if r.etag != "" {
+		input.IfMatch = aws.String(r.etag)
+	}
+	output, err := r.store.GetObject(input)
+	if err != nil {
+		return err
+	}
+	defer output.Body.Close()
+	block, err := io.ReadAll(io.LimitReader(output.Body, length))
+	if err != nil {
+		return err
+	}
+	if int64(len(block)) != length {
+		return fmt.Errorf("short read of %s: expected %d bytes at offset %d, got %d", r.key, length, pos, len(block))
+	}
+	r.budget -= length
+	r.blockStart = pos
+	r.block = block
+	return nil
+}
+
+// readObjectHead returns the first n bytes of an object of the given size, or all of it when it is smaller.
+func (s3Ctrl *S3Controller) readObjectHead(bucket, key string, size, n int64) ([]byte, error) {
+	if size == 0 || n <= 0 {
+		return []byte{}, nil
+	}
+	if n > size {
+		n = size
+	}
+	output, err := s3Ctrl.Store.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=0-%d", n-1)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+	return io.ReadAll(io.LimitReader(output.Body, n))
+}
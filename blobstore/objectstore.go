@@ -0,0 +1,55 @@
+package blobstore
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ObjectStore is the set of storage operations the blobstore handlers rely on.
+// The request and response shapes follow the S3 API so that S3 itself, S3-compatible
+// services and non-S3 backends (or test fakes) can all be plugged in behind the handlers.
+// Backends report missing objects with an awserr.Error carrying the "NotFound" code on
+// HEAD and s3.ErrCodeNoSuchKey on GET, matching what the AWS SDK returns.
+type ObjectStore interface {
+	// listing
+	ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error
+
+	// single objects
+	HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
+	GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error)
+	CopyObject(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error)
+	DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
+	DeleteObjects(input *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error)
+
+	// multipart uploads
+	CreateMultipartUpload(input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error)
+	UploadPartCopy(input *s3.UploadPartCopyInput) (*s3.UploadPartCopyOutput, error)
+	CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error)
+	ListPartsPages(input *s3.ListPartsInput, fn func(*s3.ListPartsOutput, bool) bool) error
+	ListMultipartUploadsPages(input *s3.ListMultipartUploadsInput, fn func(*s3.ListMultipartUploadsOutput, bool) bool) error
+
+	// buckets
+	ListBuckets(input *s3.ListBucketsInput) (*s3.ListBucketsOutput, error)
+	HeadBucket(input *s3.HeadBucketInput) (*s3.HeadBucketOutput, error)
+	GetBucketLocation(input *s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error)
+
+	// presigned URLs
+	PresignGetObject(input *s3.GetObjectInput, expire time.Duration) (string, error)
+	PresignPutObject(input *s3.PutObjectInput, expire time.Duration) (string, error)
+	PresignUploadPart(input *s3.UploadPartInput, expire time.Duration) (string, error)
+	PresignPostObject(input *PostPolicyInput, expire time.Duration) (*PresignedPost, error)
+}
+
+// RegionalStore is implemented by stores whose client is bound to a single region
+// and must be re-created to reach buckets that live in another region.
+type RegionalStore interface {
+	Region() string
+	WithRegion(region string) (ObjectStore, error)
+	// OnRegionMismatch registers fn to be called with the bucket of any request rejected because
+	// the bucket lives in another region, stores returned by WithRegion inherit it.
+	OnRegionMismatch(fn func(bucket string))
+}
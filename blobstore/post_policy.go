@@ -0,0 +1,281 @@
+package blobstore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// uploadedByMetadataKey is the user metadata a presigned POST forces to the email of the user it was issued to
+const uploadedByMetadataKey = "uploaded-by"
+
+// PostPolicyInput describes the uploads a presigned POST accepts.
+type PostPolicyInput struct {
+	Bucket string
+	// Key is the key the object is written to, or with KeyPrefix the prefix the key picked by the client must start with
+	Key       string
+	KeyPrefix bool
+	// MinSize and MaxSize bound the size of the upload in bytes
+	MinSize int64
+	MaxSize int64
+	// ContentType is the content type the upload must be sent with. A type ending with a slash such as
+	// image/ accepts every subtype, an empty one accepts any content type.
+	ContentType string
+	// Metadata is the user metadata the upload must carry, with these values
+	Metadata map[string]string
+}
+
+// PresignedPost is where a browser sends a presigned POST and the form fields it must carry, the file
+// goes after them in a field named file.
+type PresignedPost struct {
+	URL     string            `json:"url"`
+	Fields  map[string]string `json:"fields"`
+	Expires time.Time         `json:"expires"`
+}
+
+// postPolicyFields returns the form fields of a presigned POST for input and the policy conditions that
+// cover them. Stores add the fields and conditions of their signature.
+func postPolicyFields(input *PostPolicyInput) (map[string]string, []interface{}) {
+	fields := make(map[string]string)
+	conditions := []interface{}{map[string]string{"bucket": input.Bucket}}
+	if input.KeyPrefix {
+		// the store replaces ${filename} with the name of the uploaded file
+		fields["key"] = input.Key + "${filename}"
+		conditions = append(conditions, []interface{}{"starts-with", "$key", input.Key})
+	} else {
+		fields["key"] = input.Key
+		conditions = append(conditions, map[string]string{"key": input.Key})
+	}
+	conditions = append(conditions, []interface{}{"content-length-range", input.MinSize, input.MaxSize})
+	if input.ContentType == "" || strings.HasSuffix(input.ContentType, "/") {
+		conditions = append(conditions, []interface{}{"starts-with", "$Content-Type", input.ContentType})
+	} else {
+		fields["Content-Type"] = input.ContentType
+		conditions = append(conditions, map[string]string{"Content-Type": input.ContentType})
+	}
+	names := make([]string, 0, len(input.Metadata))
+	for name := range input.Metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := "x-amz-meta-" + strings.ToLower(name)
+		fields[field] = input.Metadata[name]
+		conditions = append(conditions, map[string]string{field: input.Metadata[name]})
+	}
+	return fields, conditions
+}
+
+// postPolicy is the policy document of a presigned POST, in the format S3 expects.
+type postPolicy struct {
+	Expiration string        `json:"expiration"`
+	Conditions []interface{} `json:"conditions"`
+}
+
+// encodePostPolicy returns the base64 encoded policy document that is signed and sent as the policy field.
+func encodePostPolicy(expiration time.Time, conditions []interface{}) (string, error) {
+	doc, err := json.Marshal(postPolicy{
+		Expiration: expiration.UTC().Format("2006-01-02T15:04:05.000Z"),
+		Conditions: conditions,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(doc), nil
+}
+
+// checkPostPolicy verifies the form fields of a POST upload, keyed by their lower case names, against
+// the policy it carries and returns the content-length-range of the policy, maxSize is -1 without one.
+// Like S3, every field other than the policy, the signature and the file must be covered by a condition.
+func checkPostPolicy(policy string, fields map[string]string, now time.Time) (minSize int64, maxSize int64, err error) {
+	maxSize = -1
+	doc, err := base64.StdEncoding.DecodeString(policy)
+	if err != nil {
+		return 0, 0, fmt.Errorf("the policy is not base64 encoded")
+	}
+	var p struct {
+		Expiration string            `json:"expiration"`
+		Conditions []json.RawMessage `json:"conditions"`
+	}
+	if err := json.Unmarshal(doc, &p); err != nil {
+		return 0, 0, fmt.Errorf("the policy is not a JSON policy document: %s", err.Error())
+	}
+	expiration, err := time.Parse(time.RFC3339, p.Expiration)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid policy expiration `%s`", p.Expiration)
+	}
+	if now.After(expiration) {
+		return 0, 0, fmt.Errorf("the policy has expired")
+	}
+
+	covered := make(map[string]bool)
+	for _, raw := range p.Conditions {
+		var exact map[string]string
+		if err := json.Unmarshal(raw, &exact); err == nil {
+			for name, want := range exact {
+				name = strings.ToLower(name)
+				if fields[name] != want {
+					return 0, 0, fmt.Errorf("policy condition failed: [\"eq\", \"$%s\", %q]", name, want)
+				}
+				covered[name] = true
+			}
+			continue
+		}
+		var condition []interface{}
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(&condition); err != nil || len(condition) != 3 {
+			return 0, 0, fmt.Errorf("invalid policy condition %s", string(raw))
+		}
+		op, _ := condition[0].(string)
+		op = strings.ToLower(op)
+		if op == "content-length-range" {
+			low, lowOk := condition[1].(json.Number)
+			high, highOk := condition[2].(json.Number)
+			if !lowOk || !highOk {
+				return 0, 0, fmt.Errorf("invalid policy condition %s", string(raw))
+			}
+			if minSize, err = low.Int64(); err != nil {
+				return 0, 0, fmt.Errorf("invalid policy condition %s", string(raw))
+			}
+			if maxSize, err = high.Int64(); err != nil {
+				return 0, 0, fmt.Errorf("invalid policy condition %s", string(raw))
+			}
+			continue
+		}
+		field, _ := condition[1].(string)
+		want, ok := condition[2].(string)
+		if !strings.HasPrefix(field, "$") || !ok {
+			return 0, 0, fmt.Errorf("invalid policy condition %s", string(raw))
+		}
+		name := strings.ToLower(strings.TrimPrefix(field, "$"))
+		value := fields[name]
+		switch op {
+		case "eq":
+			ok = value == want
+		case "starts-with":
+			ok = strings.HasPrefix(value, want)
+			// a list of content types is accepted when every type starts with the value
+			if name == "content-type" {
+				for _, t := range strings.Split(value, ",") {
+					ok = ok && strings.HasPrefix(strings.TrimSpace(t), want)
+				}
+			}
+		default:
+			return 0, 0, fmt.Errorf("invalid policy condition %s", string(raw))
+		}
+		if !ok {
+			return 0, 0, fmt.Errorf("policy condition failed: %s", string(raw))
+		}
+		covered[name] = true
+	}
+
+	for name := range fields {
+		switch {
+		case name == "policy", name == "x-amz-signature", name == "file", name == strings.ToLower(localSignatureParam):
+		case strings.HasPrefix(name, "x-ignore-"):
+		case !covered[name]:
+			return 0, 0, fmt.Errorf("extra input fields: %s", name)
+		}
+	}
+	return minSize, maxSize, nil
+}
+
+// sizeParam reads a size in bytes between 0 and max, def when it is omitted.
+func sizeParam(c echo.Context, name string, def, max int64) (int64, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 || n > max {
+		return 0, fmt.Errorf("`%s` must be a size in bytes between 0 and %d, got `%s`", name, max, value)
+	}
+	return n, nil
+}
+
+// HandleGetPresignedPost returns a presigned POST policy a browser can upload a file with directly,
+// with limits the store enforces: the object is written to `key`, or under `prefix` with the name of
+// the file, its size is between `min_size` and `max_size` bytes, its content type is `content_type`,
+// or any subtype of a type such as image/, and it carries the email of the user as metadata.
+func (bh *BlobHandler) HandleGetPresignedPost(c echo.Context) error {
+	key := c.QueryParam("key")
+	prefix := c.QueryParam("prefix")
+	if (key == "") == (prefix == "") {
+		errMsg := fmt.Errorf("exactly one of `key` and `prefix` is required")
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+	bucket := c.QueryParam("bucket")
+	s3Ctrl, err := bh.GetController(bucket)
+	if err != nil {
+		errMsg := fmt.Errorf("`bucket` %s is not available, %s", bucket, err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	httpCode, err := bh.CheckUserS3Permission(c, bucket, key+prefix, []string{"write"})
+	if err != nil {
+		errMsg := fmt.Errorf("error while checking for user permission: %s", err)
+		log.Error(errMsg.Error())
+		return c.JSON(httpCode, errMsg.Error())
+	}
+
+	maxSize, err := sizeParam(c, "max_size", bh.Config.PostUploadMaxSize, bh.Config.PostUploadMaxSize)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(http.StatusUnprocessableEntity, err.Error())
+	}
+	minSize, err := sizeParam(c, "min_size", 0, maxSize)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(http.StatusUnprocessableEntity, err.Error())
+	}
+	contentType := c.QueryParam("content_type")
+	if contentType != "" {
+		valid := false
+		if family := strings.TrimSuffix(contentType, "/"); family != contentType {
+			valid = family != "" && !strings.ContainsAny(family, "/,; ")
+		} else {
+			_, _, err := mime.ParseMediaType(contentType)
+			valid = err == nil && strings.Contains(contentType, "/")
+		}
+		if !valid {
+			errMsg := fmt.Errorf("`content_type` must be a content type such as text/csv or a type such as image/, got `%s`", contentType)
+			log.Error(errMsg.Error())
+			return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+		}
+	}
+
+	input := &PostPolicyInput{
+		Bucket:      bucket,
+		Key:         key + prefix,
+		KeyPrefix:   prefix != "",
+		MinSize:     minSize,
+		MaxSize:     maxSize,
+		ContentType: contentType,
+	}
+	if owner := requestOwner(c); owner != "" {
+		input.Metadata = map[string]string{uploadedByMetadataKey: owner}
+	}
+	post, err := s3Ctrl.Store.PresignPostObject(input, time.Duration(bh.Config.DefaultUploadPresignedUrlExpiration)*time.Minute)
+	if err != nil {
+		errMsg := fmt.Errorf("error generating presigned POST: %s", err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusInternalServerError, errMsg.Error())
+	}
+	log.Infof("successfully generated presigned POST for %s", input.Key)
+	return c.JSON(http.StatusOK, post)
+}
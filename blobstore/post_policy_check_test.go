@@ -0,0 +1,93 @@
+package blobstore
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPostPolicy(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fields, conditions := postPolicyFields(&PostPolicyInput{
+		Bucket:      "bkt",
+		Key:         "inbox/",
+		KeyPrefix:   true,
+		MinSize:     1,
+		MaxSize:     100,
+		ContentType: "image/",
+		Metadata:    map[string]string{uploadedByMetadataKey: "user@example.com"},
+	})
+	policy, err := encodePostPolicy(now.Add(time.Hour), conditions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := func(changes map[string]string) map[string]string {
+		f := map[string]string{"bucket": "bkt", "policy": policy, "content-type": "image/png"}
+		for k, v := range fields {
+			f[strings.ToLower(k)] = v
+		}
+		f["key"] = strings.ReplaceAll(f["key"], "${filename}", "a.png")
+		for k, v := range changes {
+			if v == "" {
+				delete(f, k)
+			} else {
+				f[k] = v
+			}
+		}
+		return f
+	}
+
+	minSize, maxSize, err := checkPostPolicy(policy, form(nil), now)
+	if err != nil || minSize != 1 || maxSize != 100 {
+		t.Fatalf("got %d-%d, %v", minSize, maxSize, err)
+	}
+	if _, _, err := checkPostPolicy(policy, form(map[string]string{"content-type": "image/png, image/jpeg"}), now); err != nil {
+		t.Fatalf("a list of types of the family was refused: %s", err.Error())
+	}
+
+	for name, tc := range map[string]struct {
+		fields map[string]string
+		now    time.Time
+		want   string
+	}{
+		"expired":                {now: now.Add(2 * time.Hour), want: "expired"},
+		"eq failure":             {fields: map[string]string{"x-amz-meta-uploaded-by": "other@example.com"}, want: "condition failed"},
+		"missing eq field":       {fields: map[string]string{"x-amz-meta-uploaded-by": ""}, want: "condition failed"},
+		"starts-with on the key": {fields: map[string]string{"key": "outbox/a.png"}, want: "condition failed"},
+		"starts-with on a list":  {fields: map[string]string{"content-type": "image/png, text/plain"}, want: "condition failed"},
+		"other bucket":           {fields: map[string]string{"bucket": "other"}, want: "condition failed"},
+		"extra field":            {fields: map[string]string{"x-amz-meta-extra": "x"}, want: "extra input fields: x-amz-meta-extra"},
+	} {
+		at := now
+		if !tc.now.IsZero() {
+			at = tc.now
+		}
+		if _, _, err := checkPostPolicy(policy, form(tc.fields), at); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want %q", name, err, tc.want)
+		}
+	}
+
+	// ignored fields pass without a condition
+	if _, _, err := checkPostPolicy(policy, form(map[string]string{"x-ignore-note": "x"}), now); err != nil {
+		t.Fatal(err)
+	}
+	// a policy without content-length-range doesn't limit the size
+	open, err := encodePostPolicy(now.Add(time.Hour), []interface{}{map[string]string{"bucket": "bkt"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, maxSize, err := checkPostPolicy(open, map[string]string{"bucket": "bkt"}, now); err != nil || maxSize != -1 {
+		t.Fatalf("got %d, %v", maxSize, err)
+	}
+	for _, doc := range []string{
+		"not base64!",
+		base64.StdEncoding.EncodeToString([]byte(`{"expiration":"tomorrow","conditions":[]}`)),
+		base64.StdEncoding.EncodeToString([]byte(`{"expiration":"2024-05-01T13:00:00Z","conditions":[["content-length-range","a",1]]}`)),
+		base64.StdEncoding.EncodeToString([]byte(`{"expiration":"2024-05-01T13:00:00Z","conditions":[["matches","$key","a"]]}`)),
+	} {
+		if _, _, err := checkPostPolicy(doc, map[string]string{}, now); err == nil {
+			t.Errorf("policy %s was accepted", doc)
+		}
+	}
+}
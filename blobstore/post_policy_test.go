@@ -0,0 +1,151 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Dewberry/s3api/blobstore"
+	"github.com/Dewberry/s3api/config"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// newLocalPostHandler returns a handler serving bucket from a local store, which enforces POST policies.
+func newLocalPostHandler(t *testing.T, bucket string) (*blobstore.LocalStore, *blobstore.BlobHandler) {
+	t.Helper()
+	ls, _ := newLocalStore(t, bucket)
+	srv := httptest.NewServer(ls)
+	t.Cleanup(srv.Close)
+	ls.BaseURL = srv.URL
+	return ls, &blobstore.BlobHandler{
+		S3Controllers: []blobstore.S3Controller{{Store: ls, Buckets: []string{bucket}}},
+		Config:        blobstore.NewConfig(config.Default()),
+	}
+}
+
+// postFile sends a presigned POST with its fields, changed by overrides where a value is set and
+// removed where it is empty, and the file last.
+func postFile(t *testing.T, post blobstore.PresignedPost, overrides map[string]string, filename, contentType string, data []byte) (int, string) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fields := make(map[string]string)
+	for k, v := range post.Fields {
+		fields[k] = v
+	}
+	for k, v := range overrides {
+		if v == "" {
+			delete(fields, k)
+		} else {
+			fields[k] = v
+		}
+	}
+	if contentType != "" {
+		fields["Content-Type"] = contentType
+	}
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	mw.Close()
+	resp, respBody := fetchWithType(t, post.URL, mw.FormDataContentType(), &body)
+	return resp.StatusCode, string(respBody)
+}
+
+func fetchWithType(t *testing.T, url, contentType string, body *bytes.Buffer) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out bytes.Buffer
+	out.ReadFrom(resp.Body)
+	return resp, out.Bytes()
+}
+
+func TestPresignedPostKey(t *testing.T) {
+	ls, bh := newLocalPostHandler(t, "bkt")
+	var post blobstore.PresignedPost
+	rec := serve(t, withClaims(bh.HandleGetPresignedPost, "user@example.com"), http.MethodGet, "/object/presigned_post?bucket=bkt&key=up/a.csv&max_size=10&min_size=2&content_type=text/csv", nil)
+	decode(t, rec, http.StatusOK, &post)
+
+	if status, body := postFile(t, post, nil, "a.csv", "", []byte("a,b")); status != http.StatusNoContent {
+		t.Fatalf("got %d %s", status, body)
+	}
+	head, err := ls.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("bkt"), Key: aws.String("up/a.csv")})
+	if err != nil || aws.StringValue(head.ContentType) != "text/csv" || aws.StringValue(head.Metadata["Uploaded-By"]) != "user@example.com" {
+		t.Fatalf("got %v, %v", head, err)
+	}
+
+	for name, tc := range map[string]struct {
+		overrides   map[string]string
+		contentType string
+		data        string
+	}{
+		"over max_size":         {data: "0123456789a"},
+		"under min_size":        {data: "a"},
+		"other key":             {overrides: map[string]string{"key": "up/b.csv"}, data: "a,b"},
+		"other content type":    {contentType: "text/plain", data: "a,b"},
+		"other metadata":        {overrides: map[string]string{"x-amz-meta-uploaded-by": "other@example.com"}, data: "a,b"},
+		"extra field":           {overrides: map[string]string{"x-amz-meta-extra": "x"}, data: "a,b"},
+		"missing metadata":      {overrides: map[string]string{"x-amz-meta-uploaded-by": ""}, data: "a,b"},
+		"tampered with policy":  {overrides: map[string]string{"policy": "e30="}, data: "a,b"},
+		"missing policy fields": {overrides: map[string]string{"policy": ""}, data: "a,b"},
+	} {
+		if status, _ := postFile(t, post, tc.overrides, "a.csv", tc.contentType, []byte(tc.data)); status < 400 {
+			t.Errorf("%s: got %d", name, status)
+		}
+	}
+}
+
+func TestPresignedPostPrefix(t *testing.T) {
+	ls, bh := newLocalPostHandler(t, "bkt")
+	var post blobstore.PresignedPost
+	decode(t, serve(t, bh.HandleGetPresignedPost, http.MethodGet, "/object/presigned_post?bucket=bkt&prefix=inbox&content_type=image/", nil), http.StatusOK, &post)
+	if post.Fields["key"] != "inbox/${filename}" {
+		t.Fatalf("got key field %q", post.Fields["key"])
+	}
+
+	// ${filename} is replaced with the name of the file, under the prefix
+	if status, body := postFile(t, post, nil, "photo.png", "image/png", []byte("png")); status != http.StatusNoContent {
+		t.Fatalf("got %d %s", status, body)
+	}
+	if _, err := ls.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("bkt"), Key: aws.String("inbox/photo.png")}); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := postFile(t, post, map[string]string{"key": "elsewhere/${filename}"}, "photo.png", "image/png", []byte("png")); status != http.StatusForbidden {
+		t.Fatalf("got %d for a key outside the prefix", status)
+	}
+	if status, _ := postFile(t, post, nil, "notes.txt", "text/plain", []byte("txt")); status != http.StatusForbidden {
+		t.Fatalf("got %d for a content type of another family", status)
+	}
+}
+
+func TestPresignedPostParams(t *testing.T) {
+	_, bh := newLocalPostHandler(t, "bkt")
+	for _, target := range []string{
+		"/object/presigned_post?bucket=bkt",
+		"/object/presigned_post?bucket=bkt&key=a&prefix=b",
+		"/object/presigned_post?bucket=bkt&key=a&max_size=6000000000",
+		"/object/presigned_post?bucket=bkt&key=a&max_size=10&min_size=11",
+		"/object/presigned_post?bucket=bkt&key=a&content_type=csv",
+		"/object/presigned_post?bucket=bkt&key=a&content_type=/",
+	} {
+		decode(t, serve(t, bh.HandleGetPresignedPost, http.MethodGet, target, nil), http.StatusUnprocessableEntity, nil)
+	}
+}
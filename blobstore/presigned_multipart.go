@@ -0,0 +1,256 @@
+package blobstore
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// minUploadPartSize is the smallest part S3 accepts, other than the last part of an upload
+const minUploadPartSize = 5 * 1024 * 1024
+
+// presignedPart is a part of a presigned multipart upload and the URL it is sent to with a PUT.
+type presignedPart struct {
+	PartNumber int64  `json:"partNumber"`
+	Size       int64  `json:"size"`
+	URL        string `json:"url"`
+}
+
+// uploadedPart is a part of a multipart upload the store already holds.
+type uploadedPart struct {
+	PartNumber int64  `json:"partNumber"`
+	ETag       string `json:"eTag"`
+	Size       int64  `json:"size"`
+}
+
+// presignedMultipartUpload lists the presigned URLs of the parts of an upload that are still to be sent.
+type presignedMultipartUpload struct {
+	UploadID string          `json:"uploadId"`
+	Key      string          `json:"key"`
+	Size     int64           `json:"size"`
+	PartSize int64           `json:"partSize"`
+	Expires  time.Time       `json:"expires"`
+	Parts    []presignedPart `json:"parts"`
+	// Completed lists the parts already uploaded, they are listed by a refresh
+	Completed []uploadedPart `json:"completed,omitempty"`
+}
+
+// multipartLayout reads the `size` of a file and the optional `part_size` of its upload. The default part
+// size is the one /object/upload uses for a file of that size, so a refresh given the same size finds the same layout.
+func multipartLayout(c echo.Context) (size int64, partSize int64, partCount int64, err error) {
+	value := c.QueryParam("size")
+	size, err = strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 || size > maxUploadParts*maxUploadPartSize {
+		return 0, 0, 0, fmt.Errorf("`size` must be the size of the file in bytes, at most %d, got `%s`", int64(maxUploadParts*maxUploadPartSize), value)
+	}
+	partSize = uploadPartSize(size, 1)
+	if value := c.QueryParam("part_size"); value != "" {
+		partSize, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || partSize < minUploadPartSize || partSize > maxUploadPartSize {
+			return 0, 0, 0, fmt.Errorf("`part_size` must be between %d and %d bytes, got `%s`", minUploadPartSize, int64(maxUploadPartSize), value)
+		}
+	}
+	partCount = (size + partSize - 1) / partSize
+	if partCount == 0 {
+		// an empty file is sent as one empty part
+		partCount = 1
+	}
+	if partCount > maxUploadParts {
+		return 0, 0, 0, fmt.Errorf("a `part_size` of %d bytes splits the file into %d parts, more than the %d an upload can hold", partSize, partCount, maxUploadParts)
+	}
+	return size, partSize, partCount, nil
+}
+
+// layoutPartSize is the size of part partNumber of a file of size bytes split into parts of partSize bytes.
+func layoutPartSize(size, partSize, partNumber int64) int64 {
+	remaining := size - (partNumber-1)*partSize
+	if remaining > partSize {
+		return partSize
+	}
+	return remaining
+}
+
+// presignParts presigns the URLs of every part of the layout that isn't in uploaded.
+func (s3Ctrl *S3Controller) presignParts(bucket, key, uploadID string, size, partSize, partCount int64, uploaded map[int64]bool, expMin int) ([]presignedPart, error) {
+	parts := []presignedPart{}
+	for partNumber := int64(1); partNumber <= partCount; partNumber++ {
+		if uploaded[partNumber] {
+			continue
+		}
+		url, err := s3Ctrl.GetUploadPartPresignedURL(bucket, key, uploadID, partNumber, expMin, uploadChecksums{})
+		if err != nil {
+			return nil, fmt.Errorf("error presigning part %d: %s", partNumber, err.Error())
+		}
+		parts = append(parts, presignedPart{PartNumber: partNumber, Size: layoutPartSize(size, partSize, partNumber), URL: url})
+	}
+	return parts, nil
+}
+
+// ListUploadedParts returns the parts of a multipart upload the store holds, in part number order.
+func (s3Ctrl *S3Controller) ListUploadedParts(bucket string, key string, uploadID string) ([]*s3.Part, error) {
+	var parts []*s3.Part
+	err := s3Ctrl.Store.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		parts = append(parts, page.Parts...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.Int64Value(parts[i].PartNumber) < aws.Int64Value(parts[j].PartNumber)
+	})
+	return parts, nil
+}
+
+// HandleGetPresignedMultipartUpload starts a multipart upload of a file of `size` bytes and returns its ID
+// together with a presigned URL for every part, so a large file doesn't take a request per part.
+// `part_size` overrides the part size, and `sha256` or `content_md5` are the checksums of the whole file
+// checked when the upload is completed.
+func (bh *BlobHandler) HandleGetPresignedMultipartUpload(c echo.Context) error {
+	key := c.QueryParam("key")
+	if key == "" {
+		errMsg := fmt.Errorf("`key` parameters are required")
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	bucket := c.QueryParam("bucket")
+	s3Ctrl, err := bh.GetController(bucket)
+	if err != nil {
+		errMsg := fmt.Errorf("`bucket` %s is not available, %s", bucket, err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	httpCode, err := bh.CheckUserS3Permission(c, bucket, key, []string{"write"})
+	if err != nil {
+		errMsg := fmt.Errorf("error while checking for user permission: %s", err)
+		log.Error(errMsg.Error())
+		return c.JSON(httpCode, errMsg.Error())
+	}
+	size, partSize, partCount, err := multipartLayout(c)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(http.StatusUnprocessableEntity, err.Error())
+	}
+	checksums, err := parseUploadChecksums(c)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	// uploads with checksums are staged until they are verified, like those of /object/multipart_upload_id
+	uploadKey := key
+	if !checksums.empty() {
+		uploadKey = bh.stagingKey(key)
+	}
+	uploadID, err := s3Ctrl.GetMultiPartUploadID(bucket, uploadKey, checksums, false)
+	if err != nil {
+		errMsg := fmt.Errorf("error retrieving multipart Upload ID: %s", err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusInternalServerError, errMsg.Error())
+	}
+	expMin := bh.Config.DefaultUploadPresignedUrlExpiration
+	parts, err := s3Ctrl.presignParts(bucket, uploadKey, uploadID, size, partSize, partCount, nil, expMin)
+	if err != nil {
+		if abortErr := s3Ctrl.AbortMultipartUpload(bucket, uploadKey, uploadID); abortErr != nil {
+			log.Errorf("error aborting multipart upload %s of %s: %s", uploadID, key, abortErr.Error())
+		}
+		errMsg := fmt.Errorf("error generating presigned part URLs: %s", err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusInternalServerError, errMsg.Error())
+	}
+	if uploadKey != key {
+		uploadID = stagedUploadPrefix + uploadID
+	}
+	log.Infof("successfully generated %d presigned part URLs for key: %s", len(parts), key)
+	return c.JSON(http.StatusOK, presignedMultipartUpload{
+		UploadID: uploadID,
+		Key:      key,
+		Size:     size,
+		PartSize: partSize,
+		Expires:  time.Now().Add(time.Duration(expMin) * time.Minute),
+		Parts:    parts,
+	})
+}
+
+// HandleRefreshPresignedMultipartUpload re-issues the URLs of the parts of `upload_id` that the store doesn't
+// hold yet, for the layout given by the same `size` and `part_size` the upload was started with, and lists
+// the parts already uploaded so the upload can be completed.
+func (bh *BlobHandler) HandleRefreshPresignedMultipartUpload(c echo.Context) error {
+	key := c.QueryParam("key")
+	uploadID := c.QueryParam("upload_id")
+	if key == "" || uploadID == "" {
+		errMsg := fmt.Errorf("`key` and `upload_id` parameters are required")
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	bucket := c.QueryParam("bucket")
+	s3Ctrl, err := bh.GetController(bucket)
+	if err != nil {
+		errMsg := fmt.Errorf("`bucket` %s is not available, %s", bucket, err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	httpCode, err := bh.CheckUserS3Permission(c, bucket, key, []string{"write"})
+	if err != nil {
+		errMsg := fmt.Errorf("error while checking for user permission: %s", err)
+		log.Error(errMsg.Error())
+		return c.JSON(httpCode, errMsg.Error())
+	}
+	size, partSize, partCount, err := multipartLayout(c)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	uploadKey, storeUploadID, _ := bh.uploadTarget(key, uploadID)
+	existing, err := s3Ctrl.ListUploadedParts(bucket, uploadKey, storeUploadID)
+	if err != nil {
+		errMsg := fmt.Errorf("error listing the parts of upload %s: %s", uploadID, err.Error())
+		log.Error(errMsg.Error())
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchUpload {
+			return c.JSON(http.StatusNotFound, errMsg.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, errMsg.Error())
+	}
+	uploaded := make(map[int64]bool)
+	completed := []uploadedPart{}
+	for _, part := range existing {
+		partNumber := aws.Int64Value(part.PartNumber)
+		// a part of another size was sent for a different layout and is sent again
+		if partNumber > partCount || aws.Int64Value(part.Size) != layoutPartSize(size, partSize, partNumber) {
+			continue
+		}
+		uploaded[partNumber] = true
+		completed = append(completed, uploadedPart{PartNumber: partNumber, ETag: aws.StringValue(part.ETag), Size: aws.Int64Value(part.Size)})
+	}
+
+	expMin := bh.Config.DefaultUploadPresignedUrlExpiration
+	parts, err := s3Ctrl.presignParts(bucket, uploadKey, storeUploadID, size, partSize, partCount, uploaded, expMin)
+	if err != nil {
+		errMsg := fmt.Errorf("error generating presigned part URLs: %s", err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusInternalServerError, errMsg.Error())
+	}
+	log.Infof("successfully re-issued %d presigned part URLs for key: %s, %d parts are uploaded", len(parts), key, len(completed))
+	return c.JSON(http.StatusOK, presignedMultipartUpload{
+		UploadID:  uploadID,
+		Key:       key,
+		Size:      size,
+		PartSize:  partSize,
+		Expires:   time.Now().Add(time.Duration(expMin) * time.Minute),
+		Parts:     parts,
+		Completed: completed,
+	})
+}
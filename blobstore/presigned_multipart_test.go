@@ -0,0 +1,156 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type multipartPart struct {
+	PartNumber int64  `json:"partNumber"`
+	Size       int64  `json:"size"`
+	URL        string `json:"url"`
+	ETag       string `json:"eTag"`
+}
+
+type presignedMultipart struct {
+	UploadID  string          `json:"uploadId"`
+	Size      int64           `json:"size"`
+	PartSize  int64           `json:"partSize"`
+	Parts     []multipartPart `json:"parts"`
+	Completed []multipartPart `json:"completed"`
+}
+
+func TestPresignedMultipartLayout(t *testing.T) {
+	_, bh := newTestHandler(t, "bkt")
+	const mb = 1024 * 1024
+	for _, tc := range []struct {
+		query    string
+		partSize int64
+		sizes    []int64
+	}{
+		// an empty file is sent as one empty part
+		{"size=0", 8 * mb, []int64{0}},
+		{"size=" + strconv.Itoa(8*mb), 8 * mb, []int64{8 * mb}},
+		{"size=" + strconv.Itoa(20*mb+3) + "&part_size=" + strconv.Itoa(5*mb), 5 * mb, []int64{5 * mb, 5 * mb, 5 * mb, 5 * mb, 3}},
+		// the default part size fits the file in 10,000 parts
+		{"size=" + strconv.Itoa(100000*mb), 10 * mb, nil},
+	} {
+		var upload presignedMultipart
+		decode(t, serve(t, bh.HandleGetPresignedMultipartUpload, http.MethodGet, "/object/presigned_multipart_upload?bucket=bkt&key=f.bin&"+tc.query, nil), http.StatusOK, &upload)
+		if upload.PartSize != tc.partSize {
+			t.Errorf("%s: got part size %d, want %d", tc.query, upload.PartSize, tc.partSize)
+		}
+		if tc.sizes == nil {
+			if len(upload.Parts) != 10000 {
+				t.Errorf("%s: got %d parts", tc.query, len(upload.Parts))
+			}
+			continue
+		}
+		var sizes []int64
+		for i, part := range upload.Parts {
+			if part.PartNumber != int64(i+1) || part.URL == "" {
+				t.Errorf("%s: got part %+v at %d", tc.query, part, i)
+			}
+			sizes = append(sizes, part.Size)
+		}
+		if len(sizes) != len(tc.sizes) || sizes[len(sizes)-1] != tc.sizes[len(tc.sizes)-1] {
+			t.Errorf("%s: got part sizes %v, want %v", tc.query, sizes, tc.sizes)
+		}
+	}
+
+	for _, query := range []string{
+		"",
+		"size=-1",
+		"size=abc",
+		"size=10&part_size=" + strconv.Itoa(5*mb-1),
+		"size=10&part_size=" + strconv.Itoa(6*1024*mb),
+		// 10,001 parts of 5 MB
+		"size=" + strconv.Itoa(10001*5*mb) + "&part_size=" + strconv.Itoa(5*mb),
+	} {
+		rec := serve(t, bh.HandleGetPresignedMultipartUpload, http.MethodGet, "/object/presigned_multipart_upload?bucket=bkt&key=f.bin&"+query, nil)
+		decode(t, rec, http.StatusUnprocessableEntity, nil)
+	}
+}
+
+func TestRefreshPresignedMultipartUpload(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	partSize := 5 * 1024 * 1024
+	data := bytes.Repeat([]byte("0123456789"), (2*partSize+10)/10)
+	query := "bucket=bkt&key=big.bin&size=" + strconv.Itoa(len(data)) + "&part_size=" + strconv.Itoa(partSize)
+
+	var upload presignedMultipart
+	decode(t, serve(t, bh.HandleGetPresignedMultipartUpload, http.MethodGet, "/object/presigned_multipart_upload?"+query, nil), http.StatusOK, &upload)
+	if len(upload.Parts) != 3 {
+		t.Fatalf("got %d parts", len(upload.Parts))
+	}
+	put := func(part multipartPart, body []byte) string {
+		resp, respBody := fetch(t, http.MethodPut, part.URL, bytes.NewReader(body))
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT part %d: %d %s", part.PartNumber, resp.StatusCode, respBody)
+		}
+		return resp.Header.Get("ETag")
+	}
+	chunk := func(partNumber int64) []byte {
+		end := int(partNumber) * partSize
+		if end > len(data) {
+			end = len(data)
+		}
+		return data[(int(partNumber)-1)*partSize : end]
+	}
+	// the first and last parts arrive, the second one is cut short
+	put(upload.Parts[0], chunk(1))
+	put(upload.Parts[1], chunk(2)[:100])
+	put(upload.Parts[2], chunk(3))
+
+	var refreshed presignedMultipart
+	target := "/object/presigned_multipart_upload/refresh?" + query + "&upload_id=" + upload.UploadID
+	decode(t, serve(t, bh.HandleRefreshPresignedMultipartUpload, http.MethodGet, target, nil), http.StatusOK, &refreshed)
+	if len(refreshed.Parts) != 1 || refreshed.Parts[0].PartNumber != 2 || refreshed.Parts[0].Size != int64(partSize) {
+		t.Fatalf("got parts to send %+v", refreshed.Parts)
+	}
+	if len(refreshed.Completed) != 2 || refreshed.Completed[0].PartNumber != 1 || refreshed.Completed[1].PartNumber != 3 {
+		t.Fatalf("got completed parts %+v", refreshed.Completed)
+	}
+
+	etag := put(refreshed.Parts[0], chunk(2))
+	parts := []map[string]interface{}{
+		{"partNumber": 1, "eTag": refreshed.Completed[0].ETag},
+		{"partNumber": 2, "eTag": etag},
+		{"partNumber": 3, "eTag": refreshed.Completed[1].ETag},
+	}
+	rec := serve(t, bh.HandleCompleteMultipartUpload, http.MethodPost, "/object/complete_multipart_upload?bucket=bkt&key=big.bin", map[string]interface{}{"uploadId": upload.UploadID, "parts": parts})
+	decode(t, rec, http.StatusOK, nil)
+	if got, _ := srv.GetObject("bkt", "big.bin"); !bytes.Equal(got, data) {
+		t.Fatalf("completed object has %d bytes, want %d", len(got), len(data))
+	}
+
+	rec = serve(t, bh.HandleRefreshPresignedMultipartUpload, http.MethodGet, target, nil)
+	decode(t, rec, http.StatusNotFound, nil)
+}
+
+func TestPresignedMultipartUploadStaged(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	data := []byte("small file")
+	query := "bucket=bkt&key=staged.txt&size=" + strconv.Itoa(len(data)) + "&sha256=" + sha256Hex(data)
+
+	var upload presignedMultipart
+	decode(t, serve(t, bh.HandleGetPresignedMultipartUpload, http.MethodGet, "/object/presigned_multipart_upload?"+query, nil), http.StatusOK, &upload)
+	if !strings.HasPrefix(upload.UploadID, "staged:") {
+		t.Fatalf("got upload ID %s", upload.UploadID)
+	}
+	// a refresh finds the staged upload by the ID it was handed out with
+	var refreshed presignedMultipart
+	decode(t, serve(t, bh.HandleRefreshPresignedMultipartUpload, http.MethodGet, "/object/presigned_multipart_upload/refresh?"+query+"&upload_id="+upload.UploadID, nil), http.StatusOK, &refreshed)
+	resp, body := fetch(t, http.MethodPut, refreshed.Parts[0].URL, bytes.NewReader(data))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT: %d %s", resp.StatusCode, body)
+	}
+	complete := map[string]interface{}{"uploadId": upload.UploadID, "parts": []map[string]interface{}{{"partNumber": 1, "eTag": resp.Header.Get("ETag")}}}
+	decode(t, serve(t, bh.HandleCompleteMultipartUpload, http.MethodPost, "/object/complete_multipart_upload?bucket=bkt&key=staged.txt", complete), http.StatusOK, nil)
+	if got, _ := srv.GetObject("bkt", "staged.txt"); !bytes.Equal(got, data) {
+		t.Fatalf("got %q", got)
+	}
+}
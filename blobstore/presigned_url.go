@@ -3,111 +3,137 @@ package blobstore
 import (
 	"bytes"
 	"fmt"
+	"mime"
 	"net/http"
-	"net/url"
-	"path/filepath"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Dewberry/s3api/auth"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/labstack/echo/v4"
 	log "github.com/sirupsen/logrus"
 )
 
+// DownloadURLOptions are the settings of a presigned download URL.
+type DownloadURLOptions struct {
+	Expiration time.Duration
+	// Filename has the URL download the object as an attachment with this name
+	Filename string
+	// ContentType replaces the content type stored with the object
+	ContentType string
+}
+
 func (s3Ctrl *S3Controller) GetDownloadPresignedURL(bucket, key string, expDays int) (string, error) {
-	duration := time.Duration(expDays) * 24 * time.Hour
-	req, _ := s3Ctrl.S3Svc.GetObjectRequest(&s3.GetObjectInput{
+	return s3Ctrl.GetDownloadPresignedURLWithOptions(bucket, key, DownloadURLOptions{Expiration: time.Duration(expDays) * 24 * time.Hour})
+}
+
+func (s3Ctrl *S3Controller) GetDownloadPresignedURLWithOptions(bucket, key string, opts DownloadURLOptions) (string, error) {
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
-	})
-	return req.Presign(duration)
+	}
+	if opts.Filename != "" {
+		input.ResponseContentDisposition = aws.String(attachmentDisposition(opts.Filename))
+	}
+	if opts.ContentType != "" {
+		input.ResponseContentType = aws.String(opts.ContentType)
+	}
+	return s3Ctrl.Store.PresignGetObject(input, opts.Expiration)
 }
 
-// func (s3Ctrl *S3Controller) tarS3Files(r *s3.ListObjectsV2Output, bucket string, outputFile string, prefix string) (err error) {
-// 	uploader := s3manager.NewUploader(s3Ctrl.Sess)
-// 	pr, pw := io.Pipe()
-
-// 	gzipWriter := gzip.NewWriter(pw)
-// 	tarWriter := tar.NewWriter(gzipWriter)
-
-// 	var wg sync.WaitGroup
-// 	wg.Add(1)
-
-// 	go func() {
-// 		defer wg.Done()
-// 		log.Debug("start writing files to:", outputFile)
-// 		_, err := uploader.Upload(&s3manager.UploadInput{
-// 			Bucket: aws.String(bucket),
-// 			Key:    aws.String(outputFile),
-// 			Body:   pr,
-// 		})
-// 		if err != nil {
-// 			log.Errorf("failed to upload tar.gz file to S3: %s", err)
-// 			return
-// 		}
-// 		log.Debug("completed writing files to:", outputFile)
-// 	}()
-
-// 	for _, item := range r.Contents {
-// 		filePath := filepath.Join(strings.TrimPrefix(aws.StringValue(item.Key), prefix))
-// 		copyObj := aws.StringValue(item.Key)
-// 		log.Debugf("copying %s to %s", copyObj, outputFile)
-
-// 		getResp, err := s3Ctrl.S3Svc.GetObject(&s3.GetObjectInput{
-// 			Bucket: aws.String(bucket),
-// 			Key:    aws.String(copyObj),
-// 		})
-// 		if err != nil {
-// 			log.Errorf("failed to download file: %s, error: %s", copyObj, err)
-// 			return err
-// 		}
-// 		defer getResp.Body.Close()
-
-// 		header := &tar.Header{
-// 			Name: filePath,
-// 			Size: *getResp.ContentLength,
-// 			Mode: int64(0644),
-// 		}
-
-// 		err = tarWriter.WriteHeader(header)
-// 		if err != nil {
-// 			log.Errorf("failed to write tar header for file: %s, error: %s", copyObj, err)
-// 			return err
-// 		}
-
-// 		_, err = io.Copy(tarWriter, getResp.Body)
-// 		if err != nil {
-// 			log.Errorf("failed to write file content to tar for file: %s, error: %s", copyObj, err)
-// 			return err
-// 		}
-// 		log.Debugf("completed copying: %s", copyObj)
-// 	}
-
-// 	err = tarWriter.Close()
-// 	if err != nil {
-// 		log.Error("tar close failure:", err)
-// 		return err
-// 	}
-
-// 	err = gzipWriter.Close()
-// 	if err != nil {
-// 		log.Error("gzip close failure:", err)
-// 		return err
-// 	}
-
-// 	err = pw.Close()
-// 	if err != nil {
-// 		log.Error("pipe writer close failure:", err)
-// 		return err
-// 	}
-
-// 	wg.Wait()
-
-// 	log.Debug("completed tar of file successfully")
-// 	return nil
-// }
+// attachmentDisposition returns the Content-Disposition downloading a file as filename, it is empty
+// when filename can't be encoded.
+func attachmentDisposition(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
+
+// downloadURLExpiration returns the expiry of the download URLs handed to the caller and the longest expiry
+// they may ask for. The longest is the largest maximum among their roles, where the roles of the service
+// without a configured maximum count with the default expiry, and the default never exceeds it. Callers
+// without any of these roles get the default expiry.
+func (bh *BlobHandler) downloadURLExpiration(c echo.Context) (time.Duration, time.Duration) {
+	defaultExp := time.Duration(bh.Config.DefaultDownloadPresignedUrlExpiration) * 24 * time.Hour
+	maxExp := defaultExp
+	if claims, ok := c.Get("claims").(*auth.Claims); ok {
+		var roleMax time.Duration
+		for _, role := range claims.RealmAccess["roles"] {
+			d, ok := bh.Config.MaxDownloadPresignedUrlExpiration[role]
+			if !ok && bh.isServiceRole(role) {
+				d = defaultExp
+			}
+			if d > roleMax {
+				roleMax = d
+			}
+		}
+		if roleMax > 0 {
+			maxExp = roleMax
+		}
+	}
+	if defaultExp > maxExp {
+		defaultExp = maxExp
+	}
+	return defaultExp, maxExp
+}
+
+// isServiceRole reports whether role is one of the roles the endpoints are authorized for, as opposed to
+// the other roles of the identity provider such as offline_access.
+func (bh *BlobHandler) isServiceRole(role string) bool {
+	if role == "" {
+		return false
+	}
+	for _, name := range []string{bh.Config.AdminRoleName, bh.Config.WriterRoleName, bh.Config.ReaderRoleName, bh.Config.LimitedWriterRoleName, bh.Config.LimitedReaderRoleName} {
+		if role == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseExpiresIn reads an expiry such as 30m or 12h, which can't be longer than maxExp.
+func parseExpiresIn(value string, maxExp time.Duration) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("`expires_in` must be a duration such as 30m or 12h, got `%s`", value)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("`expires_in` must be positive, got `%s`", value)
+	}
+	if d > maxExp {
+		return 0, fmt.Errorf("`expires_in` can't be longer than %s", maxExp)
+	}
+	return d, nil
+}
+
+// downloadURLOptions reads the `expires_in`, `filename` and `content_type` parameters of a download URL request.
+func (bh *BlobHandler) downloadURLOptions(c echo.Context) (DownloadURLOptions, error) {
+	defaultExp, maxExp := bh.downloadURLExpiration(c)
+	opts := DownloadURLOptions{
+		Expiration:  defaultExp,
+		Filename:    c.QueryParam("filename"),
+		ContentType: c.QueryParam("content_type"),
+	}
+	if value := c.QueryParam("expires_in"); value != "" {
+		d, err := parseExpiresIn(value, maxExp)
+		if err != nil {
+			return opts, err
+		}
+		opts.Expiration = d
+	}
+	if opts.Filename != "" && attachmentDisposition(opts.Filename) == "" {
+		return opts, fmt.Errorf("`filename` %s can't be used as a file name", opts.Filename)
+	}
+	if opts.ContentType != "" {
+		if _, _, err := mime.ParseMediaType(opts.ContentType); err != nil {
+			return opts, fmt.Errorf("`content_type` %s is not a media type: %s", opts.ContentType, err.Error())
+		}
+	}
+	return opts, nil
+}
 
 func (bh *BlobHandler) HandleGetPresignedDownloadURL(c echo.Context) error {
 	bucket := c.QueryParam("bucket")
@@ -124,6 +150,11 @@ func (bh *BlobHandler) HandleGetPresignedDownloadURL(c echo.Context) error {
 		log.Error(errMsg.Error())
 		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
 	}
+	opts, err := bh.downloadURLOptions(c)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(http.StatusUnprocessableEntity, err.Error())
+	}
 	permissions, fullAccess, statusCode, err := bh.GetS3ReadPermissions(c, bucket)
 	if err != nil {
 		log.Error(err.Error())
@@ -146,9 +177,7 @@ func (bh *BlobHandler) HandleGetPresignedDownloadURL(c echo.Context) error {
 		log.Error(errMsg.Error())
 		return c.JSON(http.StatusNotFound, errMsg.Error())
 	}
-	// Set the expiration time for the pre-signed URL
-
-	url, err := s3Ctrl.GetDownloadPresignedURL(bucket, key, bh.Config.DefaultDownloadPresignedUrlExpiration)
+	url, err := s3Ctrl.GetDownloadPresignedURLWithOptions(bucket, key, opts)
 	if err != nil {
 		errMsg := fmt.Errorf("error getting presigned URL: %s", err.Error())
 		log.Error(errMsg.Error())
@@ -159,106 +188,28 @@ func (bh *BlobHandler) HandleGetPresignedDownloadURL(c echo.Context) error {
 	return c.JSON(http.StatusOK, url)
 }
 
-// func (bh *BlobHandler) HandleGetPresignedURLMultiObj(c echo.Context) error {
-// 	prefix := c.QueryParam("prefix")
-// 	if prefix == "" {
-// 		errMsg := fmt.Errorf("request must include a `prefix` parameter")
-// 		log.Error(errMsg.Error())
-// 		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
-// 	}
-
-// 	bucket := c.QueryParam("bucket")
-// 	s3Ctrl, err := bh.GetController(bucket)
-// 	if err != nil {
-// 		errMsg := fmt.Errorf("`bucket` %s is not available, %s", bucket, err.Error())
-// 		log.Error(errMsg.Error())
-// 		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
-// 	}
-
-// 	if !strings.HasSuffix(prefix, "/") {
-// 		prefix = prefix + "/"
-// 	}
-
-// 	response, err := s3Ctrl.GetList(bucket, prefix, false)
-// 	if err != nil {
-// 		errMsg := fmt.Errorf("error getting list: %s", err.Error())
-// 		log.Error(errMsg.Error())
-// 		return c.JSON(http.StatusInternalServerError, errMsg.Error())
-// 	}
-// 	if *response.KeyCount == 0 {
-// 		errMsg := fmt.Errorf("the specified prefix %s does not exist in S3", prefix)
-// 		log.Error(errMsg.Error())
-// 		return c.JSON(http.StatusNotFound, errMsg.Error())
-// 	}
-// 	//check if size is below 5GB
-// 	var size, fileCount uint64
-// 	err = bh.GetSize(response, &size, &fileCount)
-// 	if err != nil {
-// 		errMsg := fmt.Errorf("error getting size: %s", err.Error())
-// 		log.Error(errMsg.Error())
-// 		return c.JSON(http.StatusInternalServerError, errMsg.Error())
-// 	}
-
-// 	limit := uint64(1024 * 1024 * 1024 * bh.Config.DefaultZipDownloadSizeLimit)
-// 	if size >= limit {
-// 		errMsg := fmt.Errorf("request entity is larger than %v GB, current prefix size is: %v GB", bh.Config.DefaultZipDownloadSizeLimit, float64(size)/(1024*1024*1024))
-// 		log.Error(errMsg.Error())
-// 		return c.JSON(http.StatusRequestEntityTooLarge, errMsg.Error())
-// 	}
-
-// 	filename := fmt.Sprintf("%s.%s", strings.TrimSuffix(prefix, "/"), "tar.gz")
-// 	outputFile := filepath.Join(bh.Config.DefaultTempPrefix, filename)
-
-// 	// Check if the tar.gz file already exists in S3
-// 	tarFileResponse, err := s3Ctrl.GetList(bucket, outputFile, false)
-// 	if err != nil {
-// 		errMsg := fmt.Errorf("error checking if tar.gz file exists in S3: %s", err)
-// 		log.Error(errMsg.Error())
-// 		return c.JSON(http.StatusInternalServerError, errMsg.Error())
-// 	}
-
-// 	if len(tarFileResponse.Contents) > 0 {
-// 		log.Debug("the prefix was once downloaded, checking if it is outdated")
-// 		// Tar.gz file exists, now compare modification dates
-// 		mostRecentModTime, err := s3Ctrl.getMostRecentModTime(bucket, prefix)
-// 		if err != nil {
-// 			errMsg := fmt.Errorf("error getting most recent modification time: %s", err)
-// 			log.Error(errMsg.Error())
-// 			return c.JSON(http.StatusInternalServerError, errMsg.Error())
-// 		}
-
-// 		if tarFileResponse.Contents[0].LastModified.After(mostRecentModTime) {
-// 			log.Debug("folder already downloaded and is current")
-
-// 			// Existing tar.gz file is up-to-date, return pre-signed URL
-// 			href, err := s3Ctrl.GetDownloadPresignedURL(bucket, outputFile, bh.Config.DefaultDownloadPresignedUrlExpiration)
-// 			if err != nil {
-// 				errMsg := fmt.Errorf("error getting presigned: %s", err)
-// 				log.Error(errMsg.Error())
-// 				return c.JSON(http.StatusInternalServerError, errMsg.Error())
-// 			}
-// 			return c.JSON(http.StatusOK, string(href))
-// 		}
-// 		log.Debug("folder already downloaded but is outdated starting the zip process")
-// 	}
-
-// 	err = s3Ctrl.tarS3Files(response, bucket, outputFile, prefix)
-// 	if err != nil {
-// 		errMsg := fmt.Errorf("error tarring S3 files: %s", err)
-// 		log.Error(errMsg.Error())
-// 		return c.JSON(http.StatusInternalServerError, errMsg.Error())
-// 	}
-
-// 	href, err := s3Ctrl.GetDownloadPresignedURL(bucket, outputFile, bh.Config.DefaultDownloadPresignedUrlExpiration)
-// 	if err != nil {
-// 		errMsg := fmt.Errorf("error getting presigned URL: %s", err)
-// 		log.Error(errMsg.Error())
-// 		return c.JSON(http.StatusInternalServerError, errMsg.Error())
-// 	}
-
-// 	log.Info("successfully generated presigned URL for prefix:", prefix)
-// 	return c.JSON(http.StatusOK, string(href))
-// }
+// uploadDownloadScript writes script to the download_scripts directory of the temp prefix under name
+// and returns a presigned URL to it that is valid for one day.
+func (bh *BlobHandler) uploadDownloadScript(s3Ctrl *S3Controller, bucket, name string, format scriptFormat, script string) (string, error) {
+	outputFile := path.Join(bh.Config.DefaultTempPrefix, "download_scripts", name)
+
+	//upload script to s3
+	_, err := s3Ctrl.Store.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(outputFile),
+		Body:        bytes.NewReader(format.render(script)),
+		ContentType: aws.String(format.contentType),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error uploading %s to S3: %s", name, err.Error())
+	}
+
+	href, err := s3Ctrl.GetDownloadPresignedURL(bucket, outputFile, 1)
+	if err != nil {
+		return "", fmt.Errorf("error generating presigned URL for %s: %s", name, err)
+	}
+	return href, nil
+}
 
 func (bh *BlobHandler) HandleGenerateDownloadScript(c echo.Context) error {
 	prefix := c.QueryParam("prefix")
@@ -267,6 +218,26 @@ func (bh *BlobHandler) HandleGenerateDownloadScript(c echo.Context) error {
 		log.Error(errMsg.Error())
 		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
 	}
+	formatName := c.QueryParam("format")
+	if formatName == "" {
+		formatName = defaultScriptFormat
+	}
+	format, ok := scriptFormats[formatName]
+	if !ok {
+		errMsg := fmt.Errorf("`format` must be one of %s", strings.Join(scriptFormatNames, ", "))
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	checksums := false
+	if value := c.QueryParam("checksums"); value != "" {
+		var err error
+		checksums, err = strconv.ParseBool(value)
+		if err != nil {
+			errMsg := fmt.Errorf("error parsing `checksums` parameter: %s", err.Error())
+			log.Error(errMsg.Error())
+			return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+		}
+	}
 	bucket := c.QueryParam("bucket")
 	s3Ctrl, err := bh.GetController(bucket)
 	if err != nil {
@@ -274,95 +245,220 @@ func (bh *BlobHandler) HandleGenerateDownloadScript(c echo.Context) error {
 		log.Error(errMsg.Error())
 		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
 	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
 
 	var totalSize uint64
 	var scriptBuilder strings.Builder
-	createdDirs := make(map[string]bool)
-	basePrefix := filepath.Base(strings.TrimSuffix(prefix, "/"))
-	scriptBuilder.WriteString("REM Download Instructions\n")
-	scriptBuilder.WriteString("REM To download the selected directory or file, please follow these steps:\n\n")
-	scriptBuilder.WriteString("REM 1. Locate the Downloaded File: Find the file you just downloaded. It should have a .txt file extension.\n")
-	scriptBuilder.WriteString("REM 2. Script Location Adjustment: For flexibility in file downloads, relocate the script to the target directory where you want the files to be downloaded. This can be done by moving the script file to the desired directory in your file system.\n")
-	scriptBuilder.WriteString("REM 3. Rename the File: Right-click on the file, select \"Rename,\" and change the file extension from \".txt\" to \".bat.\" For example, if the file is named \"script.txt,\" rename it to \"script.bat.\"\n")
-	scriptBuilder.WriteString("REM 4. Initiate the Download: Double-click the renamed \".bat\" file to initiate the download process. Windows might display a warning message to protect your PC.\n")
-	scriptBuilder.WriteString("REM 5. Windows Defender SmartScreen (Optional): If you see a message like \"Windows Defender SmartScreen prevented an unrecognized app from starting,\" click \"More info\" and then click \"Run anyway\" to proceed with the download.\n\n")
-	scriptBuilder.WriteString(fmt.Sprintf("mkdir \"%s\"\n", basePrefix))
+	basePrefix := path.Base(strings.TrimSuffix(prefix, "/"))
+	scriptFileName := strings.TrimSuffix(prefix, "/") + format.suffix
+	scriptBuilder.WriteString(format.header(path.Base(scriptFileName)))
 
 	permissions, fullAccess, statusCode, err := bh.GetS3ReadPermissions(c, bucket)
 	if err != nil {
 		log.Error(err.Error())
 		return c.JSON(statusCode, err.Error())
 	}
-	// Define the processPage function
-	processPage := func(page *s3.ListObjectsV2Output) error {
-		for _, item := range page.Contents {
-			if fullAccess || IsPermittedPrefix(bucket, *item.Key, permissions) {
-
-				// Size checking
-				if item.Size != nil {
-					totalSize += uint64(*item.Size)
-					if totalSize > uint64(bh.Config.DefaultScriptDownloadSizeLimit*1024*1024*1024) {
-						return fmt.Errorf("size limit of %d GB exceeded", bh.Config.DefaultScriptDownloadSizeLimit)
-					}
-
-				}
-
-				// Script generation logic (replicating your directory creation and URL logic)
-				relativePath := strings.TrimPrefix(*item.Key, filepath.Dir(prefix)+"/")
-				dirPath := filepath.Join(basePrefix, filepath.Dir(relativePath))
-				if _, exists := createdDirs[dirPath]; !exists && dirPath != basePrefix {
-					scriptBuilder.WriteString(fmt.Sprintf("mkdir \"%s\"\n", dirPath))
-					createdDirs[dirPath] = true
-				}
-
-				fullPath := filepath.Join(basePrefix, relativePath)
-				presignedURL, err := s3Ctrl.GetDownloadPresignedURL(bucket, *item.Key, bh.Config.DefaultDownloadPresignedUrlExpiration)
-				if err != nil {
-					return fmt.Errorf("error generating presigned URL for object %s: %v", *item.Key, err)
-				}
-				url, err := url.QueryUnescape(presignedURL)
-				if err != nil {
-					return fmt.Errorf("error unescaping URL encoding: %v", err)
-				}
-				encodedURL := strings.ReplaceAll(url, " ", "%20")
-				scriptBuilder.WriteString(fmt.Sprintf("if exist \"%s\" (echo skipping existing file) else (curl -v -o \"%s\" \"%s\")\n", fullPath, fullPath, encodedURL))
-			}
+	// Objects are downloaded into a directory named after the prefix, and verified against their manifest entry.
+	// The sizes come with the listing, the sha256 of every object is only looked up when `checksums` is set.
+	urlExpiration, _ := bh.downloadURLExpiration(c)
+	err = s3Ctrl.walkManifest(bucket, prefix, permissions, fullAccess, urlExpiration, checksums, func(entry manifestEntry) error {
+		totalSize += uint64(entry.Size)
+		if totalSize > uint64(bh.Config.DefaultScriptDownloadSizeLimit*1024*1024*1024) {
+			return fmt.Errorf("size limit of %d GB exceeded", bh.Config.DefaultScriptDownloadSizeLimit)
 		}
+		scriptBuilder.WriteString(format.file(path.Join(basePrefix, entry.Path), entry))
 		return nil
-	}
-
-	// Call GetList with the processPage function
-	err = s3Ctrl.GetListWithCallBack(bucket, prefix, false, processPage)
+	})
 	if err != nil {
 		errMsg := fmt.Errorf("error processing objects: %s", err.Error())
 		log.Error(errMsg.Error())
 		return c.JSON(http.StatusInternalServerError, errMsg.Error())
 	}
+	scriptBuilder.WriteString(format.footer)
 
-	txtBatFileName := fmt.Sprintf("%s_download_script.txt", strings.TrimSuffix(prefix, "/"))
-	outputFile := filepath.Join(bh.Config.DefaultTempPrefix, "download_scripts", txtBatFileName)
+	href, err := bh.uploadDownloadScript(s3Ctrl, bucket, scriptFileName, format, scriptBuilder.String())
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
 
-	//upload script to s3
-	uploader := s3manager.NewUploader(s3Ctrl.Sess)
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket:      aws.String(bucket),
-		Key:         aws.String(outputFile),
-		Body:        bytes.NewReader([]byte(scriptBuilder.String())),
-		ContentType: aws.String("binary/octet-stream"),
+	log.Infof("successfully generated download script for prefix %s in bucket %s", prefix, bucket)
+	return c.JSON(http.StatusOK, href)
+}
+
+// maxBatchKeys caps the keys of a batch presign request
+const maxBatchKeys = 1000
+
+// batchPresignConcurrency is how many keys of a batch are checked at the same time
+const batchPresignConcurrency = 16
+
+// presignedURLResult is the outcome for one key of a batch, a presigned URL or the error with its HTTP status.
+type presignedURLResult struct {
+	Key    string `json:"key"`
+	URL    string `json:"url,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// entry describes the object for download scripts
+	entry manifestEntry
+}
+
+// presignKey checks that the caller may read key and that it exists before presigning it.
+func (s3Ctrl *S3Controller) presignKey(bucket, key string, permissions []string, fullAccess bool, expiration time.Duration) presignedURLResult {
+	result := presignedURLResult{Key: key}
+	if !fullAccess && !IsPermittedPrefix(bucket, key, permissions) {
+		result.Status = http.StatusForbidden
+		result.Error = fmt.Sprintf("user does not have permission to read the %s key", key)
+		return result
+	}
+	head, err := s3Ctrl.Store.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
 	})
 	if err != nil {
-		errMsg := fmt.Errorf("error uploading %s to S3: %s", txtBatFileName, err.Error())
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+			result.Status = http.StatusNotFound
+			result.Error = fmt.Sprintf("object %s not found", key)
+			return result
+		}
+		result.Status = http.StatusInternalServerError
+		result.Error = fmt.Sprintf("checking if object exists: %s", err.Error())
+		return result
+	}
+	url, err := s3Ctrl.GetDownloadPresignedURLWithOptions(bucket, key, DownloadURLOptions{Expiration: expiration})
+	if err != nil {
+		result.Status = http.StatusInternalServerError
+		result.Error = fmt.Sprintf("error getting presigned URL: %s", err.Error())
+		return result
+	}
+	result.Status = http.StatusOK
+	result.URL = url
+	result.entry = manifestEntry{
+		Path:         key,
+		Key:          key,
+		Size:         aws.Int64Value(head.ContentLength),
+		ETag:         strings.Trim(aws.StringValue(head.ETag), "\""),
+		LastModified: aws.TimeValue(head.LastModified),
+		StorageClass: aws.StringValue(head.StorageClass),
+		SHA256:       strings.ToLower(metadataValue(head.Metadata, sha256MetadataKey)),
+		URL:          url,
+	}
+	return result
+}
+
+// HandleGetPresignedDownloadURLs presigns a list of keys in one request, reporting an error for each key that
+// can't be read instead of failing the whole batch. With a `format` it also returns a download script for the
+// keys that were presigned.
+func (bh *BlobHandler) HandleGetPresignedDownloadURLs(c echo.Context) error {
+	type PresignRequest struct {
+		Keys      []string `json:"keys"`
+		Format    string   `json:"format"`
+		ExpiresIn string   `json:"expires_in"`
+	}
+	var presignRequest PresignRequest
+	if err := c.Bind(&presignRequest); err != nil {
+		errMsg := fmt.Errorf("error parsing request body: %s", err.Error())
 		log.Error(errMsg.Error())
-		return c.JSON(http.StatusInternalServerError, errMsg.Error())
+		return c.JSON(http.StatusBadRequest, errMsg.Error())
+	}
+	if len(presignRequest.Keys) == 0 {
+		errMsg := fmt.Errorf("no keys to presign. Please provide 'keys' in the request body")
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	if len(presignRequest.Keys) > maxBatchKeys {
+		errMsg := fmt.Errorf("at most %d keys can be presigned per request, got %d", maxBatchKeys, len(presignRequest.Keys))
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	var format scriptFormat
+	if presignRequest.Format != "" {
+		var ok bool
+		format, ok = scriptFormats[presignRequest.Format]
+		if !ok {
+			errMsg := fmt.Errorf("`format` must be one of %s", strings.Join(scriptFormatNames, ", "))
+			log.Error(errMsg.Error())
+			return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+		}
 	}
 
-	href, err := s3Ctrl.GetDownloadPresignedURL(bucket, outputFile, 1)
+	expiration, maxExp := bh.downloadURLExpiration(c)
+	if presignRequest.ExpiresIn != "" {
+		d, err := parseExpiresIn(presignRequest.ExpiresIn, maxExp)
+		if err != nil {
+			log.Error(err.Error())
+			return c.JSON(http.StatusUnprocessableEntity, err.Error())
+		}
+		expiration = d
+	}
+
+	bucket := c.QueryParam("bucket")
+	s3Ctrl, err := bh.GetController(bucket)
 	if err != nil {
-		errMsg := fmt.Errorf("error generating presigned URL for %s: %s", txtBatFileName, err)
+		errMsg := fmt.Errorf("`bucket` %s is not available, %s", bucket, err.Error())
 		log.Error(errMsg.Error())
-		return c.JSON(http.StatusInternalServerError, errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	permissions, fullAccess, statusCode, err := bh.GetS3ReadPermissions(c, bucket)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(statusCode, err.Error())
 	}
 
-	log.Infof("successfully generated download script for prefix %s in bucket %s", prefix, bucket)
-	return c.JSON(http.StatusOK, href)
+	results := make([]presignedURLResult, len(presignRequest.Keys))
+	sem := make(chan struct{}, batchPresignConcurrency)
+	var wg sync.WaitGroup
+	for i, key := range presignRequest.Keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = s3Ctrl.presignKey(bucket, key, permissions, fullAccess, expiration)
+		}(i, strings.TrimPrefix(key, "/"))
+	}
+	wg.Wait()
+
+	response := struct {
+		Results []presignedURLResult `json:"results"`
+		Script  string               `json:"script,omitempty"`
+	}{Results: results}
+
+	if presignRequest.Format != "" {
+		id, err := newRandomID()
+		if err != nil {
+			errMsg := fmt.Errorf("error naming download script: %s", err.Error())
+			log.Error(errMsg.Error())
+			return c.JSON(http.StatusInternalServerError, errMsg.Error())
+		}
+		scriptFileName := path.Join("selections", id, "selection"+format.suffix)
+
+		var totalSize uint64
+		var scriptBuilder strings.Builder
+		scriptBuilder.WriteString(format.header(path.Base(scriptFileName)))
+		for _, result := range results {
+			if result.Status != http.StatusOK {
+				continue
+			}
+			totalSize += uint64(result.entry.Size)
+			if totalSize > uint64(bh.Config.DefaultScriptDownloadSizeLimit*1024*1024*1024) {
+				errMsg := fmt.Errorf("size limit of %d GB exceeded", bh.Config.DefaultScriptDownloadSizeLimit)
+				log.Error(errMsg.Error())
+				return c.JSON(http.StatusRequestEntityTooLarge, errMsg.Error())
+			}
+			// objects keep their key as path so keys of different prefixes don't collide
+			scriptBuilder.WriteString(format.file(result.Key, result.entry))
+		}
+		scriptBuilder.WriteString(format.footer)
+
+		response.Script, err = bh.uploadDownloadScript(s3Ctrl, bucket, scriptFileName, format, scriptBuilder.String())
+		if err != nil {
+			log.Error(err.Error())
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	log.Infof("successfully presigned a batch of %d key(s) in bucket %s", len(results), bucket)
+	return c.JSON(http.StatusOK, response)
 }
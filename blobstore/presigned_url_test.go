@@ -0,0 +1,160 @@
+package blobstore_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPresignedDownloadURL(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	srv.PutObject("bkt", "deep/nested/data.csv", []byte("a,b\n1,2\n"))
+
+	var url string
+	target := "/object/download?bucket=bkt&key=deep/nested/data.csv&filename=report.csv&content_type=text/csv&expires_in=30m"
+	decode(t, serve(t, bh.HandleGetPresignedDownloadURL, http.MethodGet, target, nil), http.StatusOK, &url)
+	resp, body := fetch(t, http.MethodGet, url, nil)
+	if resp.StatusCode != http.StatusOK || string(body) != "a,b\n1,2\n" {
+		t.Fatalf("GET presigned URL: %d %q", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Content-Disposition"); got != "attachment; filename=report.csv" {
+		t.Fatalf("got Content-Disposition %q", got)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "text/csv" {
+		t.Fatalf("got Content-Type %q", got)
+	}
+
+	for target, status := range map[string]int{
+		"/object/download?bucket=bkt&key=deep/missing.csv":                        http.StatusNotFound,
+		"/object/download?bucket=bkt":                                             http.StatusUnprocessableEntity,
+		"/object/download?bucket=bkt&key=deep/nested/data.csv&expires_in=forever": http.StatusUnprocessableEntity,
+		"/object/download?bucket=bkt&key=deep/nested/data.csv&expires_in=-1h":     http.StatusUnprocessableEntity,
+	} {
+		decode(t, serve(t, bh.HandleGetPresignedDownloadURL, http.MethodGet, target, nil), status, nil)
+	}
+}
+
+func TestDownloadURLExpirationPerRole(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	srv.PutObject("bkt", "data.csv", []byte("a,b\n"))
+	bh.Config.DefaultDownloadPresignedUrlExpiration = 7
+	bh.Config.AdminRoleName = "s3_admin"
+	bh.Config.LimitedReaderRoleName = "s3_limited_reader"
+	bh.Config.MaxDownloadPresignedUrlExpiration = map[string]time.Duration{"s3_limited_reader": time.Hour}
+
+	for _, tc := range []struct {
+		expiresIn string
+		roles     []string
+		status    int
+	}{
+		// the admin role has no cap of its own, so it counts with the default expiry
+		{"48h", []string{"s3_admin", "s3_limited_reader"}, http.StatusOK},
+		{"168h", []string{"s3_admin", "s3_limited_reader"}, http.StatusOK},
+		{"169h", []string{"s3_admin", "s3_limited_reader"}, http.StatusUnprocessableEntity},
+		{"30m", []string{"s3_limited_reader"}, http.StatusOK},
+		{"2h", []string{"s3_limited_reader"}, http.StatusUnprocessableEntity},
+		// roles of the identity provider that the service doesn't use are ignored
+		{"2h", []string{"s3_limited_reader", "offline_access"}, http.StatusUnprocessableEntity},
+		{"48h", []string{"offline_access"}, http.StatusOK},
+	} {
+		handler := withClaims(bh.HandleGetPresignedDownloadURL, "", tc.roles...)
+		rec := serve(t, handler, http.MethodGet, "/object/download?bucket=bkt&key=data.csv&expires_in="+tc.expiresIn, nil)
+		if rec.Code != tc.status {
+			t.Errorf("expires_in=%s with roles %s: got status %d, want %d: %s", tc.expiresIn, strings.Join(tc.roles, ","), rec.Code, tc.status, rec.Body.String())
+		}
+	}
+}
+
+type batchPresignResponse struct {
+	Results []struct {
+		Key    string `json:"key"`
+		URL    string `json:"url"`
+		Status int    `json:"status"`
+		Error  string `json:"error"`
+	} `json:"results"`
+	Script string `json:"script"`
+}
+
+func TestPresignedDownloadURLs(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	srv.PutObject("bkt", "a/one.txt", []byte("one"))
+	srv.PutObject("bkt", "b/two.txt", []byte("two"))
+
+	var response batchPresignResponse
+	body := map[string]interface{}{"keys": []string{"b/two.txt", "/a/one.txt", "a/missing.txt"}}
+	decode(t, serve(t, bh.HandleGetPresignedDownloadURLs, http.MethodPost, "/download_keys?bucket=bkt", body), http.StatusOK, &response)
+	if len(response.Results) != 3 || response.Script != "" {
+		t.Fatalf("got %+v", response)
+	}
+	// results keep the order of the request
+	for i, want := range []struct {
+		key     string
+		status  int
+		content string
+	}{
+		{"b/two.txt", http.StatusOK, "two"},
+		{"a/one.txt", http.StatusOK, "one"},
+		{"a/missing.txt", http.StatusNotFound, ""},
+	} {
+		result := response.Results[i]
+		if result.Key != want.key || result.Status != want.status {
+			t.Fatalf("result %d: got %+v, want %s with %d", i, result, want.key, want.status)
+		}
+		if want.status != http.StatusOK {
+			if result.URL != "" || result.Error == "" {
+				t.Fatalf("result %d: got %+v for a missing key", i, result)
+			}
+			continue
+		}
+		if resp, data := fetch(t, http.MethodGet, result.URL, nil); resp.StatusCode != http.StatusOK || string(data) != want.content {
+			t.Fatalf("GET %s: %d %q", result.Key, resp.StatusCode, data)
+		}
+	}
+
+	keys := make([]string, 1001)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("k/%d", i)
+	}
+	for _, body := range []interface{}{
+		map[string]interface{}{"keys": keys},
+		map[string]interface{}{"keys": []string{}},
+		map[string]interface{}{"keys": []string{"a/one.txt"}, "format": "zip"},
+	} {
+		decode(t, serve(t, bh.HandleGetPresignedDownloadURLs, http.MethodPost, "/download_keys?bucket=bkt", body), http.StatusUnprocessableEntity, nil)
+	}
+	// a full batch is accepted, every missing key reported on its own
+	decode(t, serve(t, bh.HandleGetPresignedDownloadURLs, http.MethodPost, "/download_keys?bucket=bkt", map[string]interface{}{"keys": keys[:1000]}), http.StatusOK, &response)
+	if len(response.Results) != 1000 || response.Results[999].Key != "k/999" || response.Results[999].Status != http.StatusNotFound {
+		t.Fatalf("got %d results", len(response.Results))
+	}
+}
+
+func TestPresignedDownloadURLsScript(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	srv.PutObject("bkt", "a/one.txt", []byte("one"))
+	srv.PutObject("bkt", "b/two.txt", []byte("two"))
+
+	var response batchPresignResponse
+	body := map[string]interface{}{"keys": []string{"a/one.txt", "b/two.txt", "c/missing.txt"}, "format": "bash"}
+	decode(t, serve(t, bh.HandleGetPresignedDownloadURLs, http.MethodPost, "/download_keys?bucket=bkt", body), http.StatusOK, &response)
+	if response.Script == "" {
+		t.Fatal("got no script URL")
+	}
+	resp, data := fetch(t, http.MethodGet, response.Script, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET script: %d", resp.StatusCode)
+	}
+	script := string(data)
+	// the keys that were presigned keep their full key as path, the missing one is left out
+	if !strings.Contains(script, "download 'a/one.txt' 3 ") || !strings.Contains(script, "download 'b/two.txt' 3 ") || strings.Contains(script, "missing") {
+		t.Fatalf("unexpected script:\n%s", script)
+	}
+	for _, key := range srv.Keys("bkt") {
+		if strings.HasPrefix(key, bh.Config.DefaultTempPrefix+"/download_scripts/selections/") {
+			return
+		}
+	}
+	t.Fatalf("the script was not written to the temp prefix: %v", srv.Keys("bkt"))
+}
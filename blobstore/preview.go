@@ -0,0 +1,774 @@
+package blobstore
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// preview types
+const (
+	previewCSV   = "csv"
+	previewTSV   = "tsv"
+	previewJSON  = "json"
+	previewXML   = "xml"
+	previewText  = "text"
+	previewImage = "image"
+	previewXLSX  = "xlsx"
+)
+
+// Byte caps of the previews, nothing past them is read from the object.
+const (
+	// previewTextBytes is how much of a text file is shown
+	previewTextBytes = 64 * 1024
+	// previewTableBytes is how much of a CSV or TSV file is parsed for its first rows
+	previewTableBytes = 1024 * 1024
+	// previewStructuredBytes is the largest JSON or XML document that is pretty-printed, the head
+	// of larger ones is shown as text
+	previewStructuredBytes = 1024 * 1024
+	// previewImageBytes is the largest image a thumbnail is made of
+	previewImageBytes = 20 * 1024 * 1024
+	// previewImagePixels guards against images that are small files but huge once decoded
+	previewImagePixels = 40 * 1000 * 1000
+	// previewXLSXBytes is how much of a workbook may be fetched to list its sheets and first rows
+	previewXLSXBytes = 32 * 1024 * 1024
+	// previewXLSXPartBytes caps how much of a decompressed workbook part is parsed
+	previewXLSXPartBytes = 64 * 1024 * 1024
+)
+
+const (
+	defaultPreviewRows = 20
+	maxPreviewRows     = 500
+	defaultThumbnail   = 256
+	maxThumbnail       = 1024
+	// maxPreviewColumns is how many columns of a sheet are shown, cells past it are left out
+	maxPreviewColumns = 256
+	// xlsxMaxColumns is the number of columns of a worksheet, XFD is the last one
+	xlsxMaxColumns = 16384
+)
+
+var previewExtensions = map[string]string{
+	".csv":     previewCSV,
+	".tsv":     previewTSV,
+	".tab":     previewTSV,
+	".json":    previewJSON,
+	".geojson": previewJSON,
+	".xml":     previewXML,
+	".gml":     previewXML,
+	".kml":     previewXML,
+	".xsd":     previewXML,
+	".txt":     previewText,
+	".md":      previewText,
+	".log":     previewText,
+	".prj":     previewText,
+	".yaml":    previewText,
+	".yml":     previewText,
+	".ini":     previewText,
+	".cfg":     previewText,
+	".py":      previewText,
+	".sh":      previewText,
+	".png":     previewImage,
+	".jpg":     previewImage,
+	".jpeg":    previewImage,
+	".gif":     previewImage,
+	".xlsx":    previewXLSX,
+}
+
+var previewContentTypes = map[string]string{
+	"text/csv":                  previewCSV,
+	"text/tab-separated-values": previewTSV,
+	"application/json":          previewJSON,
+	"application/geo+json":      previewJSON,
+	"application/xml":           previewXML,
+	"text/xml":                  previewXML,
+	"image/png":                 previewImage,
+	"image/jpeg":                previewImage,
+	"image/gif":                 previewImage,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": previewXLSX,
+}
+
+// objectPreview is the response of /object/preview, only the fields of its type are set.
+type objectPreview struct {
+	Key         string `json:"key"`
+	Type        string `json:"type"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	// Truncated is set when the preview doesn't cover the whole object
+	Truncated bool       `json:"truncated"`
+	Text      string     `json:"text,omitempty"`
+	Columns   []string   `json:"columns,omitempty"`
+	Rows      [][]string `json:"rows,omitempty"`
+	Sheets    []string   `json:"sheets,omitempty"`
+	Sheet     string     `json:"sheet,omitempty"`
+	Width     int        `json:"width,omitempty"`
+	Height    int        `json:"height,omitempty"`
+	// Thumbnail is a data URI, it can be used as the src of an img element
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+// statusError is a failure answered with a status other than 500.
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func statusErrorf(status int, format string, a ...interface{}) error {
+	return &statusError{status: status, err: fmt.Errorf(format, a...)}
+}
+
+// previewType picks the preview of an object from its extension, and from its content type when the extension is unknown.
+func previewType(key, contentType string) string {
+	if t, ok := previewExtensions[strings.ToLower(path.Ext(key))]; ok {
+		return t
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if t, ok := previewContentTypes[mediaType]; ok {
+		return t
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return previewText
+	}
+	return ""
+}
+
+// validText drops a rune cut in half by a byte cap from the end of b and replaces invalid bytes.
+func validText(b []byte) string {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				b = b[:len(b)-i]
+			}
+			break
+		}
+	}
+	return strings.ToValidUTF8(string(b), "\uFFFD")
+}
+
+func (s3Ctrl *S3Controller) previewText(p *objectPreview, bucket string) error {
+	data, err := s3Ctrl.readObjectHead(bucket, p.Key, p.Size, previewTextBytes)
+	if err != nil {
+		return err
+	}
+	p.Type = previewText
+	p.Truncated = p.Size > int64(len(data))
+	p.Text = validText(data)
+	return nil
+}
+
+func (s3Ctrl *S3Controller) previewTable(p *objectPreview, bucket string, rows int) error {
+	data, err := s3Ctrl.readObjectHead(bucket, p.Key, p.Size, previewTableBytes)
+	if err != nil {
+		return err
+	}
+	if p.Size > int64(len(data)) {
+		// the last line is most likely cut short
+		p.Truncated = true
+		if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+			data = data[:i+1]
+		}
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	if p.Type == previewTSV {
+		r.Comma = '\t'
+	}
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	p.Rows = [][]string{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return statusErrorf(http.StatusUnprocessableEntity, "error parsing %s as %s: %s", p.Key, p.Type, err.Error())
+		}
+		if p.Columns == nil {
+			p.Columns = record
+			continue
+		}
+		if len(p.Rows) == rows {
+			p.Truncated = true
+			break
+		}
+		p.Rows = append(p.Rows, record)
+	}
+	return nil
+}
+
+func (s3Ctrl *S3Controller) previewStructured(p *objectPreview, bucket string) error {
+	if p.Size > previewStructuredBytes {
+		// too large to be pretty-printed, its head is shown as it is
+		return s3Ctrl.previewText(p, bucket)
+	}
+	data, err := s3Ctrl.readObjectHead(bucket, p.Key, p.Size, p.Size)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if p.Type == previewJSON {
+		err = json.Indent(&out, data, "", "  ")
+	} else {
+		err = indentXML(&out, data)
+	}
+	if err != nil {
+		return statusErrorf(http.StatusUnprocessableEntity, "error parsing %s as %s: %s", p.Key, p.Type, err.Error())
+	}
+	p.Text = validText(out.Bytes())
+	return nil
+}
+
+// indentXML re-encodes an XML document with one element per line. The raw tokens are used so
+// namespace prefixes are written back as they were.
+func indentXML(w io.Writer, data []byte) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) == 0 {
+				continue
+			}
+		case xml.StartElement:
+			t.Name = rawName(t.Name)
+			attrs := make([]xml.Attr, len(t.Attr))
+			for i, a := range t.Attr {
+				attrs[i] = xml.Attr{Name: rawName(a.Name), Value: a.Value}
+			}
+			t.Attr = attrs
+			tok = t
+		case xml.EndElement:
+			t.Name = rawName(t.Name)
+			tok = t
+		}
+		if err := enc.EncodeToken(tok); err != nil {
+			return err
+		}
+	}
+	return enc.Flush()
+}
+
+// rawName folds the prefix RawToken leaves in Space into the local name, the encoder would
+// turn it into an xmlns attribute otherwise.
+func rawName(name xml.Name) xml.Name {
+	if name.Space == "" {
+		return name
+	}
+	return xml.Name{Local: name.Space + ":" + name.Local}
+}
+
+func (s3Ctrl *S3Controller) previewImage(p *objectPreview, bucket string, maxSide int) error {
+	if p.Size > previewImageBytes {
+		return statusErrorf(http.StatusRequestEntityTooLarge, "images larger than %d MB can't be previewed", previewImageBytes/1024/1024)
+	}
+	data, err := s3Ctrl.readObjectHead(bucket, p.Key, p.Size, p.Size)
+	if err != nil {
+		return err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return statusErrorf(http.StatusUnprocessableEntity, "error reading image %s: %s", p.Key, err.Error())
+	}
+	if cfg.Width*cfg.Height > previewImagePixels {
+		return statusErrorf(http.StatusRequestEntityTooLarge, "images of more than %d pixels can't be previewed", previewImagePixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return statusErrorf(http.StatusUnprocessableEntity, "error reading image %s: %s", p.Key, err.Error())
+	}
+	thumb := thumbnail(img, maxSide)
+	var out bytes.Buffer
+	mediaType := "image/jpeg"
+	if thumb.Opaque() {
+		err = jpeg.Encode(&out, thumb, &jpeg.Options{Quality: 80})
+	} else {
+		mediaType = "image/png"
+		err = png.Encode(&out, thumb)
+	}
+	if err != nil {
+		return err
+	}
+	p.Width = cfg.Width
+	p.Height = cfg.Height
+	p.Thumbnail = fmt.Sprintf("data:%s;base64,%s", mediaType, base64.StdEncoding.EncodeToString(out.Bytes()))
+	return nil
+}
+
+// thumbnail scales src down so its longest side is at most maxSide, every pixel of the
+// thumbnail is the average of the pixels it covers.
+func thumbnail(src image.Image, maxSide int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w >= h && w > maxSide {
+		tw, th = maxSide, h*maxSide/w
+	} else if h > w && h > maxSide {
+		tw, th = w*maxSide/h, maxSide
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+(y+1)*h/th
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+(x+1)*w/tw
+			if x1 == x0 {
+				x1++
+			}
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+func (s3Ctrl *S3Controller) previewXLSX(p *objectPreview, bucket, sheet string, rows int) error {
+	ra, err := s3Ctrl.newObjectReaderAt(bucket, p.Key, previewXLSXBytes)
+	if err != nil {
+		return err
+	}
+	err = readXLSXPreview(p, ra, sheet, rows)
+	if ra.Exceeded() {
+		return statusErrorf(http.StatusRequestEntityTooLarge, "the preview of %s needs more than %d MB of the workbook", p.Key, previewXLSXBytes/1024/1024)
+	}
+	return err
+}
+
+type xlsxSheet struct {
+	Name string `xml:"name,attr"`
+	// RID is the r:id attribute, which refers to the relationship holding the part of the sheet
+	RID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+}
+
+type xlsxWorkbook struct {
+	Sheets []xlsxSheet `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+func readXLSXPreview(p *objectPreview, ra *objectReaderAt, sheet string, rows int) error {
+	zr, err := zip.NewReader(ra, ra.Size())
+	if err != nil {
+		return statusErrorf(http.StatusUnprocessableEntity, "error reading workbook %s: %s", p.Key, err.Error())
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var wb xlsxWorkbook
+	if err := decodeXLSXPart(files, "xl/workbook.xml", &wb); err != nil {
+		return err
+	}
+	var rels xlsxRelationships
+	if err := decodeXLSXPart(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return err
+	}
+	if len(wb.Sheets) == 0 {
+		return statusErrorf(http.StatusUnprocessableEntity, "workbook %s has no sheets", p.Key)
+	}
+	p.Sheets = make([]string, 0, len(wb.Sheets))
+	var selected *xlsxSheet
+	for i, s := range wb.Sheets {
+		p.Sheets = append(p.Sheets, s.Name)
+		if selected == nil && (sheet == "" || s.Name == sheet) {
+			selected = &wb.Sheets[i]
+		}
+	}
+	if selected == nil {
+		return statusErrorf(http.StatusNotFound, "workbook %s has no sheet named %s", p.Key, sheet)
+	}
+	p.Sheet = selected.Name
+	var target string
+	for _, rel := range rels.Relationships {
+		if rel.ID == selected.RID {
+			target = rel.Target
+		}
+	}
+	// targets are relative to xl/ unless they start with a slash
+	if strings.HasPrefix(target, "/") {
+		target = strings.TrimPrefix(target, "/")
+	} else {
+		target = path.Join("xl", target)
+	}
+	sheetFile, ok := files[target]
+	if !ok {
+		return statusErrorf(http.StatusUnprocessableEntity, "sheet %s of workbook %s has no part", selected.Name, p.Key)
+	}
+
+	var sharedStrings []string
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		if sharedStrings, err = readSharedStrings(f); err != nil {
+			return err
+		}
+	}
+	return readSheetRows(p, sheetFile, sharedStrings, rows)
+}
+
+func decodeXLSXPart(files map[string]*zip.File, name string, v interface{}) error {
+	f, ok := files[name]
+	if !ok {
+		return statusErrorf(http.StatusUnprocessableEntity, "not a workbook, %s is missing", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(io.LimitReader(rc, previewXLSXPartBytes)).Decode(v); err != nil {
+		return statusErrorf(http.StatusUnprocessableEntity, "error parsing %s of workbook: %s", name, err.Error())
+	}
+	return nil
+}
+
+// readSharedStrings returns the strings table cells of type s index into. Runs of rich text are
+// joined and phonetic hints are left out.
+func readSharedStrings(f *zip.File) ([]string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	dec := xml.NewDecoder(io.LimitReader(rc, previewXLSXPartBytes))
+	var (
+		strs     []string
+		current  strings.Builder
+		inText   bool
+		phonetic int
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return strs, nil
+		}
+		if err != nil {
+			return nil, statusErrorf(http.StatusUnprocessableEntity, "error parsing shared strings of workbook: %s", err.Error())
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				current.Reset()
+			case "t":
+				inText = phonetic == 0
+			case "rPh":
+				phonetic++
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				strs = append(strs, current.String())
+			case "t":
+				inText = false
+			case "rPh":
+				phonetic--
+			}
+		case xml.CharData:
+			if inText {
+				current.Write(t)
+			}
+		}
+	}
+}
+
+// xlsxColumn returns the 0 based column of a cell reference such as AB12, or -1 if it has none.
+// Columns past XFD are returned as xlsxMaxColumns.
+func xlsxColumn(ref string) int {
+	col := 0
+	i := 0
+	for ; i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z'; i++ {
+		col = col*26 + int(ref[i]-'A'+1)
+		if col > xlsxMaxColumns {
+			return xlsxMaxColumns
+		}
+	}
+	if i == 0 {
+		return -1
+	}
+	return col - 1
+}
+
+// readSheetRows streams the rows of a sheet until the header and rows rows are read, the first row is
+// taken as the header.
+func readSheetRows(p *objectPreview, f *zip.File, sharedStrings []string, rows int) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	dec := xml.NewDecoder(io.LimitReader(rc, previewXLSXPartBytes))
+
+	var (
+		table    [][]string
+		row      []string
+		cellType string
+		cellCol  int
+		value    strings.Builder
+		inValue  bool
+		phonetic int
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return statusErrorf(http.StatusUnprocessableEntity, "error parsing sheet %s of workbook: %s", p.Sheet, err.Error())
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				row = []string{}
+				for _, a := range t.Attr {
+					if a.Name.Local == "r" {
+						if r, err := strconv.Atoi(a.Value); err == nil {
+							// empty rows are left out of the sheet
+							for len(table) < r-1 && len(table) <= rows+1 {
+								table = append(table, []string{})
+							}
+						}
+					}
+				}
+			case "c":
+				cellType, cellCol = "", len(row)
+				for _, a := range t.Attr {
+					switch a.Name.Local {
+					case "t":
+						cellType = a.Value
+					case "r":
+						if col := xlsxColumn(a.Value); col >= 0 {
+							cellCol = col
+						}
+					}
+				}
+				value.Reset()
+			case "v", "t":
+				inValue = phonetic == 0
+			case "rPh":
+				phonetic++
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "rPh":
+				phonetic--
+			case "c":
+				if cellCol >= xlsxMaxColumns {
+					return statusErrorf(http.StatusUnprocessableEntity, "sheet %s of workbook has a cell past column XFD", p.Sheet)
+				}
+				if cellCol >= maxPreviewColumns {
+					p.Truncated = true
+					continue
+				}
+				for len(row) < cellCol {
+					row = append(row, "")
+				}
+				row = append(row, xlsxCellValue(cellType, value.String(), sharedStrings))
+			case "row":
+				table = append(table, row)
+			}
+		case xml.CharData:
+			if inValue {
+				value.Write(t)
+			}
+		}
+		// one more row than shown tells whether the sheet goes on
+		if len(table) > rows+1 {
+			break
+		}
+	}
+
+	if len(table) > 0 {
+		p.Columns = table[0]
+		table = table[1:]
+	}
+	if len(table) > rows {
+		p.Truncated = true
+		table = table[:rows]
+	}
+	p.Rows = table
+	return nil
+}
+
+func xlsxCellValue(cellType, value string, sharedStrings []string) string {
+	switch cellType {
+	case "s":
+		i, err := strconv.Atoi(value)
+		if err != nil || i < 0 || i >= len(sharedStrings) {
+			return ""
+		}
+		return sharedStrings[i]
+	case "b":
+		if value == "1" {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+	return value
+}
+
+// intParam parses an optional positive integer query parameter, capped at max.
+func intParam(c echo.Context, name string, def, max int) (int, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > max {
+		return 0, fmt.Errorf("`%s` must be an integer between 1 and %d, got `%s`", name, max, value)
+	}
+	return n, nil
+}
+
+// HandleGetObjectPreview returns a bounded preview of an object picked by its extension or content type,
+// or by the `type` parameter: the first `rows` rows of a CSV or TSV file, a pretty-printed JSON or XML
+// document, the head of a text file, a thumbnail at most `size` pixels wide and high of an image,
+// or the sheets and the first rows of `sheet` of an xlsx workbook. Only the bytes needed are read.
+func (bh *BlobHandler) HandleGetObjectPreview(c echo.Context) error {
+	key := c.QueryParam("key")
+	if key == "" {
+		errMsg := fmt.Errorf("parameter 'key' is required")
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	kind := c.QueryParam("type")
+	switch kind {
+	case "", previewCSV, previewTSV, previewJSON, previewXML, previewText, previewImage, previewXLSX:
+	default:
+		errMsg := fmt.Errorf("`type` must be one of csv, tsv, json, xml, text, image or xlsx, got `%s`", kind)
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	rows, err := intParam(c, "rows", defaultPreviewRows, maxPreviewRows)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(http.StatusUnprocessableEntity, err.Error())
+	}
+	size, err := intParam(c, "size", defaultThumbnail, maxThumbnail)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	bucket := c.QueryParam("bucket")
+	s3Ctrl, err := bh.GetController(bucket)
+	if err != nil {
+		errMsg := fmt.Errorf("`bucket` %s is not available, %s", bucket, err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	permissions, fullAccess, statusCode, err := bh.GetS3ReadPermissions(c, bucket)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(statusCode, err.Error())
+	}
+	if !fullAccess && !IsPermittedPrefix(bucket, key, permissions) {
+		errMsg := fmt.Errorf("user does not have permission to read the %s key", key)
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusForbidden, errMsg.Error())
+	}
+
+	head, err := s3Ctrl.Store.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return objectContentError(c, key, err)
+	}
+	p := objectPreview{
+		Key:         key,
+		Size:        aws.Int64Value(head.ContentLength),
+		ContentType: aws.StringValue(head.ContentType),
+	}
+	if kind == "" {
+		kind = previewType(key, p.ContentType)
+	}
+	if kind == "" {
+		errMsg := fmt.Errorf("objects of type `%s` can't be previewed, set `type` to preview %s as csv, tsv, json, xml, text, image or xlsx", p.ContentType, key)
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnsupportedMediaType, errMsg.Error())
+	}
+	p.Type = kind
+
+	switch kind {
+	case previewCSV, previewTSV:
+		err = s3Ctrl.previewTable(&p, bucket, rows)
+	case previewJSON, previewXML:
+		err = s3Ctrl.previewStructured(&p, bucket)
+	case previewText:
+		err = s3Ctrl.previewText(&p, bucket)
+	case previewImage:
+		err = s3Ctrl.previewImage(&p, bucket, size)
+	case previewXLSX:
+		err = s3Ctrl.previewXLSX(&p, bucket, c.QueryParam("sheet"), rows)
+	}
+	if err != nil {
+		if perr, ok := err.(*statusError); ok {
+			log.Error(perr.Error())
+			return c.JSON(perr.status, perr.Error())
+		}
+		errMsg := fmt.Errorf("error previewing object %s: %s", key, err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusInternalServerError, errMsg.Error())
+	}
+
+	log.Infof("successfully generated %s preview of %s in bucket %s", kind, key, bucket)
+	return c.JSON(http.StatusOK, p)
+}
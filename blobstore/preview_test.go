@@ -0,0 +1,232 @@
+package blobstore_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type preview struct {
+	Type      string     `json:"type"`
+	Truncated bool       `json:"truncated"`
+	Text      string     `json:"text"`
+	Columns   []string   `json:"columns"`
+	Rows      [][]string `json:"rows"`
+	Sheets    []string   `json:"sheets"`
+	Sheet     string     `json:"sheet"`
+	Width     int        `json:"width"`
+	Height    int        `json:"height"`
+	Thumbnail string     `json:"thumbnail"`
+}
+
+// xlsxFile builds a minimal workbook with one sheet per entry of sheets, holding the given sheetData XML.
+func xlsxFile(t *testing.T, sharedStrings []string, sheets map[string]string, order ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	write := func(name, content string) {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var wb, rels strings.Builder
+	for i, name := range order {
+		fmt.Fprintf(&wb, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, name, i+1, i+1)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+		write(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1),
+			`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`+sheets[name]+`</sheetData></worksheet>`)
+	}
+	write("xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" `+
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`+wb.String()+`</sheets></workbook>`)
+	write("xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+rels.String()+`</Relationships>`)
+	var sst strings.Builder
+	for _, s := range sharedStrings {
+		fmt.Fprintf(&sst, "<si><t>%s</t></si>", s)
+	}
+	write("xl/sharedStrings.xml", `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`+sst.String()+`</sst>`)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestObjectPreviewTable(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	srv.PutObject("bkt", "data.csv", []byte("id,name\n1,a\n2,b\n3,c\n"))
+	srv.PutObject("bkt", "data.tab", []byte("id\tname\n1\ta\n"))
+
+	var p preview
+	decode(t, serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=data.csv&rows=2", nil), http.StatusOK, &p)
+	if p.Type != "csv" || strings.Join(p.Columns, ",") != "id,name" || len(p.Rows) != 2 || p.Rows[1][1] != "b" || !p.Truncated {
+		t.Fatalf("got %+v", p)
+	}
+	p = preview{}
+	decode(t, serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=data.tab", nil), http.StatusOK, &p)
+	if p.Type != "tsv" || len(p.Rows) != 1 || p.Rows[0][1] != "a" || p.Truncated {
+		t.Fatalf("got %+v", p)
+	}
+
+	// only the first MB is parsed, the line cut by the cap is dropped
+	var big bytes.Buffer
+	big.WriteString("n\n")
+	for i := 0; big.Len() < 1024*1024+100; i++ {
+		fmt.Fprintf(&big, "%d\n", i)
+	}
+	srv.PutObject("bkt", "big.csv", big.Bytes())
+	p = preview{}
+	decode(t, serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=big.csv&rows=500", nil), http.StatusOK, &p)
+	if !p.Truncated || len(p.Rows) != 500 || p.Rows[499][0] != "499" {
+		t.Fatalf("got %d rows, truncated %t", len(p.Rows), p.Truncated)
+	}
+
+	rec := serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=data.csv&rows=501", nil)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("got %d for rows above the maximum", rec.Code)
+	}
+}
+
+func TestObjectPreviewStructured(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	srv.PutObject("bkt", "doc.json", []byte(`{"a":[1,2]}`))
+	srv.PutObject("bkt", "doc.xml", []byte(`<gml:a xmlns:gml="http://www.opengis.net/gml"><gml:b x="1">v</gml:b></gml:a>`))
+	srv.PutObject("bkt", "bad.json", []byte(`{"a":`))
+
+	var p preview
+	decode(t, serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=doc.json", nil), http.StatusOK, &p)
+	if p.Text != "{\n  \"a\": [\n    1,\n    2\n  ]\n}" {
+		t.Fatalf("got %q", p.Text)
+	}
+	p = preview{}
+	decode(t, serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=doc.xml", nil), http.StatusOK, &p)
+	if !strings.Contains(p.Text, "\n  <gml:b x=\"1\">v</gml:b>") {
+		t.Fatalf("got %q", p.Text)
+	}
+	rec := serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=bad.json", nil)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("got %d for invalid JSON", rec.Code)
+	}
+
+	// documents over 1 MB get the first 64 KB as text
+	big := append([]byte(`{"a":"`), bytes.Repeat([]byte("x"), 1024*1024)...)
+	big = append(big, `"}`...)
+	srv.PutObject("bkt", "big.json", big)
+	p = preview{}
+	decode(t, serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=big.json", nil), http.StatusOK, &p)
+	if p.Type != "text" || !p.Truncated || len(p.Text) != 64*1024 {
+		t.Fatalf("got type %s, truncated %t and %d bytes", p.Type, p.Truncated, len(p.Text))
+	}
+}
+
+func TestObjectPreviewText(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	// the cap falls in the middle of a two byte rune, which is dropped
+	data := append(bytes.Repeat([]byte("a"), 64*1024-1), "é"...)
+	srv.PutObject("bkt", "notes.txt", data)
+	srv.PutObject("bkt", "blob.bin", []byte{0, 1, 2})
+
+	var p preview
+	decode(t, serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=notes.txt", nil), http.StatusOK, &p)
+	if !p.Truncated || p.Text != string(data[:64*1024-1]) {
+		t.Fatalf("got %d bytes, truncated %t", len(p.Text), p.Truncated)
+	}
+	rec := serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=blob.bin", nil)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("got %d for an unknown type", rec.Code)
+	}
+	p = preview{}
+	decode(t, serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=blob.bin&type=text", nil), http.StatusOK, &p)
+	if p.Type != "text" || p.Truncated {
+		t.Fatalf("got %+v", p)
+	}
+}
+
+func TestObjectPreviewImage(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	img := image.NewRGBA(image.Rect(0, 0, 400, 100))
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 100; y++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	srv.PutObject("bkt", "img.png", buf.Bytes())
+	srv.PutObject("bkt", "broken.png", []byte("not an image"))
+	srv.PutObject("bkt", "huge.png", make([]byte, 20*1024*1024+1))
+
+	var p preview
+	decode(t, serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=img.png&size=100", nil), http.StatusOK, &p)
+	if p.Width != 400 || p.Height != 100 || !strings.HasPrefix(p.Thumbnail, "data:image/jpeg;base64,") {
+		t.Fatalf("got %dx%d and %.30s", p.Width, p.Height, p.Thumbnail)
+	}
+	rec := serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=broken.png", nil)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("got %d for a broken image", rec.Code)
+	}
+	rec = serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=huge.png", nil)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got %d for an image over 20 MB", rec.Code)
+	}
+}
+
+func TestObjectPreviewXLSX(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	srv.PutObject("bkt", "book.xlsx", xlsxFile(t, []string{"id", "name", "a"}, map[string]string{
+		"First": `<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>` +
+			`<row r="2"><c r="A2"><v>1</v></c><c r="C2" t="s"><v>2</v></c></row>` +
+			`<row r="4"><c r="B4" t="b"><v>1</v></c></row>`,
+		"Second": `<row r="1"><c r="A1" t="inlineStr"><is><t>x</t></is></c></row>`,
+	}, "First", "Second"))
+
+	var p preview
+	decode(t, serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=book.xlsx", nil), http.StatusOK, &p)
+	if strings.Join(p.Sheets, ",") != "First,Second" || p.Sheet != "First" || strings.Join(p.Columns, ",") != "id,name" {
+		t.Fatalf("got %+v", p)
+	}
+	// the missing row 3 is kept as an empty row
+	if len(p.Rows) != 3 || strings.Join(p.Rows[0], ",") != "1,,a" || len(p.Rows[1]) != 0 || strings.Join(p.Rows[2], ",") != ",TRUE" {
+		t.Fatalf("got rows %q", p.Rows)
+	}
+	p = preview{}
+	decode(t, serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=book.xlsx&sheet=Second", nil), http.StatusOK, &p)
+	if p.Sheet != "Second" || strings.Join(p.Columns, ",") != "x" {
+		t.Fatalf("got %+v", p)
+	}
+	rec := serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=book.xlsx&sheet=Missing", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("got %d for a missing sheet", rec.Code)
+	}
+}
+
+func TestObjectPreviewXLSXColumns(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	// a cell in the last column would otherwise pad the row with 16383 empty cells
+	srv.PutObject("bkt", "wide.xlsx", xlsxFile(t, nil, map[string]string{
+		"Wide": `<row r="1"><c r="A1"><v>1</v></c><c r="XFD1"><v>2</v></c></row>`,
+	}, "Wide"))
+	srv.PutObject("bkt", "past.xlsx", xlsxFile(t, nil, map[string]string{
+		"Past": `<row r="1"><c r="A1"><v>1</v></c><c r="ZZZZZZZZZZZZZZ1"><v>2</v></c></row>`,
+	}, "Past"))
+
+	var p preview
+	decode(t, serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=wide.xlsx", nil), http.StatusOK, &p)
+	if !p.Truncated || len(p.Columns) != 1 {
+		t.Fatalf("got %d columns, truncated %t", len(p.Columns), p.Truncated)
+	}
+	rec := serve(t, bh.HandleGetObjectPreview, http.MethodGet, "/object/preview?bucket=bkt&key=past.xlsx", nil)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("got %d for a cell past XFD", rec.Code)
+	}
+}
@@ -0,0 +1,148 @@
+package blobstore
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// bucketRouter maps bucket names to the controller serving them and caches the store bound to
+// each bucket's region, so that requests don't pay a GetBucketLocation round-trip. It is built
+// alongside the controllers it indexes and replaced with them on reload.
+type bucketRouter struct {
+	mu     sync.RWMutex
+	ttl    time.Duration
+	routes map[string]*bucketRoute
+	// stores bound to a region are shared by every bucket of that region in the same account
+	regionStores map[regionKey]ObjectStore
+}
+
+// regionResolveConcurrency bounds the GetBucketLocation calls made when controllers are loaded
+const regionResolveConcurrency = 8
+
+type bucketRoute struct {
+	controller int
+	// store is nil until the bucket's region is resolved, and again once it is invalidated
+	store    ObjectStore
+	expireAt time.Time
+}
+
+type regionKey struct {
+	controller int
+	region     string
+}
+
+func newBucketRouter(controllers []S3Controller, ttl time.Duration) *bucketRouter {
+	r := &bucketRouter{
+		ttl:          ttl,
+		routes:       make(map[string]*bucketRoute),
+		regionStores: make(map[regionKey]ObjectStore),
+	}
+	for i, s3Ctrl := range controllers {
+		for _, b := range s3Ctrl.Buckets {
+			r.routes[b] = &bucketRoute{controller: i}
+		}
+		if regional, ok := s3Ctrl.Store.(RegionalStore); ok {
+			r.regionStores[regionKey{i, regional.Region()}] = s3Ctrl.Store
+			// requests rejected because the cached region went stale drop the cached store
+			regional.OnRegionMismatch(r.invalidate)
+		}
+	}
+	return r
+}
+
+// lookup returns the index of the controller serving bucket, and the store bound to the
+// bucket's region if it is cached and not expired.
+func (r *bucketRouter) lookup(bucket string) (int, ObjectStore, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	route, ok := r.routes[bucket]
+	if !ok {
+		return 0, nil, false
+	}
+	if route.store == nil || time.Now().After(route.expireAt) {
+		return route.controller, nil, true
+	}
+	return route.controller, route.store, true
+}
+
+// add routes a bucket that was not known when the router was built, such as one picked up
+// by /list_buckets when all buckets are allowed.
+func (r *bucketRouter) add(bucket string, controller int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.routes[bucket]; !ok {
+		r.routes[bucket] = &bucketRoute{controller: controller}
+	}
+}
+
+// resolve returns the store of the given controller bound to the bucket's region and caches it,
+// base is the controller's own store.
+func (r *bucketRouter) resolve(bucket string, controller int, base ObjectStore, regional RegionalStore) (ObjectStore, error) {
+	region, err := getBucketRegion(base, bucket)
+	if err != nil {
+		return nil, err
+	}
+	key := regionKey{controller, region}
+
+	r.mu.RLock()
+	store, ok := r.regionStores[key]
+	r.mu.RUnlock()
+	if !ok {
+		log.Debugf("bucket %s is in region %s, creating a new session for it", bucket, region)
+		store, err = regional.WithRegion(region)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cached, ok := r.regionStores[key]; ok {
+		// another request resolved the same region first
+		store = cached
+	} else {
+		r.regionStores[key] = store
+	}
+	if route, ok := r.routes[bucket]; ok {
+		route.store = store
+		route.expireAt = time.Now().Add(r.ttl)
+	}
+	return store, nil
+}
+
+// resolveAll resolves the region of every bucket served by a regional store, so that buckets
+// whose region can't be resolved are reported when the controllers are loaded rather than on
+// their first request. Failures are logged and left to be retried by GetController.
+func (r *bucketRouter) resolveAll(controllers []S3Controller) {
+	sem := make(chan struct{}, regionResolveConcurrency)
+	var wg sync.WaitGroup
+	for i, s3Ctrl := range controllers {
+		regional, ok := s3Ctrl.Store.(RegionalStore)
+		if !ok {
+			continue
+		}
+		for _, bucket := range s3Ctrl.Buckets {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, base ObjectStore, bucket string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				if _, err := r.resolve(bucket, i, base, regional); err != nil {
+					log.Errorf("Failed to get region for bucket '%s' of account '%s': %s", bucket, controllers[i].Name, err.Error())
+				}
+			}(i, s3Ctrl.Store, bucket)
+		}
+	}
+	wg.Wait()
+}
+
+// invalidate forgets the cached region of a bucket, the next lookup resolves it again.
+func (r *bucketRouter) invalidate(bucket string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if route, ok := r.routes[bucket]; ok && route.store != nil {
+		route.store = nil
+	}
+}
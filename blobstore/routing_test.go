@@ -0,0 +1,133 @@
+package blobstore
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// fakeRegionalStore answers GetBucketLocation from a fixed map and counts the calls. The
+// embedded ObjectStore is nil, the router never calls its other methods.
+type fakeRegionalStore struct {
+	ObjectStore
+	region  string
+	regions map[string]string
+	calls   *int
+	mu      *sync.Mutex
+	// mismatch is shared by the stores returned by WithRegion, like S3ObjectStore does
+	mismatch *func(bucket string)
+}
+
+func newFakeRegionalStore(region string, regions map[string]string) *fakeRegionalStore {
+	var mismatch func(string)
+	return &fakeRegionalStore{region: region, regions: regions, calls: new(int), mu: &sync.Mutex{}, mismatch: &mismatch}
+}
+
+func (f *fakeRegionalStore) GetBucketLocation(input *s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	*f.calls++
+	region, ok := f.regions[aws.StringValue(input.Bucket)]
+	if !ok {
+		return nil, fmt.Errorf("access denied")
+	}
+	return &s3.GetBucketLocationOutput{LocationConstraint: aws.String(region)}, nil
+}
+
+func (f *fakeRegionalStore) Region() string { return f.region }
+
+func (f *fakeRegionalStore) WithRegion(region string) (ObjectStore, error) {
+	bound := *f
+	bound.region = region
+	return &bound, nil
+}
+
+func (f *fakeRegionalStore) OnRegionMismatch(fn func(bucket string)) { *f.mismatch = fn }
+
+func (f *fakeRegionalStore) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return *f.calls
+}
+
+func TestBucketRouterCachesRegions(t *testing.T) {
+	store := newFakeRegionalStore("us-east-1", map[string]string{"east": "us-east-1", "west": "us-west-2", "west2": "us-west-2"})
+	controllers := []S3Controller{{Name: "aws", Store: store, Buckets: []string{"east", "west", "west2"}}}
+	router := newBucketRouter(controllers, 50*time.Millisecond)
+
+	if _, cached, ok := router.lookup("west"); !ok || cached != nil {
+		t.Fatalf("got %v, %v before resolving", cached, ok)
+	}
+	resolved, err := router.resolve("west", 0, store, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.(RegionalStore).Region() != "us-west-2" {
+		t.Fatalf("got region %s", resolved.(RegionalStore).Region())
+	}
+	i, cached, ok := router.lookup("west")
+	if !ok || i != 0 || cached != resolved {
+		t.Fatalf("got %d, %v, %v after resolving", i, cached, ok)
+	}
+	// buckets of the same region share one store
+	if other, _ := router.resolve("west2", 0, store, store); other != resolved {
+		t.Fatal("west2 got a store of its own")
+	}
+	if east, _ := router.resolve("east", 0, store, store); east != store {
+		t.Fatal("east did not reuse the store of the controller's own region")
+	}
+
+	// a request rejected for the wrong region drops the cached store
+	(*store.mismatch)("west")
+	if _, cached, _ := router.lookup("west"); cached != nil {
+		t.Fatal("the store is still cached after a region mismatch")
+	}
+	// and so does the TTL
+	time.Sleep(60 * time.Millisecond)
+	if _, cached, _ := router.lookup("west2"); cached != nil {
+		t.Fatal("the store is still cached after the TTL")
+	}
+
+	if _, err := router.resolve("missing", 0, store, store); err == nil {
+		t.Fatal("resolved a bucket without a location")
+	}
+	if _, _, ok := router.lookup("unknown"); ok {
+		t.Fatal("routed an unknown bucket")
+	}
+}
+
+func TestGetControllerResolvesRegionsOnLoad(t *testing.T) {
+	store := newFakeRegionalStore("us-east-1", map[string]string{"west": "us-west-2"})
+	controllers := []S3Controller{{Name: "aws", Store: store, Buckets: []string{"west", "denied"}}}
+	bh := &BlobHandler{S3Controllers: controllers, Config: &Config{BucketRegionCacheTTL: 60}}
+	bh.router = newBucketRouter(controllers, bh.regionCacheTTL())
+	bh.router.resolveAll(controllers)
+	if got := store.callCount(); got != 2 {
+		t.Fatalf("got %d GetBucketLocation calls on load, want 2", got)
+	}
+
+	for i := 0; i < 3; i++ {
+		s3Ctrl, err := bh.GetController("west")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if region := s3Ctrl.Store.(RegionalStore).Region(); region != "us-west-2" {
+			t.Fatalf("got region %s", region)
+		}
+	}
+	if got := store.callCount(); got != 2 {
+		t.Fatalf("got %d GetBucketLocation calls, cached regions were resolved again", got)
+	}
+
+	// failures on load are retried when the bucket is requested
+	if _, err := bh.GetController("denied"); err == nil {
+		t.Fatal("got a controller for a bucket whose region can't be resolved")
+	}
+	if got := store.callCount(); got != 3 {
+		t.Fatalf("got %d GetBucketLocation calls, want 3", got)
+	}
+}
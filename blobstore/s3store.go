@@ -0,0 +1,168 @@
+package blobstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// S3ObjectStore is the ObjectStore backed by the AWS SDK. It serves AWS S3 as well as
+// S3-compatible services such as MinIO.
+type S3ObjectStore struct {
+	*s3.S3
+	Sess *session.Session
+	// PublicEndpoint, when set, is the endpoint every presigned URL points at instead of the one
+	// the API talks to. This is done so that in MinIO mode the presigned url starts with
+	// localhost:9000 instead of minio:9000, and so that services behind a proxy hand out URLs
+	// that browsers can reach. Set it with SetPublicEndpoint.
+	PublicEndpoint string
+	presigner      *s3.S3
+
+	regionMismatch func(bucket string)
+}
+
+func NewS3ObjectStore(sess *session.Session) *S3ObjectStore {
+	s := &S3ObjectStore{S3: s3.New(sess), Sess: sess}
+	s.presigner = s.S3
+	s.Handlers.Complete.PushBack(s.checkRegionMismatch)
+	return s
+}
+
+// SetPublicEndpoint makes every presigned URL of the store point at endpoint, the requests
+// the API itself sends still go to the session's endpoint. An empty endpoint resets it.
+func (s *S3ObjectStore) SetPublicEndpoint(endpoint string) {
+	s.PublicEndpoint = endpoint
+	if endpoint == "" {
+		s.presigner = s.S3
+		return
+	}
+	// presigning happens offline, so the copy only changes the host the URLs are signed for
+	s.presigner = s3.New(s.Sess.Copy(&aws.Config{Endpoint: aws.String(endpoint)}))
+}
+
+// error codes S3 answers with when a request is sent to a region the bucket doesn't live in
+var regionMismatchCodes = map[string]bool{
+	"PermanentRedirect":                  true,
+	"AuthorizationHeaderMalformed":       true,
+	"BucketRegionError":                  true,
+	"IllegalLocationConstraintException": true,
+}
+
+func (s *S3ObjectStore) checkRegionMismatch(r *request.Request) {
+	if r.Error == nil || s.regionMismatch == nil {
+		return
+	}
+	aerr, ok := r.Error.(awserr.Error)
+	if !ok {
+		return
+	}
+	redirect := r.HTTPResponse != nil && r.HTTPResponse.StatusCode == http.StatusMovedPermanently
+	if !regionMismatchCodes[aerr.Code()] && !redirect {
+		return
+	}
+	buckets, err := awsutil.ValuesAtPath(r.Params, "Bucket")
+	if err != nil || len(buckets) == 0 {
+		return
+	}
+	if bucket, ok := buckets[0].(*string); ok {
+		log.Debugf("request to bucket %s was sent to the wrong region: %s", aws.StringValue(bucket), aerr.Code())
+		s.regionMismatch(aws.StringValue(bucket))
+	}
+}
+
+func (s *S3ObjectStore) PresignGetObject(input *s3.GetObjectInput, expire time.Duration) (string, error) {
+	req, _ := s.presigner.GetObjectRequest(input)
+	return req.Presign(expire)
+}
+
+func (s *S3ObjectStore) PresignPutObject(input *s3.PutObjectInput, expire time.Duration) (string, error) {
+	req, _ := s.presigner.PutObjectRequest(input)
+	return req.Presign(expire)
+}
+
+func (s *S3ObjectStore) PresignUploadPart(input *s3.UploadPartInput, expire time.Duration) (string, error) {
+	req, _ := s.presigner.UploadPartRequest(input)
+	return req.Presign(expire)
+}
+
+// PresignPostObject signs a POST policy with SigV4, the URL is the one the bucket is addressed with,
+// path-style or virtual-hosted like every other request of the store.
+func (s *S3ObjectStore) PresignPostObject(input *PostPolicyInput, expire time.Duration) (*PresignedPost, error) {
+	req, _ := s.presigner.ListObjectsV2Request(&s3.ListObjectsV2Input{Bucket: aws.String(input.Bucket)})
+	if err := req.Build(); err != nil {
+		return nil, err
+	}
+	bucketURL := *req.HTTPRequest.URL
+	bucketURL.RawQuery = ""
+	creds, err := s.presigner.Config.Credentials.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+	region := aws.StringValue(s.presigner.Config.Region)
+	fields, conditions := postPolicyFields(input)
+	signing := [][2]string{
+		{"x-amz-algorithm", "AWS4-HMAC-SHA256"},
+		{"x-amz-credential", fmt.Sprintf("%s/%s/%s/s3/aws4_request", creds.AccessKeyID, date, region)},
+		{"x-amz-date", now.Format("20060102T150405Z")},
+	}
+	if creds.SessionToken != "" {
+		signing = append(signing, [2]string{"x-amz-security-token", creds.SessionToken})
+	}
+	for _, field := range signing {
+		fields[field[0]] = field[1]
+		conditions = append(conditions, map[string]string{field[0]: field[1]})
+	}
+	expires := now.Add(expire)
+	policy, err := encodePostPolicy(expires, conditions)
+	if err != nil {
+		return nil, err
+	}
+	fields["policy"] = policy
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, "s3", "aws4_request", policy} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	fields["x-amz-signature"] = hex.EncodeToString(key)
+	return &PresignedPost{URL: bucketURL.String(), Fields: fields, Expires: expires}, nil
+}
+
+// Region returns the region the store's session is bound to.
+func (s *S3ObjectStore) Region() string {
+	return aws.StringValue(s.Sess.Config.Region)
+}
+
+// WithRegion returns a copy of the store whose session targets the given region.
+func (s *S3ObjectStore) WithRegion(region string) (ObjectStore, error) {
+	newSession := s.Sess.Copy(&aws.Config{Region: aws.String(region)})
+	store := NewS3ObjectStore(newSession)
+	store.SetPublicEndpoint(s.PublicEndpoint)
+	store.regionMismatch = s.regionMismatch
+	return store, nil
+}
+
+func (s *S3ObjectStore) OnRegionMismatch(fn func(bucket string)) {
+	s.regionMismatch = fn
+}
+
+// fixedRegionStore hides the RegionalStore methods of the wrapped store, so that buckets are
+// always reached through the region the store was configured with.
+type fixedRegionStore struct {
+	ObjectStore
+}
@@ -0,0 +1,179 @@
+package blobstore
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxDeleteKeys is the most keys a single DeleteObjects request accepts
+const maxDeleteKeys = 1000
+
+// tempCleanupReport lists the objects removed from the temp prefix of a bucket, or the ones that
+// would be removed for a dry run.
+type tempCleanupReport struct {
+	Bucket  string   `json:"bucket"`
+	Prefix  string   `json:"prefix"`
+	DryRun  bool     `json:"dry_run"`
+	Objects int      `json:"objects"`
+	Bytes   uint64   `json:"bytes"`
+	Removed []string `json:"removed"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// tempPrefix is the prefix the generated scripts and archives are written under, with a trailing
+// slash so keys that merely start with the same name are never matched.
+func (bh *BlobHandler) tempPrefix() string {
+	return strings.Trim(bh.Config.DefaultTempPrefix, "/") + "/"
+}
+
+// cleanTempPrefix removes the objects under tempPrefix that were last modified before cutoff.
+func (s3Ctrl *S3Controller) cleanTempPrefix(bucket, tempPrefix string, cutoff time.Time, dryRun bool) (tempCleanupReport, error) {
+	report := tempCleanupReport{Bucket: bucket, Prefix: tempPrefix, DryRun: dryRun, Removed: []string{}}
+	var expired []*s3.Object
+	err := s3Ctrl.GetListWithCallBack(bucket, tempPrefix, false, func(page *s3.ListObjectsV2Output) error {
+		for _, item := range page.Contents {
+			// the listing is limited to the prefix already, checked again as nothing else may be removed
+			if !strings.HasPrefix(aws.StringValue(item.Key), tempPrefix) {
+				continue
+			}
+			if aws.TimeValue(item.LastModified).Before(cutoff) {
+				expired = append(expired, item)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("error listing %s: %s", tempPrefix, err.Error())
+	}
+
+	var failed []string
+	for start := 0; start < len(expired); start += maxDeleteKeys {
+		end := start + maxDeleteKeys
+		if end > len(expired) {
+			end = len(expired)
+		}
+		batch := expired[start:end]
+		notDeleted := make(map[string]bool)
+		if !dryRun {
+			objects := make([]*s3.ObjectIdentifier, 0, len(batch))
+			for _, item := range batch {
+				objects = append(objects, &s3.ObjectIdentifier{Key: item.Key})
+			}
+			output, err := s3Ctrl.Store.DeleteObjects(&s3.DeleteObjectsInput{
+				Bucket: aws.String(bucket),
+				Delete: &s3.Delete{
+					Objects: objects,
+					Quiet:   aws.Bool(true),
+				},
+			})
+			if err != nil {
+				return report, fmt.Errorf("error deleting objects: %s", err.Error())
+			}
+			for _, e := range output.Errors {
+				key := aws.StringValue(e.Key)
+				notDeleted[key] = true
+				failed = append(failed, fmt.Sprintf("%s: %s", key, aws.StringValue(e.Message)))
+			}
+		}
+		for _, item := range batch {
+			key := aws.StringValue(item.Key)
+			if notDeleted[key] {
+				continue
+			}
+			report.Removed = append(report.Removed, key)
+			report.Objects++
+			report.Bytes += uint64(aws.Int64Value(item.Size))
+		}
+	}
+	if len(failed) > 0 {
+		return report, fmt.Errorf("%d object(s) could not be removed: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return report, nil
+}
+
+// cleanTemp removes the temp objects of bucket older than maxAge and logs what was removed.
+func (bh *BlobHandler) cleanTemp(s3Ctrl *S3Controller, bucket string, maxAge time.Duration, dryRun bool) tempCleanupReport {
+	report, err := s3Ctrl.cleanTempPrefix(bucket, bh.tempPrefix(), time.Now().Add(-maxAge), dryRun)
+	if err != nil {
+		report.Error = err.Error()
+		log.Errorf("error cleaning the temp prefix of bucket %s: %s", bucket, err.Error())
+	}
+	if report.Objects > 0 && !dryRun {
+		log.Infof("removed %d temp object(s) older than %s, %d bytes, from bucket %s: %v", report.Objects, maxAge, report.Bytes, bucket, report.Removed)
+	}
+	return report
+}
+
+// cleanAllTemp cleans the temp prefix of every bucket the controllers serve.
+func (bh *BlobHandler) cleanAllTemp(maxAge time.Duration, dryRun bool) []tempCleanupReport {
+	reports := []tempCleanupReport{}
+	bh.forEachBucket(func(s3Ctrl *S3Controller, bucket string) {
+		reports = append(reports, bh.cleanTemp(s3Ctrl, bucket, maxAge, dryRun))
+	})
+	return reports
+}
+
+// StartTempJanitor removes the scripts and archives older than TempMaxAge from the temp prefix of
+// every bucket, once at startup and then every TempCleanupInterval, unless the interval is 0.
+func (bh *BlobHandler) StartTempJanitor() {
+	interval := bh.Config.TempCleanupInterval
+	if interval <= 0 {
+		log.Info("temp janitor disabled, the temp prefixes are not cleaned up")
+		return
+	}
+	log.Infof("temp janitor removes objects older than %s from %s every %s", bh.Config.TempMaxAge, bh.tempPrefix(), interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			bh.cleanAllTemp(bh.Config.TempMaxAge, false)
+			<-ticker.C
+		}
+	}()
+}
+
+// HandleCleanTemp removes the expired objects from the temp prefix of `bucket`, or of every bucket
+// when it is omitted, and reports what was removed. `older_than` overrides the configured age and
+// `dry_run` only reports what would be removed.
+func (bh *BlobHandler) HandleCleanTemp(c echo.Context) error {
+	maxAge := bh.Config.TempMaxAge
+	if value := c.QueryParam("older_than"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			errMsg := fmt.Errorf("`older_than` must be a positive duration such as 24h, got `%s`", value)
+			log.Error(errMsg.Error())
+			return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+		}
+		maxAge = d
+	}
+	dryRun := false
+	if value := c.QueryParam("dry_run"); value != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			errMsg := fmt.Errorf("error parsing `dry_run` parameter: %s", err.Error())
+			log.Error(errMsg.Error())
+			return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+		}
+	}
+
+	bucket := c.QueryParam("bucket")
+	if bucket == "" {
+		return c.JSON(http.StatusOK, bh.cleanAllTemp(maxAge, dryRun))
+	}
+	s3Ctrl, err := bh.GetController(bucket)
+	if err != nil {
+		errMsg := fmt.Errorf("`bucket` %s is not available, %s", bucket, err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	return c.JSON(http.StatusOK, []tempCleanupReport{bh.cleanTemp(s3Ctrl, bucket, maxAge, dryRun)})
+}
@@ -0,0 +1,48 @@
+package blobstore_test
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCleanTemp(t *testing.T) {
+	srv, bh := newTestHandler(t, "a", "b")
+	srv.PutObject("a", "tmp/script.sh", []byte("#!/bin/bash"))
+	srv.PutObject("a", "tmpfile.txt", []byte("kept"))
+	srv.PutObject("b", "tmp/archives/x.zip", []byte("zip"))
+	// a served bucket the store no longer has is skipped
+	bh.S3Controllers[0].Buckets = append(bh.S3Controllers[0].Buckets, "gone")
+	bh.Config.DefaultTempPrefix = "tmp"
+
+	type report struct {
+		Bucket  string   `json:"bucket"`
+		Objects int      `json:"objects"`
+		Removed []string `json:"removed"`
+		Error   string   `json:"error"`
+	}
+	var reports []report
+	decode(t, serve(t, bh.HandleCleanTemp, http.MethodPost, "/admin/clean_temp?older_than=1ns&dry_run=true", nil), http.StatusOK, &reports)
+	if len(reports) != 2 || reports[0].Bucket != "a" || reports[1].Bucket != "b" {
+		t.Fatalf("got reports %+v, want one for each of a and b", reports)
+	}
+	if _, ok := srv.GetObject("a", "tmp/script.sh"); !ok {
+		t.Fatal("dry run removed an object")
+	}
+
+	reports = nil
+	decode(t, serve(t, bh.HandleCleanTemp, http.MethodPost, "/admin/clean_temp?older_than=1ns", nil), http.StatusOK, &reports)
+	for _, r := range reports {
+		if r.Objects != 1 || r.Error != "" {
+			t.Fatalf("unexpected report %+v", r)
+		}
+	}
+	if keys := srv.Keys("a"); len(keys) != 1 || keys[0] != "tmpfile.txt" {
+		t.Fatalf("bucket a holds %v, want only tmpfile.txt", keys)
+	}
+	if keys := srv.Keys("b"); len(keys) != 0 {
+		t.Fatalf("bucket b holds %v", keys)
+	}
+
+	decode(t, serve(t, bh.HandleCleanTemp, http.MethodPost, "/admin/clean_temp?bucket=gone", nil), http.StatusUnprocessableEntity, nil)
+	decode(t, serve(t, bh.HandleCleanTemp, http.MethodPost, "/admin/clean_temp?older_than=soon", nil), http.StatusUnprocessableEntity, nil)
+}
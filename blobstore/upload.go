@@ -2,117 +2,255 @@ package blobstore
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/labstack/echo/v4"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func (s3Ctrl *S3Controller) UploadS3Obj(bucket string, key string, body io.ReadCloser) error {
-	// Initialize the multipart upload to S3
-	params := &s3.CreateMultipartUploadInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
+// Part sizes and limits of the multipart uploads done by UploadS3Obj
+const (
+	// defaultUploadPartSize is the part size of uploads of unknown size, it doubles every
+	// uploadPartGrowth parts so an upload can reach the largest object size before maxUploadParts.
+	// It is also the smallest part size, S3 refuses parts under 5 MB but the last
+	defaultUploadPartSize = 8 * 1024 * 1024
+	uploadPartGrowth      = 1000
+	maxUploadPartSize     = 5 * 1024 * 1024 * 1024
+	maxUploadParts        = 10000
+	// uploadConcurrency is how many parts of an upload are sent at the same time, which is also
+	// how many part buffers an upload holds at most
+	uploadConcurrency = 4
+	// uploadBufferBudget caps the memory of the part buffers of an upload. Fewer parts are sent at
+	// the same time once the parts grow, and parts of uploads of unknown size stop growing at it.
+	// Only uploads of a known size over 5 TB need larger parts, they hold a single buffer
+	uploadBufferBudget = 512 * 1024 * 1024
+	// uploadPartAttempts is how many times a part is sent before the upload is given up
+	uploadPartAttempts = 3
+)
+
+// uploadPartSize returns the size of part partNumber of an upload of size bytes, or of an
+// upload of unknown size when size is negative.
+func uploadPartSize(size int64, partNumber int) int64 {
+	if size < 0 {
+		growth := (partNumber - 1) / uploadPartGrowth
+		if int64(defaultUploadPartSize)<<uint(growth) >= uploadBufferBudget {
+			return uploadBufferBudget
+		}
+		return int64(defaultUploadPartSize) << uint(growth)
+	}
+	partSize := (size + maxUploadParts - 1) / maxUploadParts
+	if partSize < defaultUploadPartSize {
+		return defaultUploadPartSize
+	}
+	// round up to a whole MB
+	const mb = 1024 * 1024
+	return (partSize + mb - 1) / mb * mb
+}
+
+// uploadSlots returns how many parts of partSize bytes are sent at the same time within the buffer budget.
+func uploadSlots(partSize int64) int {
+	slots := int(uploadBufferBudget / partSize)
+	if slots > uploadConcurrency {
+		return uploadConcurrency
+	}
+	if slots < 1 {
+		return 1
 	}
+	return slots
+}
 
-	resp, err := s3Ctrl.S3Svc.CreateMultipartUpload(params)
+// UploadS3Obj streams body to key with a multipart upload, see UploadS3ObjWithSize.
+func (s3Ctrl *S3Controller) UploadS3Obj(bucket string, key string, body io.ReadCloser) error {
+	return s3Ctrl.UploadS3ObjWithSize(bucket, key, body, -1)
+}
+
+// UploadS3ObjWithSize streams body to key with a multipart upload whose parts are sent concurrently,
+// and retried when they fail, from a bounded pool of reused buffers. size picks a part size that keeps
+// the upload under the part limit, it is -1 when unknown. The upload is aborted when anything fails,
+// so no incomplete upload is left behind.
+func (s3Ctrl *S3Controller) UploadS3ObjWithSize(bucket string, key string, body io.Reader, size int64) error {
+	return s3Ctrl.uploadObject(bucket, key, body, size, uploadChecksums{})
+}
+
+// uploadObject is UploadS3ObjWithSize for a body the client supplied checksums for. The checksums are
+// stored as metadata of the object and the body is verified before the upload is completed, a mismatch
+// aborts it with a statusError.
+func (s3Ctrl *S3Controller) uploadObject(bucket string, key string, body io.Reader, size int64, checksums uploadChecksums) error {
+	if size > maxUploadParts*maxUploadPartSize {
+		return fmt.Errorf("object of %d bytes is larger than a multipart upload can hold", size)
+	}
+	var verify func() error
+	if !checksums.empty() {
+		cr := newChecksumReader(body)
+		body = cr
+		verify = func() error { return cr.verify(checksums) }
+	}
+	resp, err := s3Ctrl.Store.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		Metadata: checksums.metadata(),
+	})
 	if err != nil {
 		return fmt.Errorf("error initializing multipart upload. %s", err.Error())
 	}
+	uploadID := resp.UploadId
 
-	// Create the variables that will track upload progress
-	var totalBytes int64 = 0
-	var partNumber int64 = 1
-	completedParts := []*s3.CompletedPart{}
-	buffer := bytes.NewBuffer(nil)
-
-	for {
-		// Read from the request body into the buffer
-		chunkSize := 1024 * 1024 * 5
-		buf := make([]byte, chunkSize)
-		n, err := body.Read(buf)
+	err = s3Ctrl.uploadParts(bucket, key, uploadID, body, size, verify)
+	if err != nil {
+		_, abortErr := s3Ctrl.Store.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      aws.String(key),
+			UploadId: uploadID,
+		})
+		if abortErr != nil {
+			log.Errorf("error aborting multipart upload %s of %s: %s", aws.StringValue(uploadID), key, abortErr.Error())
+		}
+		return err
+	}
+	return nil
+}
 
-		// This would be a true error while reading
-		if err != nil && err != io.EOF {
-			return fmt.Errorf("error copying POST body to S3. %s", err.Error())
+// uploadParts reads body into parts, sends them and completes the upload. verify, when not nil, is
+// called once all of body was sent and the upload is not completed when it fails.
+func (s3Ctrl *S3Controller) uploadParts(bucket, key string, uploadID *string, body io.Reader, size int64, verify func() error) error {
+	// buffers holds a slot per concurrent part, a slot keeps its buffer for the next part unless the part size grew
+	buffers := make(chan []byte, uploadConcurrency)
+	slots := uploadSlots(uploadPartSize(size, 1))
+	for i := 0; i < slots; i++ {
+		buffers <- nil
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		parts    []*s3.CompletedPart
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
 		}
+	}
+	failed := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr
+	}
 
-		// Add the buffer data to the buffer
-		buffer.Write(buf[:n])
-
-		// Upload a part if the buffer contains more than 5mb of data to avoid AWS EntityTooSmall error
-		if buffer.Len() > chunkSize {
-			params := &s3.UploadPartInput{
-				Bucket:     aws.String(bucket),
-				Key:        aws.String(key),
-				UploadId:   resp.UploadId,
-				PartNumber: aws.Int64(partNumber),
-				Body:       bytes.NewReader(buffer.Bytes()),
-			}
+	for partNumber := 1; ; partNumber++ {
+		if partNumber > maxUploadParts {
+			fail(fmt.Errorf("object is larger than %d parts can hold", maxUploadParts))
+			break
+		}
+		partSize := uploadPartSize(size, partNumber)
+		// retiring a slot waits for a part in flight and drops its buffer, so the larger
+		// buffers are only allocated once the smaller ones can be freed
+		for ; slots > uploadSlots(partSize); slots-- {
+			<-buffers
+		}
+		buf := <-buffers
+		if failed() != nil {
+			buffers <- buf
+			break
+		}
+		if int64(cap(buf)) < partSize {
+			buf = make([]byte, partSize)
+		}
+		n, err := io.ReadFull(body, buf[:partSize])
+		// an empty part is only sent when the whole object is empty
+		if err == io.EOF && partNumber > 1 {
+			buffers <- buf
+			break
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			buffers <- buf
+			fail(fmt.Errorf("error copying POST body to S3. %s", err.Error()))
+			break
+		}
+		last := err != nil
 
-			result, err := s3Ctrl.S3Svc.UploadPart(params)
+		wg.Add(1)
+		go func(partNumber int64, buf []byte, data []byte) {
+			defer wg.Done()
+			defer func() { buffers <- buf }()
+			etag, err := s3Ctrl.uploadPart(bucket, key, uploadID, partNumber, data)
 			if err != nil {
-				return fmt.Errorf("error streaming POST body to S3. %s, %+v", err.Error(), result)
+				fail(err)
+				return
 			}
+			mu.Lock()
+			parts = append(parts, &s3.CompletedPart{ETag: etag, PartNumber: aws.Int64(partNumber)})
+			mu.Unlock()
+		}(int64(partNumber), buf, buf[:n])
 
-			totalBytes += int64(buffer.Len())
-			completedParts = append(completedParts, &s3.CompletedPart{
-				ETag:       result.ETag,
-				PartNumber: aws.Int64(partNumber),
-			})
-
-			buffer.Reset()
-			partNumber++
-		}
-
-		if err == io.EOF {
+		if last {
 			break
 		}
 	}
-
-	// Upload the remaining data as the last part
-	params2 := &s3.UploadPartInput{
-		Bucket:     aws.String(bucket),
-		Key:        aws.String(key),
-		UploadId:   resp.UploadId,
-		PartNumber: aws.Int64(partNumber),
-		Body:       bytes.NewReader(buffer.Bytes()),
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
 	}
-
-	result, err := s3Ctrl.S3Svc.UploadPart(params2)
-	if err != nil {
-		return fmt.Errorf("error streaming POST body to S3. %s, %+v", err.Error(), result)
+	if verify != nil {
+		if err := verify(); err != nil {
+			return err
+		}
 	}
 
-	totalBytes += int64(buffer.Len())
-	completedParts = append(completedParts, &s3.CompletedPart{
-		ETag:       result.ETag,
-		PartNumber: aws.Int64(partNumber),
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.Int64Value(parts[i].PartNumber) < aws.Int64Value(parts[j].PartNumber)
 	})
-
-	// Complete the multipart upload
-	completeParams := &s3.CompleteMultipartUploadInput{
+	_, err := s3Ctrl.Store.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
 		Bucket:          aws.String(bucket),
 		Key:             aws.String(key),
-		UploadId:        resp.UploadId,
-		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completedParts},
-	}
-	_, err = s3Ctrl.S3Svc.CompleteMultipartUpload(completeParams)
+		UploadId:        uploadID,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
 	if err != nil {
 		return fmt.Errorf("error completing multipart upload. %s", err.Error())
 	}
-
 	return nil
 }
 
+// uploadPart sends a part, trying again with a growing delay when it fails.
+func (s3Ctrl *S3Controller) uploadPart(bucket, key string, uploadID *string, partNumber int64, data []byte) (*string, error) {
+	var err error
+	for attempt := 0; attempt < uploadPartAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt*attempt) * 500 * time.Millisecond)
+		}
+		var result *s3.UploadPartOutput
+		result, err = s3Ctrl.Store.UploadPart(&s3.UploadPartInput{
+			Bucket:     aws.String(bucket),
+			Key:        aws.String(key),
+			UploadId:   uploadID,
+			PartNumber: aws.Int64(partNumber),
+			Body:       bytes.NewReader(data),
+			ContentMD5: partContentMD5(data),
+		})
+		if err == nil {
+			return result.ETag, nil
+		}
+		log.Warnf("error uploading part %d of %s, attempt %d of %d: %s", partNumber, key, attempt+1, uploadPartAttempts, err.Error())
+	}
+	return nil, fmt.Errorf("error streaming POST body to S3. part %d: %s", partNumber, err.Error())
+}
+
 func (bh *BlobHandler) HandleMultipartUpload(c echo.Context) error {
 	// Add overwrite check and parameter
 	key := c.QueryParam("key")
@@ -156,6 +294,12 @@ func (bh *BlobHandler) HandleMultipartUpload(c echo.Context) error {
 		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
 	}
 
+	checksums, err := parseUploadChecksums(c)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(http.StatusUnprocessableEntity, err.Error())
+	}
+
 	// Check if the request body is empty
 	buf := make([]byte, 1)
 	_, err = c.Request().Body.Read(buf)
@@ -183,10 +327,13 @@ func (bh *BlobHandler) HandleMultipartUpload(c echo.Context) error {
 	body := c.Request().Body
 	defer body.Close()
 
-	err = s3Ctrl.UploadS3Obj(bucket, key, body)
+	err = s3Ctrl.uploadObject(bucket, key, body, c.Request().ContentLength, checksums)
 	if err != nil {
 		errMsg := fmt.Errorf("error uploading S3 object: %s", err.Error())
 		log.Errorf(errMsg.Error())
+		if serr, ok := err.(*statusError); ok {
+			return c.JSON(serr.status, errMsg.Error())
+		}
 		return c.JSON(http.StatusInternalServerError, errMsg.Error())
 	}
 
@@ -195,14 +342,16 @@ func (bh *BlobHandler) HandleMultipartUpload(c echo.Context) error {
 }
 
 // function to retrieve presigned url for a normal one time upload. You can only upload 5GB files at a time.
-func (s3Ctrl *S3Controller) GetUploadPresignedURL(bucket string, key string, expMin int) (string, error) {
+// The store refuses an upload that doesn't match the checksums, which are stored as metadata of the object.
+func (s3Ctrl *S3Controller) GetUploadPresignedURL(bucket string, key string, expMin int, checksums uploadChecksums) (string, error) {
 	duration := time.Duration(expMin) * time.Minute
-	req, _ := s3Ctrl.S3Svc.PutObjectRequest(&s3.PutObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
-
-	urlStr, err := req.Presign(duration)
+	urlStr, err := s3Ctrl.Store.PresignPutObject(&s3.PutObjectInput{
+		Bucket:         aws.String(bucket),
+		Key:            aws.String(key),
+		ContentMD5:     checksums.contentMD5(),
+		ChecksumSHA256: checksums.checksumSHA256(),
+		Metadata:       checksums.metadata(),
+	}, duration)
 	if err != nil {
 		return "", err
 	}
@@ -210,48 +359,19 @@ func (s3Ctrl *S3Controller) GetUploadPresignedURL(bucket string, key string, exp
 	return urlStr, nil
 }
 
-// function to retrieve presigned url for a multipart upload part.
-func (s3Ctrl *S3Controller) GetUploadPartPresignedURL(bucket string, key string, uploadID string, partNumber int64, expMin int) (string, error) {
+// function to retrieve presigned url for a multipart upload part. The store refuses a part that doesn't match the checksums.
+func (s3Ctrl *S3Controller) GetUploadPartPresignedURL(bucket string, key string, uploadID string, partNumber int64, expMin int, checksums uploadChecksums) (string, error) {
 	duration := time.Duration(expMin) * time.Minute
-	var urlStr string
-	var err error
-	if s3Ctrl.S3Mock {
-		// Create a temporary S3 client with the modified endpoint
-		//this is done  so that the presigned url starts with localhost:9000 instead of
-		//minio:9000 which would cause an error due to cors origin policy
-		tempS3Svc, err := session.NewSession(&aws.Config{
-			Endpoint:         aws.String("http://localhost:9000"),
-			Region:           s3Ctrl.S3Svc.Config.Region,
-			Credentials:      s3Ctrl.S3Svc.Config.Credentials,
-			S3ForcePathStyle: aws.Bool(true),
-		})
-		if err != nil {
-			return "", fmt.Errorf("error creating temporary s3 session: %s", err.Error())
-		}
-
-		// Generate the request using the temporary client
-		req, _ := s3.New(tempS3Svc).UploadPartRequest(&s3.UploadPartInput{
-			Bucket:     aws.String(bucket),
-			Key:        aws.String(key),
-			UploadId:   aws.String(uploadID),
-			PartNumber: aws.Int64(partNumber),
-		})
-		urlStr, err = req.Presign(duration)
-		if err != nil {
-			return "", err
-		}
-	} else {
-		// Generate the request using the original client
-		req, _ := s3Ctrl.S3Svc.UploadPartRequest(&s3.UploadPartInput{
-			Bucket:     aws.String(bucket),
-			Key:        aws.String(key),
-			UploadId:   aws.String(uploadID),
-			PartNumber: aws.Int64(partNumber),
-		})
-		urlStr, err = req.Presign(duration)
-		if err != nil {
-			return "", err
-		}
+	urlStr, err := s3Ctrl.Store.PresignUploadPart(&s3.UploadPartInput{
+		Bucket:         aws.String(bucket),
+		Key:            aws.String(key),
+		UploadId:       aws.String(uploadID),
+		PartNumber:     aws.Int64(partNumber),
+		ContentMD5:     checksums.contentMD5(),
+		ChecksumSHA256: checksums.checksumSHA256(),
+	}, duration)
+	if err != nil {
+		return "", err
 	}
 
 	return urlStr, nil
@@ -279,6 +399,11 @@ func (bh *BlobHandler) HandleGetPresignedUploadURL(c echo.Context) error {
 		log.Error(errMsg.Error())
 		return c.JSON(httpCode, errMsg.Error())
 	}
+	checksums, err := parseUploadChecksums(c)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(http.StatusUnprocessableEntity, err.Error())
+	}
 	uploadID := c.QueryParam("upload_id")
 	partNumberStr := c.QueryParam("part_number")
 
@@ -290,13 +415,17 @@ func (bh *BlobHandler) HandleGetPresignedUploadURL(c echo.Context) error {
 			log.Error(errMsg.Error())
 			return c.JSON(http.StatusInternalServerError, errMsg.Error())
 		}
-		presignedURL, err := s3Ctrl.GetUploadPartPresignedURL(bucket, key, uploadID, int64(partNumber), bh.Config.DefaultUploadPresignedUrlExpiration)
+		uploadKey, storeUploadID, _ := bh.uploadTarget(key, uploadID)
+		presignedURL, err := s3Ctrl.GetUploadPartPresignedURL(bucket, uploadKey, storeUploadID, int64(partNumber), bh.Config.DefaultUploadPresignedUrlExpiration, checksums)
 		if err != nil {
 			errMsg := fmt.Errorf("error generating presigned part URL: %s", err.Error())
 			log.Error(errMsg.Error())
 			return c.JSON(http.StatusInternalServerError, errMsg.Error())
 		}
 		log.Infof("successfully generated presigned part URL for key: %s", key)
+		if !checksums.empty() {
+			return c.JSON(http.StatusOK, presignedUpload{URL: presignedURL, Headers: checksums.presignedUploadHeaders()})
+		}
 		return c.JSON(http.StatusOK, presignedURL)
 	} else if (uploadID == "" && partNumberStr != "") || (uploadID != "" && partNumberStr == "") {
 		errMsg := fmt.Errorf("both 'uploadID' and 'partNumber' must be provided together for a multipart upload, or neither for a standard upload")
@@ -304,23 +433,31 @@ func (bh *BlobHandler) HandleGetPresignedUploadURL(c echo.Context) error {
 		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
 	}
 	//if the user did not provided both upload_id and part_number then we returned normal presigned URL
-	presignedURL, err := s3Ctrl.GetUploadPresignedURL(bucket, key, bh.Config.DefaultUploadPresignedUrlExpiration)
+	presignedURL, err := s3Ctrl.GetUploadPresignedURL(bucket, key, bh.Config.DefaultUploadPresignedUrlExpiration, checksums)
 	if err != nil {
 		log.Errorf("error generating presigned URL: %s", err.Error())
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
 	log.Infof("successfully generated presigned URL for key: %s", key)
+	if !checksums.empty() {
+		return c.JSON(http.StatusOK, presignedUpload{URL: presignedURL, Headers: checksums.presignedUploadHeaders()})
+	}
 	return c.JSON(http.StatusOK, presignedURL)
 }
 
-// function that will return a multipart upload ID
-func (s3Ctrl *S3Controller) GetMultiPartUploadID(bucket string, key string) (string, error) {
+// function that will return a multipart upload ID. The checksums of the whole object are stored as its
+// metadata and checked when the upload is completed, partChecksums has every part carry a sha256.
+func (s3Ctrl *S3Controller) GetMultiPartUploadID(bucket string, key string, checksums uploadChecksums, partChecksums bool) (string, error) {
 	input := &s3.CreateMultipartUploadInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		Metadata: checksums.metadata(),
 	}
-	result, err := s3Ctrl.S3Svc.CreateMultipartUpload(input)
+	if partChecksums {
+		input.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmSha256)
+	}
+	result, err := s3Ctrl.Store.CreateMultipartUpload(input)
 	if err != nil {
 		return "", err
 	}
@@ -349,13 +486,36 @@ func (bh *BlobHandler) HandleGetMultipartUploadID(c echo.Context) error {
 		log.Error(errMsg.Error())
 		return c.JSON(httpCode, errMsg.Error())
 	}
+	checksums, err := parseUploadChecksums(c)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(http.StatusUnprocessableEntity, err.Error())
+	}
+	partChecksums := false
+	switch value := c.QueryParam("part_checksums"); value {
+	case "":
+	case "sha256":
+		partChecksums = true
+	default:
+		errMsg := fmt.Errorf("`part_checksums` only supports `sha256`, got `%s`", value)
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
 
-	uploadID, err := s3Ctrl.GetMultiPartUploadID(bucket, key)
+	// uploads with checksums are staged until they are verified
+	uploadKey := key
+	if !checksums.empty() {
+		uploadKey = bh.stagingKey(key)
+	}
+	uploadID, err := s3Ctrl.GetMultiPartUploadID(bucket, uploadKey, checksums, partChecksums)
 	if err != nil {
 		errMsg := fmt.Errorf("error retrieving multipart Upload ID: %s", err.Error())
 		log.Error(errMsg.Error())
 		return c.JSON(http.StatusInternalServerError, errMsg.Error())
 	}
+	if uploadKey != key {
+		uploadID = stagedUploadPrefix + uploadID
+	}
 	log.Infof("successfully generated multipart Upload ID for key: %s", key)
 	return c.JSON(http.StatusOK, uploadID)
 }
@@ -370,13 +530,47 @@ func (s3Ctrl *S3Controller) CompleteMultipartUpload(bucket string, key string, u
 			Parts: parts,
 		},
 	}
-	result, err := s3Ctrl.S3Svc.CompleteMultipartUpload(input)
+	result, err := s3Ctrl.Store.CompleteMultipartUpload(input)
 	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
+// verifyCompletedUpload checks a completed staged upload against the checksums stored as its
+// metadata when the upload was created and the sha256 given when it was completed. An object that
+// doesn't match is deleted.
+func (s3Ctrl *S3Controller) verifyCompletedUpload(bucket string, key string, sha256Hex string) error {
+	head, err := s3Ctrl.Store.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	checksums := uploadChecksums{SHA256: strings.ToLower(metadataValue(head.Metadata, sha256MetadataKey))}
+	if md5Hex := metadataValue(head.Metadata, md5MetadataKey); md5Hex != "" {
+		if sum, err := hex.DecodeString(md5Hex); err == nil {
+			checksums.MD5 = base64.StdEncoding.EncodeToString(sum)
+		}
+	}
+	if sha256Hex != "" {
+		if checksums.SHA256 != "" && checksums.SHA256 != sha256Hex {
+			err = statusErrorf(http.StatusBadRequest, "checksum mismatch: `sha256` is %s, the upload was created with %s", sha256Hex, checksums.SHA256)
+		}
+		checksums.SHA256 = sha256Hex
+	}
+	if err == nil && !checksums.empty() {
+		err = s3Ctrl.verifyObjectChecksums(bucket, key, checksums)
+	}
+	if _, ok := err.(*statusError); ok {
+		if _, delErr := s3Ctrl.Store.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}); delErr != nil {
+			log.Errorf("error deleting %s after a checksum mismatch: %s", key, delErr.Error())
+		}
+	}
+	return err
+}
+
 // endpoint handler that will complete a multipart upload
 func (bh *BlobHandler) HandleCompleteMultipartUpload(c echo.Context) error {
 	key := c.QueryParam("key")
@@ -401,10 +595,14 @@ func (bh *BlobHandler) HandleCompleteMultipartUpload(c echo.Context) error {
 	type part struct {
 		PartNumber int    `json:"partNumber"`
 		ETag       string `json:"eTag"`
+		// ChecksumSHA256 is the hex encoded sha256 of the part, required by uploads created with `part_checksums`
+		ChecksumSHA256 string `json:"checksumSHA256"`
 	}
 	type completeUploadRequest struct {
 		UploadID string `json:"uploadId"`
 		Parts    []part `json:"parts"`
+		// SHA256 is the hex encoded sha256 of the whole object, checked like the one given when the upload was created
+		SHA256 string `json:"sha256"`
 	}
 	var req completeUploadRequest
 	if err := c.Bind(&req); err != nil {
@@ -419,14 +617,52 @@ func (bh *BlobHandler) HandleCompleteMultipartUpload(c echo.Context) error {
 			PartNumber: aws.Int64(int64(part.PartNumber)),
 			ETag:       aws.String(part.ETag),
 		}
+		if part.ChecksumSHA256 != "" {
+			sum, err := hex.DecodeString(part.ChecksumSHA256)
+			if err != nil || len(sum) != sha256.Size {
+				errMsg := fmt.Errorf("`checksumSHA256` of part %d must be a hex encoded sha256, got `%s`", part.PartNumber, part.ChecksumSHA256)
+				log.Error(errMsg.Error())
+				return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+			}
+			s3Parts[i].ChecksumSHA256 = aws.String(base64.StdEncoding.EncodeToString(sum))
+		}
+	}
+	uploadKey, storeUploadID, staged := bh.uploadTarget(key, req.UploadID)
+	req.SHA256 = strings.ToLower(req.SHA256)
+	if req.SHA256 != "" {
+		if sum, err := hex.DecodeString(req.SHA256); err != nil || len(sum) != sha256.Size {
+			errMsg := fmt.Errorf("`sha256` must be the hex encoded sha256 of the object, got `%s`", req.SHA256)
+			log.Error(errMsg.Error())
+			return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+		}
+		// only a staged upload can be checked before it is at its key
+		if !staged {
+			errMsg := fmt.Errorf("`sha256` can only be checked for uploads created with `sha256` or `content_md5`")
+			log.Error(errMsg.Error())
+			return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+		}
 	}
 
-	_, err = s3Ctrl.CompleteMultipartUpload(bucket, key, req.UploadID, s3Parts)
+	_, err = s3Ctrl.CompleteMultipartUpload(bucket, uploadKey, storeUploadID, s3Parts)
 	if err != nil {
 		errMsg := fmt.Errorf("error completing the multipart Upload for key %s, %s", key, err)
 		log.Error(errMsg.Error())
 		return c.JSON(http.StatusInternalServerError, errMsg.Error())
 	}
+	if staged {
+		err = s3Ctrl.verifyCompletedUpload(bucket, uploadKey, req.SHA256)
+		if err == nil {
+			err = s3Ctrl.copyVerifiedObject(bucket, uploadKey, key)
+		}
+		if err != nil {
+			errMsg := fmt.Errorf("error verifying the multipart Upload for key %s, %s", key, err.Error())
+			log.Error(errMsg.Error())
+			if serr, ok := err.(*statusError); ok {
+				return c.JSON(serr.status, errMsg.Error())
+			}
+			return c.JSON(http.StatusInternalServerError, errMsg.Error())
+		}
+	}
 	log.Infof("succesfully completed multipart upload for key %s", key)
 	return c.JSON(http.StatusOK, "succesfully completed multipart upload")
 }
@@ -438,7 +674,7 @@ func (s3Ctrl *S3Controller) AbortMultipartUpload(bucket string, key string, uplo
 		Key:      aws.String(key),
 		UploadId: aws.String(uploadID),
 	}
-	_, err := s3Ctrl.S3Svc.AbortMultipartUpload(input)
+	_, err := s3Ctrl.Store.AbortMultipartUpload(input)
 	if err != nil {
 		return err
 	}
@@ -474,7 +710,8 @@ func (bh *BlobHandler) HandleAbortMultipartUpload(c echo.Context) error {
 		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
 	}
 
-	err = s3Ctrl.AbortMultipartUpload(bucket, key, uploadID)
+	uploadKey, storeUploadID, _ := bh.uploadTarget(key, uploadID)
+	err = s3Ctrl.AbortMultipartUpload(bucket, uploadKey, storeUploadID)
 	if err != nil {
 		errMsg := fmt.Errorf("error aborting the multipart Upload for key %s, %s", key, err.Error())
 		log.Error(errMsg.Error())
@@ -0,0 +1,33 @@
+package blobstore
+
+import "testing"
+
+func TestUploadPartsStayWithinBufferBudget(t *testing.T) {
+	var total int64
+	for partNumber := 1; partNumber <= maxUploadParts; partNumber++ {
+		partSize := uploadPartSize(-1, partNumber)
+		if partSize < defaultUploadPartSize || partSize > uploadBufferBudget {
+			t.Fatalf("part %d of an upload of unknown size is %d bytes", partNumber, partSize)
+		}
+		if buffers := int64(uploadSlots(partSize)) * partSize; buffers > uploadBufferBudget {
+			t.Fatalf("part %d holds %d bytes of buffers", partNumber, buffers)
+		}
+		total += partSize
+	}
+	if total < 2*1024*1024*1024*1024 {
+		t.Fatalf("uploads of unknown size only reach %d bytes", total)
+	}
+
+	for _, size := range []int64{0, 1, 100 * 1024 * 1024 * 1024, maxUploadParts * uploadBufferBudget} {
+		partSize := uploadPartSize(size, 1)
+		if int64(maxUploadParts)*partSize < size {
+			t.Fatalf("parts of %d bytes don't fit %d bytes", partSize, size)
+		}
+		if buffers := int64(uploadSlots(partSize)) * partSize; buffers > uploadBufferBudget {
+			t.Fatalf("an upload of %d bytes holds %d bytes of buffers", size, buffers)
+		}
+	}
+	if slots := uploadSlots(maxUploadPartSize); slots != 1 {
+		t.Fatalf("got %d slots for the largest parts", slots)
+	}
+}
@@ -0,0 +1,108 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMultipartUpload(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	data := []byte("uploaded through the service")
+
+	rec := serve(t, bh.HandleMultipartUpload, http.MethodPost, "/object/upload?bucket=bkt&key=up/a.txt&override=false", bytes.NewReader(data))
+	decode(t, rec, http.StatusOK, nil)
+	if got, ok := srv.GetObject("bkt", "up/a.txt"); !ok || !bytes.Equal(got, data) {
+		t.Fatalf("got %q, want %q", got, data)
+	}
+
+	rec = serve(t, bh.HandleMultipartUpload, http.MethodPost, "/object/upload?bucket=bkt&key=up/a.txt&override=false", bytes.NewReader(data))
+	decode(t, rec, http.StatusConflict, nil)
+	rec = serve(t, bh.HandleMultipartUpload, http.MethodPost, "/object/upload?bucket=bkt&key=up/a.txt&override=true", strings.NewReader("replaced"))
+	decode(t, rec, http.StatusOK, nil)
+	if got, _ := srv.GetObject("bkt", "up/a.txt"); string(got) != "replaced" {
+		t.Fatalf("got %q after override", got)
+	}
+
+	rec = serve(t, bh.HandleMultipartUpload, http.MethodPost, "/object/upload?bucket=bkt&key=up/b.txt&override=true", strings.NewReader(""))
+	decode(t, rec, http.StatusBadRequest, nil)
+	rec = serve(t, bh.HandleMultipartUpload, http.MethodPost, "/object/upload?bucket=bkt&key=up/b.txt", bytes.NewReader(data))
+	decode(t, rec, http.StatusUnprocessableEntity, nil)
+}
+
+func TestMultipartUploadParts(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	// three parts of unknown size, sent concurrently and completed in order
+	data := make([]byte, 20*1024*1024)
+	for i := range data {
+		data[i] = byte(i / 4096)
+	}
+	rec := serve(t, bh.HandleMultipartUpload, http.MethodPost, "/object/upload?bucket=bkt&key=big.bin&override=true", bytes.NewReader(data))
+	decode(t, rec, http.StatusOK, nil)
+	if got, _ := srv.GetObject("bkt", "big.bin"); !bytes.Equal(got, data) {
+		t.Fatalf("got %d bytes that differ from the %d uploaded", len(got), len(data))
+	}
+}
+
+func TestPresignedUpload(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+
+	var url string
+	decode(t, serve(t, bh.HandleGetPresignedUploadURL, http.MethodGet, "/object/presigned_upload?bucket=bkt&key=put/a.txt", nil), http.StatusOK, &url)
+	if resp, body := fetch(t, http.MethodPut, url, strings.NewReader("presigned")); resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT to presigned URL: %d %s", resp.StatusCode, body)
+	}
+	if got, _ := srv.GetObject("bkt", "put/a.txt"); string(got) != "presigned" {
+		t.Fatalf("got %q", got)
+	}
+
+	rec := serve(t, bh.HandleGetPresignedUploadURL, http.MethodGet, "/object/presigned_upload?bucket=bkt&key=put/a.txt&part_number=1", nil)
+	decode(t, rec, http.StatusUnprocessableEntity, nil)
+}
+
+func TestMultipartCompleteAndAbort(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	key := "multi/big.bin"
+	first := bytes.Repeat([]byte("a"), 5*1024*1024)
+	second := []byte("tail")
+
+	var uploadID string
+	decode(t, serve(t, bh.HandleGetMultipartUploadID, http.MethodGet, "/object/multipart_upload_id?bucket=bkt&key="+key, nil), http.StatusOK, &uploadID)
+
+	type part struct {
+		PartNumber int    `json:"partNumber"`
+		ETag       string `json:"eTag"`
+	}
+	var parts []part
+	for i, data := range [][]byte{first, second} {
+		var url string
+		target := "/object/presigned_upload?bucket=bkt&key=" + key + "&upload_id=" + uploadID + "&part_number=" + strconv.Itoa(i+1)
+		decode(t, serve(t, bh.HandleGetPresignedUploadURL, http.MethodGet, target, nil), http.StatusOK, &url)
+		resp, body := fetch(t, http.MethodPut, url, bytes.NewReader(data))
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT part %d: %d %s", i+1, resp.StatusCode, body)
+		}
+		parts = append(parts, part{PartNumber: i + 1, ETag: resp.Header.Get("ETag")})
+	}
+	complete := map[string]interface{}{"uploadId": uploadID, "parts": parts}
+	rec := serve(t, bh.HandleCompleteMultipartUpload, http.MethodPost, "/object/complete_multipart_upload?bucket=bkt&key="+key, complete)
+	decode(t, rec, http.StatusOK, nil)
+	if got, _ := srv.GetObject("bkt", key); !bytes.Equal(got, append(first, second...)) {
+		t.Fatalf("completed object has %d bytes, want %d", len(got), len(first)+len(second))
+	}
+	rec = serve(t, bh.HandleCompleteMultipartUpload, http.MethodPost, "/object/complete_multipart_upload?bucket=bkt&key="+key, complete)
+	decode(t, rec, http.StatusInternalServerError, nil)
+
+	decode(t, serve(t, bh.HandleGetMultipartUploadID, http.MethodGet, "/object/multipart_upload_id?bucket=bkt&key=multi/aborted.bin", nil), http.StatusOK, &uploadID)
+	rec = serve(t, bh.HandleAbortMultipartUpload, http.MethodPost, "/object/abort_multipart_upload?bucket=bkt&key=multi/aborted.bin&upload_id="+uploadID, nil)
+	decode(t, rec, http.StatusOK, nil)
+	rec = serve(t, bh.HandleAbortMultipartUpload, http.MethodPost, "/object/abort_multipart_upload?bucket=bkt&key=multi/aborted.bin&upload_id="+uploadID, nil)
+	decode(t, rec, http.StatusInternalServerError, nil)
+	rec = serve(t, bh.HandleAbortMultipartUpload, http.MethodPost, "/object/abort_multipart_upload?bucket=bkt&key=multi/aborted.bin", nil)
+	decode(t, rec, http.StatusUnprocessableEntity, nil)
+	if _, ok := srv.GetObject("bkt", "multi/aborted.bin"); ok {
+		t.Fatal("aborted upload left an object")
+	}
+}
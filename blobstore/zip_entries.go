@@ -0,0 +1,363 @@
+package blobstore
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// zipDirectoryBytes is how much of a zip may be fetched to read its central directory
+const zipDirectoryBytes = 64 * 1024 * 1024
+
+// maxExtractEntries is the most entries a single extract request writes
+const maxExtractEntries = 1000
+
+// zipEntry describes an entry of a zip stored in a bucket.
+type zipEntry struct {
+	Name           string    `json:"name"`
+	Size           uint64    `json:"size"`
+	CompressedSize uint64    `json:"compressed_size"`
+	Modified       time.Time `json:"modified"`
+	CRC32          uint32    `json:"crc32"`
+	IsDir          bool      `json:"is_dir"`
+}
+
+// openZip reads the central directory of the zip at key with ranged requests.
+func (s3Ctrl *S3Controller) openZip(bucket, key string) (*zip.Reader, *objectReaderAt, error) {
+	ra, err := s3Ctrl.newObjectReaderAt(bucket, key, zipDirectoryBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	zr, err := zip.NewReader(ra, ra.Size())
+	if err != nil {
+		if ra.Exceeded() {
+			return nil, nil, statusErrorf(http.StatusRequestEntityTooLarge, "the central directory of %s is larger than %d MB", key, zipDirectoryBytes/1024/1024)
+		}
+		return nil, nil, statusErrorf(http.StatusUnprocessableEntity, "error reading %s as a zip: %s", key, err.Error())
+	}
+	return zr, ra, nil
+}
+
+// openZipEntry streams the content of an entry with a single ranged request for its compressed
+// bytes, the reader fails at the end of the entry when its CRC-32 doesn't match.
+func (s3Ctrl *S3Controller) openZipEntry(ra *objectReaderAt, f *zip.File) (io.ReadCloser, error) {
+	if f.Flags&0x1 != 0 {
+		return nil, statusErrorf(http.StatusUnprocessableEntity, "entry %s is encrypted", f.Name)
+	}
+	if f.Method != zip.Store && f.Method != zip.Deflate {
+		return nil, statusErrorf(http.StatusUnprocessableEntity, "entry %s is compressed with the unsupported method %d", f.Name, f.Method)
+	}
+	offset, err := f.DataOffset()
+	if err != nil {
+		return nil, err
+	}
+	if f.CompressedSize64 == 0 {
+		return &crcReader{r: strings.NewReader(""), hash: crc32.NewIEEE(), want: f.CRC32, name: f.Name}, nil
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(ra.bucket),
+		Key:    aws.String(ra.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+int64(f.CompressedSize64)-1)),
+	}
+	if ra.etag != "" {
+		input.IfMatch = aws.String(ra.etag)
+	}
+	output, err := s3Ctrl.Store.GetObject(input)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = io.LimitReader(output.Body, int64(f.CompressedSize64))
+	closers := []io.Closer{output.Body}
+	if f.Method == zip.Deflate {
+		fr := flate.NewReader(r)
+		// the decompressor is closed first, it reads from the body
+		closers = []io.Closer{fr, output.Body}
+		r = fr
+	}
+	return &crcReader{r: io.LimitReader(r, int64(f.UncompressedSize64)), closers: closers, hash: crc32.NewIEEE(), want: f.CRC32, name: f.Name}, nil
+}
+
+type crcReader struct {
+	r       io.Reader
+	closers []io.Closer
+	hash    hash.Hash32
+	want    uint32
+	name    string
+}
+
+func (c *crcReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	if err == io.EOF && c.hash.Sum32() != c.want {
+		return n, fmt.Errorf("checksum mismatch for entry %s", c.name)
+	}
+	return n, err
+}
+
+func (c *crcReader) Close() error {
+	var first error
+	for _, closer := range c.closers {
+		if err := closer.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// zipEntryName checks that an entry name stays inside the directory it is extracted to.
+func zipEntryName(name string) (string, error) {
+	clean := path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if strings.HasPrefix(clean, "/") || clean == ".." || strings.HasPrefix(clean, "../") || clean == "." {
+		return "", fmt.Errorf("entry name %s points outside of the destination", name)
+	}
+	return clean, nil
+}
+
+// readableZip resolves the bucket of the request and checks that the caller may read key.
+func (bh *BlobHandler) readableZip(c echo.Context, bucket, key string) (*S3Controller, int, error) {
+	if key == "" {
+		return nil, http.StatusUnprocessableEntity, fmt.Errorf("parameter 'key' is required")
+	}
+	s3Ctrl, err := bh.GetController(bucket)
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, fmt.Errorf("`bucket` %s is not available, %s", bucket, err.Error())
+	}
+	permissions, fullAccess, statusCode, err := bh.GetS3ReadPermissions(c, bucket)
+	if err != nil {
+		return nil, statusCode, err
+	}
+	if !fullAccess && !IsPermittedPrefix(bucket, key, permissions) {
+		return nil, http.StatusForbidden, fmt.Errorf("user does not have permission to read the %s key", key)
+	}
+	return s3Ctrl, http.StatusOK, nil
+}
+
+// zipError answers a failed zip request, with the status of a statusError when it is one.
+func zipError(c echo.Context, key string, err error) error {
+	if perr, ok := err.(*statusError); ok {
+		log.Error(perr.Error())
+		return c.JSON(perr.status, perr.Error())
+	}
+	return objectContentError(c, key, err)
+}
+
+// HandleListZipEntries lists the entries of a zip stored in a bucket, only its central directory is read.
+func (bh *BlobHandler) HandleListZipEntries(c echo.Context) error {
+	bucket := c.QueryParam("bucket")
+	key := c.QueryParam("key")
+	s3Ctrl, statusCode, err := bh.readableZip(c, bucket, key)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(statusCode, err.Error())
+	}
+	zr, _, err := s3Ctrl.openZip(bucket, key)
+	if err != nil {
+		return zipError(c, key, err)
+	}
+	entries := make([]zipEntry, 0, len(zr.File))
+	for _, f := range zr.File {
+		entries = append(entries, zipEntry{
+			Name:           f.Name,
+			Size:           f.UncompressedSize64,
+			CompressedSize: f.CompressedSize64,
+			Modified:       f.Modified,
+			CRC32:          f.CRC32,
+			IsDir:          strings.HasSuffix(f.Name, "/"),
+		})
+	}
+	log.Infof("successfully listed %d entries of zip %s in bucket %s", len(entries), key, bucket)
+	return c.JSON(http.StatusOK, entries)
+}
+
+// HandleGetZipEntry streams a single entry of a zip stored in a bucket.
+func (bh *BlobHandler) HandleGetZipEntry(c echo.Context) error {
+	bucket := c.QueryParam("bucket")
+	key := c.QueryParam("key")
+	name := c.QueryParam("entry")
+	if name == "" {
+		errMsg := fmt.Errorf("parameter 'entry' is required")
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	s3Ctrl, statusCode, err := bh.readableZip(c, bucket, key)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(statusCode, err.Error())
+	}
+	zr, ra, err := s3Ctrl.openZip(bucket, key)
+	if err != nil {
+		return zipError(c, key, err)
+	}
+	var file *zip.File
+	for _, f := range zr.File {
+		if f.Name == name && !strings.HasSuffix(f.Name, "/") {
+			file = f
+			break
+		}
+	}
+	if file == nil {
+		errMsg := fmt.Errorf("zip %s has no entry %s", key, name)
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusNotFound, errMsg.Error())
+	}
+	body, err := s3Ctrl.openZipEntry(ra, file)
+	if err != nil {
+		return zipError(c, key, err)
+	}
+	defer body.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = echo.MIMEOctetStream
+	}
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, contentType)
+	header.Set(echo.HeaderContentLength, fmt.Sprintf("%d", file.UncompressedSize64))
+	header.Set(echo.HeaderContentDisposition, attachmentDisposition(path.Base(name)))
+	c.Response().WriteHeader(http.StatusOK)
+	if _, err := io.Copy(c.Response(), body); err != nil {
+		// the status line is already sent, all that is left is to log the aborted transfer
+		log.Errorf("error streaming entry %s of zip %s: %s", name, key, err.Error())
+		return nil
+	}
+	log.Infof("successfully streamed entry %s of zip %s in bucket %s", name, key, bucket)
+	return nil
+}
+
+// zipExtractResult reports the extraction of one entry.
+type zipExtractResult struct {
+	Entry  string `json:"entry"`
+	Key    string `json:"key,omitempty"`
+	Size   uint64 `json:"size"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HandleExtractZipEntries writes entries of a zip stored in a bucket under a destination prefix of the
+// same bucket. Entries are named in the body, a name ending with a slash selects every entry under it.
+func (bh *BlobHandler) HandleExtractZipEntries(c echo.Context) error {
+	var body struct {
+		Entries     []string `json:"entries"`
+		Destination string   `json:"destination"`
+	}
+	if err := c.Bind(&body); err != nil {
+		errMsg := fmt.Errorf("error parsing request body: %s", err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	if len(body.Entries) == 0 {
+		errMsg := fmt.Errorf("request body must list the `entries` to extract")
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	destination := strings.Trim(body.Destination, "/")
+	if destination == "" {
+		errMsg := fmt.Errorf("request body must include a `destination` prefix")
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusUnprocessableEntity, errMsg.Error())
+	}
+	destination += "/"
+
+	bucket := c.QueryParam("bucket")
+	key := c.QueryParam("key")
+	s3Ctrl, statusCode, err := bh.readableZip(c, bucket, key)
+	if err != nil {
+		log.Error(err.Error())
+		return c.JSON(statusCode, err.Error())
+	}
+	httpCode, err := bh.CheckUserS3Permission(c, bucket, destination, []string{"write"})
+	if err != nil {
+		errMsg := fmt.Errorf("error while checking for user permission: %s", err.Error())
+		log.Error(errMsg.Error())
+		return c.JSON(httpCode, errMsg.Error())
+	}
+
+	zr, ra, err := s3Ctrl.openZip(bucket, key)
+	if err != nil {
+		return zipError(c, key, err)
+	}
+	var selected []*zip.File
+	var total uint64
+	results := []zipExtractResult{}
+	for _, name := range body.Entries {
+		matched := false
+		for _, f := range zr.File {
+			if strings.HasSuffix(f.Name, "/") {
+				continue
+			}
+			if f.Name == name || (strings.HasSuffix(name, "/") && strings.HasPrefix(f.Name, name)) {
+				selected = append(selected, f)
+				total += f.UncompressedSize64
+				matched = true
+			}
+		}
+		if !matched {
+			results = append(results, zipExtractResult{Entry: name, Status: http.StatusNotFound, Error: fmt.Sprintf("zip %s has no entry %s", key, name)})
+		}
+	}
+	if len(selected) > maxExtractEntries {
+		errMsg := fmt.Errorf("at most %d entries can be extracted at once, %d were selected", maxExtractEntries, len(selected))
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusRequestEntityTooLarge, errMsg.Error())
+	}
+	limit := uint64(bh.Config.DefaultZipDownloadSizeLimit) * 1024 * 1024 * 1024
+	if total > limit {
+		errMsg := fmt.Errorf("the selected entries are larger than %v GB once extracted", bh.Config.DefaultZipDownloadSizeLimit)
+		log.Error(errMsg.Error())
+		return c.JSON(http.StatusRequestEntityTooLarge, errMsg.Error())
+	}
+
+	extracted := 0
+	seen := make(map[string]bool)
+	for _, f := range selected {
+		if seen[f.Name] {
+			continue
+		}
+		seen[f.Name] = true
+		result := zipExtractResult{Entry: f.Name, Size: f.UncompressedSize64, Status: http.StatusOK}
+		name, err := zipEntryName(f.Name)
+		if err != nil {
+			result.Status = http.StatusUnprocessableEntity
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		result.Key = destination + name
+		if err := s3Ctrl.extractZipEntry(ra, f, bucket, result.Key); err != nil {
+			result.Status = http.StatusInternalServerError
+			if perr, ok := err.(*statusError); ok {
+				result.Status = perr.status
+			}
+			result.Error = err.Error()
+			log.Errorf("error extracting entry %s of zip %s to %s: %s", f.Name, key, result.Key, err.Error())
+		} else {
+			extracted++
+		}
+		results = append(results, result)
+	}
+
+	log.Infof("extracted %d of %d entries of zip %s to %s in bucket %s", extracted, len(results), key, destination, bucket)
+	return c.JSON(http.StatusOK, results)
+}
+
+func (s3Ctrl *S3Controller) extractZipEntry(ra *objectReaderAt, f *zip.File, bucket, key string) error {
+	body, err := s3Ctrl.openZipEntry(ra, f)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return s3Ctrl.UploadS3ObjWithSize(bucket, key, body, int64(f.UncompressedSize64))
+}
@@ -0,0 +1,121 @@
+package blobstore_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// buildZip returns a zip holding the given entries, deflated unless their name ends with .bin.
+func buildZip(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range entries {
+		method := zip.Deflate
+		if strings.HasSuffix(name, ".bin") {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestZipEntries(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	report := strings.Repeat("compressible text ", 1000)
+	srv.PutObject("bkt", "zips/a.zip", buildZip(t, map[string]string{
+		"docs/report.txt": report,
+		"raw.bin":         "stored as is",
+		"empty.txt":       "",
+		"docs/":           "",
+	}))
+
+	var entries []struct {
+		Name           string `json:"name"`
+		Size           uint64 `json:"size"`
+		CompressedSize uint64 `json:"compressed_size"`
+		IsDir          bool   `json:"is_dir"`
+	}
+	decode(t, serve(t, bh.HandleListZipEntries, http.MethodGet, "/object/zip/list?bucket=bkt&key=zips/a.zip", nil), http.StatusOK, &entries)
+	sizes := make(map[string]uint64)
+	for _, e := range entries {
+		sizes[e.Name] = e.Size
+		if e.Name == "docs/report.txt" && e.CompressedSize >= e.Size {
+			t.Fatalf("%s isn't compressed: %+v", e.Name, e)
+		}
+	}
+	if len(entries) != 4 || sizes["docs/report.txt"] != uint64(len(report)) || sizes["raw.bin"] != 12 {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+
+	for name, want := range map[string]string{"docs/report.txt": report, "raw.bin": "stored as is", "empty.txt": ""} {
+		rec := serve(t, bh.HandleGetZipEntry, http.MethodGet, "/object/zip/entry?bucket=bkt&key=zips/a.zip&entry="+name, nil)
+		if rec.Code != http.StatusOK || rec.Body.String() != want {
+			t.Fatalf("entry %s: got %d with %d bytes, want %d bytes", name, rec.Code, rec.Body.Len(), len(want))
+		}
+	}
+	decode(t, serve(t, bh.HandleGetZipEntry, http.MethodGet, "/object/zip/entry?bucket=bkt&key=zips/a.zip&entry=docs/", nil), http.StatusNotFound, nil)
+	decode(t, serve(t, bh.HandleGetZipEntry, http.MethodGet, "/object/zip/entry?bucket=bkt&key=zips/a.zip&entry=missing.txt", nil), http.StatusNotFound, nil)
+
+	srv.PutObject("bkt", "zips/not-a.zip", []byte("plain text"))
+	decode(t, serve(t, bh.HandleListZipEntries, http.MethodGet, "/object/zip/list?bucket=bkt&key=zips/not-a.zip", nil), http.StatusUnprocessableEntity, nil)
+}
+
+func TestExtractZipEntries(t *testing.T) {
+	srv, bh := newTestHandler(t, "bkt")
+	data := buildZip(t, map[string]string{
+		"docs/report.txt": "report",
+		"corrupt.bin":     "original content",
+		"../escape.txt":   "outside",
+	})
+	// the stored bytes no longer match the CRC-32 of the central directory
+	data = bytes.Replace(data, []byte("original content"), []byte("modified content"), 1)
+	srv.PutObject("bkt", "zips/a.zip", data)
+
+	var results []struct {
+		Entry  string `json:"entry"`
+		Key    string `json:"key"`
+		Status int    `json:"status"`
+		Error  string `json:"error"`
+	}
+	body := map[string]interface{}{
+		"entries":     []string{"docs/", "corrupt.bin", "../escape.txt", "missing.txt"},
+		"destination": "out",
+	}
+	decode(t, serve(t, bh.HandleExtractZipEntries, http.MethodPost, "/object/zip/extract?bucket=bkt&key=zips/a.zip", body), http.StatusOK, &results)
+	statuses := make(map[string]int)
+	for _, r := range results {
+		statuses[r.Entry] = r.Status
+	}
+	want := map[string]int{
+		"docs/report.txt": http.StatusOK,
+		"corrupt.bin":     http.StatusInternalServerError,
+		"../escape.txt":   http.StatusUnprocessableEntity,
+		"missing.txt":     http.StatusNotFound,
+	}
+	for entry, status := range want {
+		if statuses[entry] != status {
+			t.Errorf("entry %s: got status %d, want %d in %+v", entry, statuses[entry], status, results)
+		}
+	}
+	if got, _ := srv.GetObject("bkt", "out/docs/report.txt"); string(got) != "report" {
+		t.Fatalf("got %q for the extracted entry", got)
+	}
+	for _, key := range srv.Keys("bkt") {
+		if key != "zips/a.zip" && key != "out/docs/report.txt" {
+			t.Errorf("unexpected object %s", key)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Config is the configuration of the API. It is read from an optional JSON file and every
+// setting can be overridden by the environment variable noted next to it.
+// The authentication variables read by the auth package (INIT_AUTH, KEYCLOAK_PUBLIC_KEYS_URL
+// and POSTGRES_CONN_STRING) are not part of it, they are consumed before main runs.
+type Config struct {
+	Server  Server  `json:"server"`
+	Auth    Auth    `json:"auth"`
+	Storage Storage `json:"storage"`
+	Limits  Limits  `json:"limits"`
+}
+
+type Server struct {
+	Port             int      `json:"port"`               // S3API_SERVICE_PORT
+	LogLevel         string   `json:"log_level"`          // LOG_LEVEL
+	CORSAllowOrigins []string `json:"cors_allow_origins"` // CORS_ALLOW_ORIGINS, comma separated
+}
+
+type Auth struct {
+	Level             int    `json:"level"`               // AUTH_LEVEL, 0 for no fine grained access control, 1 to enable it
+	AdminRole         string `json:"admin_role"`          // AUTH_ADMIN_ROLE
+	WriterRole        string `json:"writer_role"`         // AUTH_WRITER_ROLE
+	ReaderRole        string `json:"reader_role"`         // AUTH_READER_ROLE
+	LimitedWriterRole string `json:"limited_writer_role"` // AUTH_LIMITED_WRITER_ROLE
+	LimitedReaderRole string `json:"limited_reader_role"` // AUTH_LIMITED_READER_ROLE
+}
+
+type Storage struct {
+	EnvJson    string     `json:"env_json"` // ENV_JSON, the accounts file used when neither MinIO nor local storage is enabled
+	S3Mock     bool       `json:"s3_mock"`  // S3_MOCK
+	MinIO      MinIO      `json:"minio"`
+	LocalStore LocalStore `json:"local_store"`
+}
+
+type MinIO struct {
+	Endpoint        string `json:"endpoint"`          // MINIO_S3_ENDPOINT
+	DisableSSL      bool   `json:"disable_ssl"`       // MINIO_S3_DISABLE_SSL
+	ForcePathStyle  bool   `json:"force_path_style"`  // MINIO_S3_FORCE_PATH_STYLE
+	AccessKeyID     string `json:"access_key_id"`     // MINIO_ACCESS_KEY_ID
+	SecretAccessKey string `json:"secret_access_key"` // MINIO_SECRET_ACCESS_KEY
+	Bucket          string `json:"bucket"`            // AWS_S3_BUCKET
+	// Buckets are served along with Bucket, missing ones are created. "*" serves every bucket of the server.
+	Buckets []string `json:"buckets"` // MINIO_BUCKETS, comma separated
+	// FixturesDir holds one directory per bucket, their files are uploaded to buckets missing them
+	FixturesDir string `json:"fixtures_dir"` // MINIO_FIXTURES_DIR
+	// PublicEndpoint is the endpoint presigned URLs point at, the host-mapped port by default
+	PublicEndpoint string `json:"public_endpoint"` // MINIO_PUBLIC_ENDPOINT
+}
+
+type LocalStore struct {
+	Root   string `json:"root"`   // LOCAL_STORE_ROOT
+	URL    string `json:"url"`    // LOCAL_STORE_URL
+	Secret string `json:"secret"` // LOCAL_STORE_SECRET
+}
+
+type Limits struct {
+	TempPrefix                  string `json:"temp_prefix"`                     // TEMP_PREFIX
+	DownloadURLExpirationDays   int    `json:"download_url_expiration_days"`    // DOWNLOAD_URL_EXP_DAYS
+	UploadURLExpirationMinutes  int    `json:"upload_url_expiration_minutes"`   // UPLOAD_URL_EXP_MIN
+	ScriptDownloadSizeLimitGB   int    `json:"script_download_size_limit_gb"`   // SCRIPT_DOWNLOAD_SIZE_LIMIT
+	ZipDownloadSizeLimitGB      int    `json:"zip_download_size_limit_gb"`      // ZIP_DOWNLOAD_SIZE_LIMIT
+	ArchiveJobSizeLimitGB       int    `json:"archive_job_size_limit_gb"`       // ARCHIVE_JOB_SIZE_LIMIT
+	ManifestEntryLimit          int    `json:"manifest_entry_limit"`            // MANIFEST_ENTRY_LIMIT
+	BucketRegionCacheTTLMinutes int    `json:"bucket_region_cache_ttl_minutes"` // BUCKET_REGION_CACHE_TTL_MIN
+
+	// DownloadURLMaxExpiration caps the expiry of the download URLs of a role, such as "1h" or "30m",
+	// roles that aren't listed are capped at DownloadURLExpirationDays
+	DownloadURLMaxExpiration map[string]string `json:"download_url_max_expiration"` // DOWNLOAD_URL_MAX_EXP, as role=duration,role=duration
+
+	// TempMaxAgeHours is how long the scripts and archives written under TempPrefix are kept
+	TempMaxAgeHours int `json:"temp_max_age_hours"` // TEMP_MAX_AGE_HOURS
+	// TempCleanupIntervalMinutes is how often the expired temp objects are removed, 0 disables the background cleanup
+	TempCleanupIntervalMinutes int `json:"temp_cleanup_interval_minutes"` // TEMP_CLEANUP_INTERVAL_MIN
+
+	// PostUploadMaxSizeMB is the largest object a presigned POST policy accepts, S3 refuses POST uploads over 5 GB
+	PostUploadMaxSizeMB int `json:"post_upload_max_size_mb"` // POST_UPLOAD_MAX_SIZE_MB
+	// StaleUploadMaxAgeDays is the age past which /admin/abort_stale_uploads aborts an incomplete multipart upload
+	StaleUploadMaxAgeDays int `json:"stale_upload_max_age_days"` // STALE_UPLOAD_MAX_AGE_DAYS
+}
+
+// Default returns the configuration used for settings that are neither in the file nor in the environment.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port:             5005,
+			LogLevel:         "info",
+			CORSAllowOrigins: []string{"*"},
+		},
+		Auth: Auth{
+			AdminRole:  "s3_admin",
+			WriterRole: "s3_writer",
+			ReaderRole: "s3_reader",
+		},
+		Storage: Storage{
+			EnvJson: "/app/.env.json",
+			MinIO: MinIO{
+				// MinIO serves buckets on paths rather than subdomains
+				ForcePathStyle: true,
+				PublicEndpoint: "http://localhost:9000",
+			},
+		},
+		Limits: Limits{
+			TempPrefix:                  "downloads-temp",
+			DownloadURLExpirationDays:   7,
+			UploadURLExpirationMinutes:  15,
+			ScriptDownloadSizeLimitGB:   50,
+			ZipDownloadSizeLimitGB:      5,
+			ArchiveJobSizeLimitGB:       50,
+			ManifestEntryLimit:          100000,
+			BucketRegionCacheTTLMinutes: 60,
+			TempMaxAgeHours:             168,
+			TempCleanupIntervalMinutes:  60,
+			PostUploadMaxSizeMB:         5120,
+			StaleUploadMaxAgeDays:       7,
+		},
+	}
+}
+
+// Load returns the default configuration overlaid with the JSON file at path, if path is not
+// empty, and then with the environment. Values that can't be parsed are reported together in
+// the returned error, the configuration still holds everything else.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return c, fmt.Errorf("error reading config file: %s", err.Error())
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(c); err != nil {
+			return c, fmt.Errorf("error parsing config file %s: %s", path, err.Error())
+		}
+	}
+
+	var problems []string
+	for _, b := range c.envBindings() {
+		value := strings.TrimSpace(os.Getenv(b.env))
+		if value == "" {
+			continue
+		}
+		if err := b.set(value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", b.env, err.Error()))
+		}
+	}
+	return c, problemsError(problems)
+}
+
+// Validate reports every invalid or missing setting at once.
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		add("server.port must be between 1 and 65535, got %d", c.Server.Port)
+	}
+	if _, err := log.ParseLevel(c.Server.LogLevel); err != nil {
+		add("server.log_level: %s", err.Error())
+	}
+	if len(c.Server.CORSAllowOrigins) == 0 {
+		add("server.cors_allow_origins must list at least one origin, use * to allow all")
+	}
+	for _, origin := range c.Server.CORSAllowOrigins {
+		if origin == "" {
+			add("server.cors_allow_origins contains an empty origin")
+		}
+	}
+
+	switch c.Auth.Level {
+	case 0:
+	case 1:
+		if c.Auth.LimitedWriterRole == "" {
+			add("auth.limited_writer_role is required when auth.level is 1")
+		}
+		if c.Auth.LimitedReaderRole == "" {
+			add("auth.limited_reader_role is required when auth.level is 1")
+		}
+	default:
+		add("auth.level must be 0 or 1, got %d", c.Auth.Level)
+	}
+	if c.Auth.AdminRole == "" || c.Auth.WriterRole == "" || c.Auth.ReaderRole == "" {
+		add("auth.admin_role, auth.writer_role and auth.reader_role can't be empty")
+	}
+
+	switch {
+	case c.Storage.LocalStore.Root != "":
+		if info, err := os.Stat(c.Storage.LocalStore.Root); err != nil {
+			add("storage.local_store.root: %s", err.Error())
+		} else if !info.IsDir() {
+			add("storage.local_store.root %s is not a directory", c.Storage.LocalStore.Root)
+		}
+		if c.Storage.LocalStore.URL != "" {
+			if !isURL(c.Storage.LocalStore.URL) {
+				add("storage.local_store.url `%s` is not a URL", c.Storage.LocalStore.URL)
+			}
+		}
+	case c.Storage.S3Mock:
+		mc := c.Storage.MinIO
+		if !isURL(mc.Endpoint) {
+			add("storage.minio.endpoint `%s` is not a URL", mc.Endpoint)
+		}
+		if mc.AccessKeyID == "" || mc.SecretAccessKey == "" {
+			add("storage.minio.access_key_id and storage.minio.secret_access_key are required when storage.s3_mock is set")
+		}
+		if mc.Bucket == "" && len(mc.Buckets) == 0 && mc.FixturesDir == "" {
+			add("storage.minio.bucket, storage.minio.buckets or storage.minio.fixtures_dir is required when storage.s3_mock is set")
+		}
+		for _, bucket := range mc.Buckets {
+			if bucket == "" {
+				add("storage.minio.buckets contains an empty bucket name")
+			}
+		}
+		if mc.FixturesDir != "" {
+			if info, err := os.Stat(mc.FixturesDir); err != nil {
+				add("storage.minio.fixtures_dir: %s", err.Error())
+			} else if !info.IsDir() {
+				add("storage.minio.fixtures_dir %s is not a directory", mc.FixturesDir)
+			}
+		}
+		if mc.PublicEndpoint != "" && !isURL(mc.PublicEndpoint) {
+			add("storage.minio.public_endpoint `%s` is not a URL", mc.PublicEndpoint)
+		}
+	default:
+		if _, err := os.Stat(c.Storage.EnvJson); err != nil {
+			add("storage.env_json: %s", err.Error())
+		}
+	}
+
+	l := c.Limits
+	if l.TempPrefix == "" || strings.HasPrefix(l.TempPrefix, "/") {
+		add("limits.temp_prefix must be a relative prefix such as downloads-temp, got `%s`", l.TempPrefix)
+	}
+	for _, limit := range []struct {
+		name  string
+		value int
+	}{
+		{"limits.download_url_expiration_days", l.DownloadURLExpirationDays},
+		{"limits.upload_url_expiration_minutes", l.UploadURLExpirationMinutes},
+		{"limits.script_download_size_limit_gb", l.ScriptDownloadSizeLimitGB},
+		{"limits.zip_download_size_limit_gb", l.ZipDownloadSizeLimitGB},
+		{"limits.archive_job_size_limit_gb", l.ArchiveJobSizeLimitGB},
+		{"limits.manifest_entry_limit", l.ManifestEntryLimit},
+		{"limits.temp_max_age_hours", l.TempMaxAgeHours},
+		{"limits.post_upload_max_size_mb", l.PostUploadMaxSizeMB},
+		{"limits.stale_upload_max_age_days", l.StaleUploadMaxAgeDays},
+	} {
+		if limit.value <= 0 {
+			add("%s must be greater than 0, got %d", limit.name, limit.value)
+		}
+	}
+	// presigned URLs signed with SigV4 can't be valid for more than 7 days
+	if l.DownloadURLExpirationDays > 7 {
+		add("limits.download_url_expiration_days can't be more than 7, got %d", l.DownloadURLExpirationDays)
+	}
+	for role, value := range l.DownloadURLMaxExpiration {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			add("limits.download_url_max_expiration of role %s: %s", role, err.Error())
+		} else if d <= 0 || d > 7*24*time.Hour {
+			add("limits.download_url_max_expiration of role %s must be between 1s and 168h, got %s", role, value)
+		}
+	}
+	if l.BucketRegionCacheTTLMinutes < 0 {
+		add("limits.bucket_region_cache_ttl_minutes can't be negative, got %d", l.BucketRegionCacheTTLMinutes)
+	}
+	if l.TempCleanupIntervalMinutes < 0 {
+		add("limits.temp_cleanup_interval_minutes can't be negative, got %d", l.TempCleanupIntervalMinutes)
+	}
+	if l.PostUploadMaxSizeMB > 5120 {
+		add("limits.post_upload_max_size_mb can't be more than 5120, got %d", l.PostUploadMaxSizeMB)
+	}
+
+	return problemsError(problems)
+}
+
+// Redacted returns a copy of the configuration with the secrets masked, for printing.
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.Server.CORSAllowOrigins = append([]string(nil), c.Server.CORSAllowOrigins...)
+	r.Storage.MinIO.SecretAccessKey = redact(c.Storage.MinIO.SecretAccessKey)
+	r.Storage.LocalStore.Secret = redact(c.Storage.LocalStore.Secret)
+	return &r
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "********"
+}
+
+// envBinding ties an environment variable to the setting it overrides.
+type envBinding struct {
+	env string
+	set func(value string) error
+}
+
+func (c *Config) envBindings() []envBinding {
+	return []envBinding{
+		{"S3API_SERVICE_PORT", intSetter(&c.Server.Port)},
+		{"LOG_LEVEL", stringSetter(&c.Server.LogLevel)},
+		{"CORS_ALLOW_ORIGINS", listSetter(&c.Server.CORSAllowOrigins)},
+		{"AUTH_LEVEL", intSetter(&c.Auth.Level)},
+		{"AUTH_ADMIN_ROLE", stringSetter(&c.Auth.AdminRole)},
+		{"AUTH_WRITER_ROLE", stringSetter(&c.Auth.WriterRole)},
+		{"AUTH_READER_ROLE", stringSetter(&c.Auth.ReaderRole)},
+		{"AUTH_LIMITED_WRITER_ROLE", stringSetter(&c.Auth.LimitedWriterRole)},
+		{"AUTH_LIMITED_READER_ROLE", stringSetter(&c.Auth.LimitedReaderRole)},
+		{"ENV_JSON", stringSetter(&c.Storage.EnvJson)},
+		{"S3_MOCK", boolSetter(&c.Storage.S3Mock)},
+		{"MINIO_S3_ENDPOINT", stringSetter(&c.Storage.MinIO.Endpoint)},
+		{"MINIO_S3_DISABLE_SSL", boolSetter(&c.Storage.MinIO.DisableSSL)},
+		{"MINIO_S3_FORCE_PATH_STYLE", boolSetter(&c.Storage.MinIO.ForcePathStyle)},
+		{"MINIO_ACCESS_KEY_ID", stringSetter(&c.Storage.MinIO.AccessKeyID)},
+		{"MINIO_SECRET_ACCESS_KEY", stringSetter(&c.Storage.MinIO.SecretAccessKey)},
+		{"AWS_S3_BUCKET", stringSetter(&c.Storage.MinIO.Bucket)},
+		{"MINIO_BUCKETS", listSetter(&c.Storage.MinIO.Buckets)},
+		{"MINIO_FIXTURES_DIR", stringSetter(&c.Storage.MinIO.FixturesDir)},
+		{"MINIO_PUBLIC_ENDPOINT", stringSetter(&c.Storage.MinIO.PublicEndpoint)},
+		{"LOCAL_STORE_ROOT", stringSetter(&c.Storage.LocalStore.Root)},
+		{"LOCAL_STORE_URL", stringSetter(&c.Storage.LocalStore.URL)},
+		{"LOCAL_STORE_SECRET", stringSetter(&c.Storage.LocalStore.Secret)},
+		{"TEMP_PREFIX", stringSetter(&c.Limits.TempPrefix)},
+		{"DOWNLOAD_URL_EXP_DAYS", intSetter(&c.Limits.DownloadURLExpirationDays)},
+		{"DOWNLOAD_URL_MAX_EXP", mapSetter(&c.Limits.DownloadURLMaxExpiration)},
+		{"UPLOAD_URL_EXP_MIN", intSetter(&c.Limits.UploadURLExpirationMinutes)},
+		{"SCRIPT_DOWNLOAD_SIZE_LIMIT", intSetter(&c.Limits.ScriptDownloadSizeLimitGB)},
+		{"ZIP_DOWNLOAD_SIZE_LIMIT", intSetter(&c.Limits.ZipDownloadSizeLimitGB)},
+		{"ARCHIVE_JOB_SIZE_LIMIT", intSetter(&c.Limits.ArchiveJobSizeLimitGB)},
+		{"MANIFEST_ENTRY_LIMIT", intSetter(&c.Limits.ManifestEntryLimit)},
+		{"BUCKET_REGION_CACHE_TTL_MIN", intSetter(&c.Limits.BucketRegionCacheTTLMinutes)},
+		{"TEMP_MAX_AGE_HOURS", intSetter(&c.Limits.TempMaxAgeHours)},
+		{"TEMP_CLEANUP_INTERVAL_MIN", intSetter(&c.Limits.TempCleanupIntervalMinutes)},
+		{"POST_UPLOAD_MAX_SIZE_MB", intSetter(&c.Limits.PostUploadMaxSizeMB)},
+		{"STALE_UPLOAD_MAX_AGE_DAYS", intSetter(&c.Limits.StaleUploadMaxAgeDays)},
+	}
+}
+
+func stringSetter(p *string) func(string) error {
+	return func(v string) error {
+		*p = v
+		return nil
+	}
+}
+
+func intSetter(p *int) func(string) error {
+	return func(v string) error {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("`%s` is not an integer", v)
+		}
+		*p = i
+		return nil
+	}
+}
+
+func boolSetter(p *bool) func(string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("`%s` is not a boolean, use true/false or 1/0", v)
+		}
+		*p = b
+		return nil
+	}
+}
+
+func listSetter(p *[]string) func(string) error {
+	return func(v string) error {
+		var list []string
+		for _, item := range strings.Split(v, ",") {
+			list = append(list, strings.TrimSpace(item))
+		}
+		*p = list
+		return nil
+	}
+}
+
+func mapSetter(p *map[string]string) func(string) error {
+	return func(v string) error {
+		m := make(map[string]string)
+		for _, item := range strings.Split(v, ",") {
+			key, value, ok := strings.Cut(item, "=")
+			if !ok || strings.TrimSpace(key) == "" {
+				return fmt.Errorf("`%s` is not a key=value pair", item)
+			}
+			m[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+		*p = m
+		return nil
+	}
+}
+
+func isURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+func problemsError(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+}
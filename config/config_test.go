@@ -0,0 +1,48 @@
+package config
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestExamplesLoad checks that the example config file and env file, which are copied to start a
+// deployment, hold values Load accepts.
+func TestExamplesLoad(t *testing.T) {
+	f, err := os.Open("../.example.env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		name, value, ok := strings.Cut(line, "=")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+		value = strings.Trim(strings.TrimSpace(value), `'"`)
+		t.Setenv(name, value)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load("../.example.config.json")
+	if err != nil {
+		t.Fatalf("the examples don't load: %s", err.Error())
+	}
+	if !c.Storage.MinIO.DisableSSL || !c.Storage.MinIO.ForcePathStyle {
+		t.Fatalf("got disable_ssl %t and force_path_style %t, want both set", c.Storage.MinIO.DisableSSL, c.Storage.MinIO.ForcePathStyle)
+	}
+}
+
+func TestMinIODefaultsToPathStyle(t *testing.T) {
+	if !Default().Storage.MinIO.ForcePathStyle {
+		t.Fatal("MinIO must default to path style requests")
+	}
+}
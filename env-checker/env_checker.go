@@ -5,7 +5,8 @@ import (
 	"os"
 )
 
-var REQUIRED_ENV_VAR = []string{"S3API_SERVICE_PORT", "KEYCLOAK_PUBLIC_KEYS_URL"}
+// REQUIRED_ENV_VAR lists the variables without a default, the other settings are described by config.Config
+var REQUIRED_ENV_VAR = []string{"KEYCLOAK_PUBLIC_KEYS_URL"}
 
 func CheckEnvVariablesExist(envVars []string) error {
 	var missingVars []string
@@ -2,15 +2,17 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/Dewberry/s3api/auth"
 	"github.com/Dewberry/s3api/blobstore"
+	"github.com/Dewberry/s3api/config"
 	envcheck "github.com/Dewberry/s3api/env-checker"
 
 	"github.com/labstack/echo/v4"
@@ -19,15 +21,22 @@ import (
 )
 
 func main() {
+	// settings come from an optional JSON file, every one of them can be overridden by its env variable
+	cfg, loadErr := config.Load(os.Getenv("S3API_CONFIG_FILE"))
+	if len(os.Args) > 1 && os.Args[1] == "check-config" {
+		os.Exit(checkConfig(cfg, loadErr))
+	}
+	if loadErr != nil {
+		log.Fatal(loadErr)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
 	if err := envcheck.CheckEnvVariablesExist(envcheck.REQUIRED_ENV_VAR); err != nil {
 		log.Fatal(err)
 	}
 	log.SetFormatter(&log.JSONFormatter{})
-	logLevel := os.Getenv("LOG_LEVEL")
-	if logLevel == "" {
-		logLevel = "info"
-	}
-	level, err := log.ParseLevel(logLevel)
+	level, err := log.ParseLevel(cfg.Server.LogLevel)
 	if err != nil {
 		log.WithError(err).Error("Invalid log level")
 		level = log.InfoLevel
@@ -35,48 +44,31 @@ func main() {
 	log.SetLevel(level)
 	log.SetReportCaller(true)
 	log.Infof("level level set to: %s", level)
-	admin := []string{"s3_admin"}
-	allUsers := []string{"s3_admin", "s3_reader", "s3_writer"}
-	writers := []string{"s3_admin", "s3_writer"}
-
-	var authLvl int
-	authLvlString := os.Getenv("AUTH_LEVEL")
-	if authLvlString == "" {
-		authLvl = 0
+	admin := []string{cfg.Auth.AdminRole}
+	allUsers := []string{cfg.Auth.AdminRole, cfg.Auth.ReaderRole, cfg.Auth.WriterRole}
+	writers := []string{cfg.Auth.AdminRole, cfg.Auth.WriterRole}
+
+	if cfg.Auth.Level == 0 {
 		log.Warn("Fine Grained Access Control disabled")
 	} else {
-		authLvl, err = strconv.Atoi(authLvlString)
-		if err != nil {
-			log.Fatalf("could not convert AUTH_LEVEL env variable to integer: %v", err)
-		}
-		if authLvl == 1 {
-			s3LimitWriterRoleName, ok := os.LookupEnv("AUTH_LIMITED_WRITER_ROLE")
-			if !ok {
-				log.Fatal("AUTH_S3_LIMITED_WRITER env variable not set")
-			}
-			s3LimitedReaderRoleName, ok := os.LookupEnv("AUTH_LIMITED_READER_ROLE")
-			if !ok {
-				log.Fatal("AUTH_LIMITED_READER_ROLE env variable not set")
-			}
-			allUsers = append(allUsers, s3LimitWriterRoleName, s3LimitedReaderRoleName)
-			writers = append(writers, s3LimitWriterRoleName)
-		}
-
+		allUsers = append(allUsers, cfg.Auth.LimitedWriterRole, cfg.Auth.LimitedReaderRole)
+		writers = append(writers, cfg.Auth.LimitedWriterRole)
 	}
 
-	envJson := "/app/.env.json"
-
-	bh, err := blobstore.NewBlobHandler(envJson, authLvl)
+	bh, err := blobstore.NewBlobHandler(cfg)
 	if err != nil {
 		log.Fatalf("error initializing a new blobhandler: %v", err)
 	}
 
+	bh.StartTempJanitor()
+
 	e := echo.New()
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowCredentials: true,
-		AllowOrigins:     []string{"*"},
+		AllowOrigins:     cfg.Server.CORSAllowOrigins,
+		ExposeHeaders:    []string{"ETag"},
 	}))
 
 	e.GET("/ping_with_auth", auth.Authorize(bh.PingWithAuth, allUsers...))
@@ -85,26 +77,40 @@ func main() {
 	// object content
 	e.GET("/object/metadata", auth.Authorize(bh.HandleGetMetaData, allUsers...))
 	e.GET("/object/content", auth.Authorize(bh.HandleObjectContents, allUsers...))
+	e.HEAD("/object/content", auth.Authorize(bh.HandleObjectContents, allUsers...))
+	e.GET("/object/preview", auth.Authorize(bh.HandleGetObjectPreview, allUsers...))
+	e.GET("/object/zip/list", auth.Authorize(bh.HandleListZipEntries, allUsers...))
+	e.GET("/object/zip/entry", auth.Authorize(bh.HandleGetZipEntry, allUsers...))
+	e.POST("/object/zip/extract", auth.Authorize(bh.HandleExtractZipEntries, writers...))
 	e.PUT("/object/move", auth.Authorize(bh.HandleMoveObject, admin...))
 	e.GET("/object/download", auth.Authorize(bh.HandleGetPresignedDownloadURL, allUsers...))
 	e.POST("/object/upload", auth.Authorize(bh.HandleMultipartUpload, writers...)) //deprecated by presigned upload URL
 	e.DELETE("/object/delete", auth.Authorize(bh.HandleDeleteObject, writers...))
 	e.GET("/object/exists", auth.Authorize(bh.HandleGetObjExist, allUsers...))
 	e.GET("/object/presigned_upload", auth.Authorize(bh.HandleGetPresignedUploadURL, writers...))
+	e.GET("/object/presigned_post", auth.Authorize(bh.HandleGetPresignedPost, writers...))
 	e.GET("/object/multipart_upload_id", auth.Authorize(bh.HandleGetMultipartUploadID, writers...))
+	e.GET("/object/presigned_multipart_upload", auth.Authorize(bh.HandleGetPresignedMultipartUpload, writers...))
+	e.GET("/object/presigned_multipart_upload/refresh", auth.Authorize(bh.HandleRefreshPresignedMultipartUpload, writers...))
+	e.GET("/object/multipart_upload_parts", auth.Authorize(bh.HandleListMultipartUploadParts, writers...))
 	e.POST("/object/complete_multipart_upload", auth.Authorize(bh.HandleCompleteMultipartUpload, writers...))
 	e.POST("object/abort_multipart_upload", auth.Authorize(bh.HandleAbortMultipartUpload, writers...))
 	// prefix
 	e.GET("/prefix/list", auth.Authorize(bh.HandleListByPrefix, allUsers...))
 	e.GET("/prefix/list_with_details", auth.Authorize(bh.HandleListByPrefixWithDetail, allUsers...))
-	// e.GET("/prefix/download", auth.Authorize(bh.HandleGetPresignedURLMultiObj, allUsers...))
+	e.GET("/prefix/download", auth.Authorize(bh.HandleGetPrefixArchive, allUsers...))
 	e.GET("/prefix/download/script", auth.Authorize(bh.HandleGenerateDownloadScript, allUsers...))
+	e.GET("/prefix/manifest", auth.Authorize(bh.HandleGetPrefixManifest, allUsers...))
+	e.POST("/prefix/download/archive", auth.Authorize(bh.HandleCreateArchiveJob, allUsers...))
+	e.GET("/prefix/download/archive/status", auth.Authorize(bh.HandleGetArchiveJob, allUsers...))
 	e.PUT("/prefix/move", auth.Authorize(bh.HandleMovePrefix, admin...))
 	e.DELETE("/prefix/delete", auth.Authorize(bh.HandleDeletePrefix, writers...))
 	e.GET("/prefix/size", auth.Authorize(bh.HandleGetSize, allUsers...))
+	e.GET("/prefix/multipart_uploads", auth.Authorize(bh.HandleListMultipartUploads, writers...))
 
 	// universal
 	e.DELETE("/delete_keys", auth.Authorize(bh.HandleDeleteObjectsByList, writers...))
+	e.POST("/download_keys", auth.Authorize(bh.HandleGetPresignedDownloadURLs, allUsers...))
 
 	// multi-bucket
 	e.GET("/list_buckets", auth.Authorize(bh.HandleListBuckets, allUsers...))
@@ -112,22 +118,44 @@ func main() {
 	// e.PUT("/object/cross-bucket/copy", auth.Authorize(bh., writers...))
 	// e.PUT("/prefix/cross-bucket/copy", auth.Authorize(bh., writers...))
 
+	// local storage backend, the presigned URLs are authorized by their signature
+	if cfg.Storage.LocalStore.Root != "" {
+		e.Any(blobstore.LocalStoreRoutePrefix+"/*", bh.HandleLocalStore)
+	}
+
 	//auth
 	e.GET("/check_user_permission", auth.Authorize(bh.HandleCheckS3UserPermission, allUsers...))
 
+	// admin
+	e.POST("/admin/reload", auth.Authorize(bh.HandleReload, admin...))
+	e.POST("/admin/clean_temp", auth.Authorize(bh.HandleCleanTemp, admin...))
+	e.POST("/admin/abort_stale_uploads", auth.Authorize(bh.HandleAbortStaleUploads, admin...))
+
 	// Start server
 	go func() {
-		log.Info("server starting on port: ", os.Getenv("S3API_SERVICE_PORT"))
-		if err := e.Start(":" + os.Getenv("S3API_SERVICE_PORT")); err != nil && err != http.ErrServerClosed {
+		log.Info("server starting on port: ", cfg.Server.Port)
+		if err := e.Start(fmt.Sprintf(":%d", cfg.Server.Port)); err != nil && err != http.ErrServerClosed {
 			log.Error("server error : ", err.Error())
 			log.Fatal("shutting down the server")
 		}
 	}()
 
+	// Reload the accounts and bucket allow list from .env.json on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			log.Info("received SIGHUP, reloading configuration")
+			if err := bh.Reload(); err != nil {
+				log.Errorf("error reloading configuration, the current configuration is kept: %s", err.Error())
+			}
+		}
+	}()
+
 	// Wait for interrupt signal to gracefully shutdown the server.
 	// Use a buffered channel to avoid missing signals as recommended for signal.Notify
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 	<-quit
 	log.Info("gracefully shutting down the server")
 
@@ -150,3 +178,39 @@ func main() {
 	}
 	log.Info("server gracefully shutdown")
 }
+
+// checkConfig prints the effective configuration with its secrets redacted, followed by every
+// problem found in it, and returns the exit code of the check-config command.
+func checkConfig(cfg *config.Config, loadErr error) int {
+	out, err := json.MarshalIndent(cfg.Redacted(), "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	var problems []error
+	if loadErr != nil {
+		problems = append(problems, loadErr)
+	}
+	if err := cfg.Validate(); err != nil {
+		problems = append(problems, err)
+	}
+	// the accounts file is only read when neither MinIO nor local storage is enabled
+	if cfg.Storage.LocalStore.Root == "" && !cfg.Storage.S3Mock {
+		if err := blobstore.ValidateEnvJSON(cfg.Storage.EnvJson); err != nil {
+			problems = append(problems, fmt.Errorf("invalid %s: %s", cfg.Storage.EnvJson, err.Error()))
+		}
+	}
+	if err := envcheck.CheckEnvVariablesExist(envcheck.REQUIRED_ENV_VAR); err != nil {
+		problems = append(problems, err)
+	}
+	if len(problems) == 0 {
+		fmt.Fprintln(os.Stderr, "configuration is valid")
+		return 0
+	}
+	for _, p := range problems {
+		fmt.Fprintln(os.Stderr, p.Error())
+	}
+	return 1
+}
@@ -0,0 +1,825 @@
+// Package s3test serves the subset of the S3 REST API used by s3api from an in-process
+// httptest.Server, so that a BlobHandler can be built against it and the blobstore
+// handlers can be exercised with plain go test, without docker-compose.
+//
+// Only path-style requests are understood and request signatures are not verified.
+package s3test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dewberry/s3api/blobstore"
+	"github.com/Dewberry/s3api/config"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+const timeFormat = "2006-01-02T15:04:05.000Z"
+
+type object struct {
+	data         []byte
+	contentType  string
+	etag         string
+	lastModified time.Time
+	metadata     map[string]string
+}
+
+type bucket struct {
+	created time.Time
+	objects map[string]*object
+}
+
+type upload struct {
+	bucket      string
+	key         string
+	initiated   time.Time
+	contentType string
+	metadata    map[string]string
+	parts       map[int]*object
+}
+
+// Server is an in-memory S3 stand-in. The zero value is not usable, use NewServer.
+type Server struct {
+	*httptest.Server
+	// Region is reported by GetBucketLocation for every bucket
+	Region string
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	uploads map[string]*upload
+	nextID  int
+}
+
+// NewServer starts a stand-in serving the given (empty) buckets. Call Close when done.
+func NewServer(buckets ...string) *Server {
+	s := &Server{
+		Region:  "us-east-1",
+		buckets: make(map[string]*bucket),
+		uploads: make(map[string]*upload),
+	}
+	for _, b := range buckets {
+		s.CreateBucket(b)
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// CreateBucket adds an empty bucket, it is a no-op if the bucket already exists.
+func (s *Server) CreateBucket(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[name]; !ok {
+		s.buckets[name] = &bucket{created: time.Now().UTC(), objects: make(map[string]*object)}
+	}
+}
+
+// PutObject seeds an object, creating the bucket if needed.
+func (s *Server) PutObject(bucketName, key string, data []byte) {
+	s.CreateBucket(bucketName)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buckets[bucketName].objects[key] = newObject(data, "", nil)
+}
+
+// GetObject returns the content of an object and whether it exists.
+func (s *Server) GetObject(bucketName, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return nil, false
+	}
+	obj, ok := b.objects[key]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), obj.data...), true
+}
+
+// Keys returns the sorted keys of a bucket.
+func (s *Server) Keys(bucketName string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	if b, ok := s.buckets[bucketName]; ok {
+		for k := range b.objects {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Session returns an AWS session that talks to the stand-in.
+func (s *Server) Session() *session.Session {
+	return session.Must(session.NewSession(&aws.Config{
+		Endpoint:         aws.String(s.URL),
+		Region:           aws.String(s.Region),
+		Credentials:      credentials.NewStaticCredentials("s3test", "s3test", ""),
+		S3ForcePathStyle: aws.Bool(true),
+		DisableSSL:       aws.Bool(true),
+	}))
+}
+
+// Store returns an ObjectStore backed by the stand-in.
+func (s *Server) Store() *blobstore.S3ObjectStore {
+	return blobstore.NewS3ObjectStore(s.Session())
+}
+
+// BlobHandler returns a handler serving every bucket of the stand-in with authorization disabled.
+func (s *Server) BlobHandler() *blobstore.BlobHandler {
+	s.mu.Lock()
+	var names []string
+	for name := range s.buckets {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+
+	return &blobstore.BlobHandler{
+		S3Controllers: []blobstore.S3Controller{{Store: s.Store(), Buckets: names}},
+		Config:        blobstore.NewConfig(config.Default()),
+	}
+}
+
+func newObject(data []byte, contentType string, metadata map[string]string) *object {
+	if contentType == "" {
+		contentType = "binary/octet-stream"
+	}
+	sum := md5.Sum(data)
+	return &object{
+		data:         data,
+		contentType:  contentType,
+		etag:         fmt.Sprintf("\"%s\"", hex.EncodeToString(sum[:])),
+		lastModified: time.Now().UTC().Truncate(time.Second),
+		metadata:     metadata,
+	}
+}
+
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Resource string
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	writeXML(w, status, errorResponse{Code: code, Message: message, Resource: r.URL.Path})
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+// ServeHTTP dispatches a path-style S3 request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucketName, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	q := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if bucketName == "" {
+		if r.Method == http.MethodGet {
+			s.listBuckets(w)
+			return
+		}
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported request")
+		return
+	}
+
+	if r.Method == http.MethodPut && key == "" {
+		if _, ok := s.buckets[bucketName]; !ok {
+			s.buckets[bucketName] = &bucket{created: time.Now().UTC(), objects: make(map[string]*object)}
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "the specified bucket does not exist")
+		return
+	}
+
+	if key == "" {
+		switch {
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && q.Has("location"):
+			s.getBucketLocation(w)
+		case r.Method == http.MethodGet && q.Get("list-type") == "2":
+			s.listObjectsV2(w, bucketName, b, q)
+		case r.Method == http.MethodGet && q.Has("uploads"):
+			s.listMultipartUploads(w, bucketName, q)
+		case r.Method == http.MethodPost && q.Has("delete"):
+			s.deleteObjects(w, r, b)
+		case r.Method == http.MethodPost:
+			s.postObject(w, r, b)
+		default:
+			writeError(w, r, http.StatusNotImplemented, "NotImplemented", "unsupported bucket request")
+		}
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodHead:
+		s.headObject(w, r, b, key)
+	case r.Method == http.MethodGet && q.Has("uploadId"):
+		s.listParts(w, r, bucketName, key, q)
+	case r.Method == http.MethodGet:
+		s.getObject(w, r, b, key)
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		s.uploadPart(w, r, bucketName, key, q)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.copyObject(w, r, b, key)
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, b, key)
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		s.createMultipartUpload(w, r, bucketName, key)
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		s.completeMultipartUpload(w, r, bucketName, b, key, q.Get("uploadId"))
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		s.abortMultipartUpload(w, r, bucketName, key, q.Get("uploadId"))
+	case r.Method == http.MethodDelete:
+		delete(b.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "unsupported object request")
+	}
+}
+
+func (s *Server) listBuckets(w http.ResponseWriter) {
+	type bucketEntry struct {
+		Name         string
+		CreationDate string
+	}
+	type listAllMyBucketsResult struct {
+		XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+		OwnerID string        `xml:"Owner>ID"`
+		Buckets []bucketEntry `xml:"Buckets>Bucket"`
+	}
+	result := listAllMyBucketsResult{OwnerID: "s3test"}
+	for name, b := range s.buckets {
+		result.Buckets = append(result.Buckets, bucketEntry{Name: name, CreationDate: b.created.Format(timeFormat)})
+	}
+	sort.Slice(result.Buckets, func(i, j int) bool { return result.Buckets[i].Name < result.Buckets[j].Name })
+	writeXML(w, http.StatusOK, result)
+}
+
+func (s *Server) getBucketLocation(w http.ResponseWriter) {
+	type locationConstraint struct {
+		XMLName xml.Name `xml:"LocationConstraint"`
+		Region  string   `xml:",chardata"`
+	}
+	// like AWS, buckets in us-east-1 report an empty location
+	region := s.Region
+	if region == "us-east-1" {
+		region = ""
+	}
+	writeXML(w, http.StatusOK, locationConstraint{Region: region})
+}
+
+func (s *Server) listObjectsV2(w http.ResponseWriter, bucketName string, b *bucket, q url.Values) {
+	type contents struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int64
+		StorageClass string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	type listBucketResult struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		Delimiter             string `xml:",omitempty"`
+		MaxKeys               int
+		KeyCount              int
+		IsTruncated           bool
+		ContinuationToken     string `xml:",omitempty"`
+		NextContinuationToken string `xml:",omitempty"`
+		StartAfter            string `xml:",omitempty"`
+		Contents              []contents
+		CommonPrefixes        []commonPrefix
+	}
+
+	prefix := q.Get("prefix")
+	delimiter := q.Get("delimiter")
+	maxKeys := 1000
+	if v, err := strconv.Atoi(q.Get("max-keys")); err == nil && v >= 0 && v < maxKeys {
+		maxKeys = v
+	}
+	startAfter := q.Get("start-after")
+	if token := q.Get("continuation-token"); token != "" {
+		startAfter = token
+	}
+
+	var keys []string
+	for k := range b.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	result := listBucketResult{
+		Name:              bucketName,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		MaxKeys:           maxKeys,
+		ContinuationToken: q.Get("continuation-token"),
+		StartAfter:        q.Get("start-after"),
+	}
+	lastName := ""
+	for _, k := range keys {
+		// the continuation token is the last key or common prefix returned
+		name := k
+		isPrefix := false
+		if delimiter != "" {
+			if idx := strings.Index(k[len(prefix):], delimiter); idx >= 0 {
+				name = k[:len(prefix)+idx+len(delimiter)]
+				isPrefix = true
+			}
+		}
+		if name <= startAfter || name == lastName {
+			continue
+		}
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = lastName
+			break
+		}
+		lastName = name
+		result.KeyCount++
+		if isPrefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: name})
+			continue
+		}
+		obj := b.objects[k]
+		result.Contents = append(result.Contents, contents{
+			Key:          k,
+			LastModified: obj.lastModified.Format(timeFormat),
+			ETag:         obj.etag,
+			Size:         int64(len(obj.data)),
+			StorageClass: "STANDARD",
+		})
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+func writeObjectHeaders(w http.ResponseWriter, r *http.Request, obj *object) {
+	w.Header().Set("Content-Type", obj.contentType)
+	w.Header().Set("ETag", obj.etag)
+	w.Header().Set("Last-Modified", obj.lastModified.Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	for k, v := range obj.metadata {
+		w.Header().Set("X-Amz-Meta-"+k, v)
+	}
+	// presigned URLs can override response headers, the same as S3
+	query := r.URL.Query()
+	if disposition := query.Get("response-content-disposition"); disposition != "" {
+		w.Header().Set("Content-Disposition", disposition)
+	}
+	if contentType := query.Get("response-content-type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+}
+
+// headObject and getObject rely on http.ServeContent for Range and the conditional headers,
+// which answers 206, 304, 412 and 416 like S3 albeit with plain text error bodies.
+func (s *Server) headObject(w http.ResponseWriter, r *http.Request, b *bucket, key string) {
+	obj, ok := b.objects[key]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NotFound", "not found")
+		return
+	}
+	writeObjectHeaders(w, r, obj)
+	http.ServeContent(w, r, "", obj.lastModified, bytes.NewReader(obj.data))
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, b *bucket, key string) {
+	obj, ok := b.objects[key]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+		return
+	}
+	writeObjectHeaders(w, r, obj)
+	http.ServeContent(w, r, "", obj.lastModified, bytes.NewReader(obj.data))
+}
+
+func requestMetadata(r *http.Request) map[string]string {
+	metadata := make(map[string]string)
+	for k, v := range r.Header {
+		if strings.HasPrefix(k, "X-Amz-Meta-") && len(v) > 0 {
+			metadata[strings.TrimPrefix(k, "X-Amz-Meta-")] = v[0]
+		}
+	}
+	return metadata
+}
+
+// checkDigest verifies data against the Content-MD5 and x-amz-checksum-sha256 headers of the
+// request, and writes a BadDigest error when either doesn't match.
+func checkDigest(w http.ResponseWriter, r *http.Request, data []byte) bool {
+	if want := r.Header.Get("Content-MD5"); want != "" {
+		sum := md5.Sum(data)
+		if base64.StdEncoding.EncodeToString(sum[:]) != want {
+			writeError(w, r, http.StatusBadRequest, "BadDigest", "the Content-MD5 you specified did not match what we received")
+			return false
+		}
+	}
+	if want := r.Header.Get("X-Amz-Checksum-Sha256"); want != "" {
+		sum := sha256.Sum256(data)
+		if base64.StdEncoding.EncodeToString(sum[:]) != want {
+			writeError(w, r, http.StatusBadRequest, "BadDigest", "the SHA256 you specified did not match what we received")
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, b *bucket, key string) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if !checkDigest(w, r, data) {
+		return
+	}
+	obj := newObject(data, r.Header.Get("Content-Type"), requestMetadata(r))
+	b.objects[key] = obj
+	w.Header().Set("ETag", obj.etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+// postObject stores the file of a presigned POST. Like signatures, the policy is not checked.
+func (s *Server) postObject(w http.ResponseWriter, r *http.Request, b *bucket) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeError(w, r, http.StatusBadRequest, "MalformedPOSTRequest", err.Error())
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "POST requires exactly one file upload per request")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	metadata := make(map[string]string)
+	var contentType string
+	for name, values := range r.MultipartForm.Value {
+		lower := strings.ToLower(name)
+		switch {
+		case lower == "content-type":
+			contentType = values[0]
+		case strings.HasPrefix(lower, "x-amz-meta-"):
+			metadata[http.CanonicalHeaderKey(strings.TrimPrefix(lower, "x-amz-meta-"))] = values[0]
+		}
+	}
+	key := strings.ReplaceAll(r.FormValue("key"), "${filename}", header.Filename)
+	obj := newObject(data, contentType, metadata)
+	b.objects[key] = obj
+	w.Header().Set("ETag", obj.etag)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, b *bucket, key string) {
+	source := strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/")
+	if unescaped, err := url.PathUnescape(source); err == nil {
+		source = unescaped
+	}
+	srcBucketName, srcKey, _ := strings.Cut(source, "/")
+	srcBucket, ok := s.buckets[srcBucketName]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "the source bucket does not exist")
+		return
+	}
+	src, ok := srcBucket.objects[srcKey]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "the source key does not exist")
+		return
+	}
+
+	contentType, metadata := src.contentType, src.metadata
+	if strings.EqualFold(r.Header.Get("X-Amz-Metadata-Directive"), "REPLACE") {
+		contentType, metadata = r.Header.Get("Content-Type"), requestMetadata(r)
+	}
+	obj := newObject(append([]byte(nil), src.data...), contentType, metadata)
+	b.objects[key] = obj
+
+	type copyObjectResult struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		ETag         string
+		LastModified string
+	}
+	writeXML(w, http.StatusOK, copyObjectResult{ETag: obj.etag, LastModified: obj.lastModified.Format(timeFormat)})
+}
+
+func (s *Server) deleteObjects(w http.ResponseWriter, r *http.Request, b *bucket) {
+	type objectIdentifier struct {
+		Key string
+	}
+	var req struct {
+		XMLName xml.Name           `xml:"Delete"`
+		Quiet   bool               `xml:"Quiet"`
+		Objects []objectIdentifier `xml:"Object"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+	type deleteResult struct {
+		XMLName xml.Name           `xml:"DeleteResult"`
+		Deleted []objectIdentifier `xml:"Deleted"`
+	}
+	var result deleteResult
+	for _, o := range req.Objects {
+		delete(b.objects, o.Key)
+		if !req.Quiet {
+			result.Deleted = append(result.Deleted, o)
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucketName, key string) {
+	s.nextID++
+	uploadID := fmt.Sprintf("upload-%d", s.nextID)
+	s.uploads[uploadID] = &upload{
+		bucket:      bucketName,
+		key:         key,
+		initiated:   time.Now().UTC(),
+		contentType: r.Header.Get("Content-Type"),
+		metadata:    requestMetadata(r),
+		parts:       make(map[int]*object),
+	}
+	type initiateMultipartUploadResult struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{Bucket: bucketName, Key: key, UploadId: uploadID})
+}
+
+func (s *Server) getUpload(w http.ResponseWriter, r *http.Request, bucketName, key, uploadID string) (*upload, bool) {
+	u, ok := s.uploads[uploadID]
+	if !ok || u.bucket != bucketName || u.key != key {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "the specified upload does not exist")
+		return nil, false
+	}
+	return u, true
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, bucketName, key string, q url.Values) {
+	u, ok := s.getUpload(w, r, bucketName, key, q.Get("uploadId"))
+	if !ok {
+		return
+	}
+	partNumber, err := strconv.Atoi(q.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "part number must be an integer between 1 and 10000")
+		return
+	}
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		s.uploadPartCopy(w, r, u, partNumber)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if !checkDigest(w, r, data) {
+		return
+	}
+	part := newObject(data, "", nil)
+	u.parts[partNumber] = part
+	w.Header().Set("ETag", part.etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+// uploadPartCopy fills a part with the x-amz-copy-source-range of an object, or all of it.
+func (s *Server) uploadPartCopy(w http.ResponseWriter, r *http.Request, u *upload, partNumber int) {
+	source := strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/")
+	if unescaped, err := url.PathUnescape(source); err == nil {
+		source = unescaped
+	}
+	srcBucketName, srcKey, _ := strings.Cut(source, "/")
+	srcBucket, ok := s.buckets[srcBucketName]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "the source bucket does not exist")
+		return
+	}
+	src, ok := srcBucket.objects[srcKey]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "the source key does not exist")
+		return
+	}
+	start, end := 0, len(src.data)-1
+	if rng := r.Header.Get("X-Amz-Copy-Source-Range"); rng != "" {
+		if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); err != nil || start < 0 || end < start || end >= len(src.data) {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid copy source range")
+			return
+		}
+	}
+	part := newObject(append([]byte(nil), src.data[start:end+1]...), "", nil)
+	u.parts[partNumber] = part
+
+	type copyPartResult struct {
+		XMLName      xml.Name `xml:"CopyPartResult"`
+		ETag         string
+		LastModified string
+	}
+	writeXML(w, http.StatusOK, copyPartResult{ETag: part.etag, LastModified: part.lastModified.Format(timeFormat)})
+}
+
+func (s *Server) listMultipartUploads(w http.ResponseWriter, bucketName string, q url.Values) {
+	type uploadEntry struct {
+		Key       string
+		UploadId  string
+		Initiated string
+	}
+	type listMultipartUploadsResult struct {
+		XMLName            xml.Name `xml:"ListMultipartUploadsResult"`
+		Bucket             string
+		Prefix             string
+		KeyMarker          string
+		UploadIdMarker     string
+		NextKeyMarker      string
+		NextUploadIdMarker string
+		MaxUploads         int
+		IsTruncated        bool
+		Uploads            []uploadEntry `xml:"Upload"`
+	}
+
+	prefix := q.Get("prefix")
+	keyMarker, uploadIDMarker := q.Get("key-marker"), q.Get("upload-id-marker")
+	maxUploads := 1000
+	if v, err := strconv.Atoi(q.Get("max-uploads")); err == nil && v > 0 && v < maxUploads {
+		maxUploads = v
+	}
+	var ids []string
+	for id, u := range s.uploads {
+		if u.bucket == bucketName && strings.HasPrefix(u.key, prefix) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		ui, uj := s.uploads[ids[i]], s.uploads[ids[j]]
+		if ui.key != uj.key {
+			return ui.key < uj.key
+		}
+		return ids[i] < ids[j]
+	})
+	result := listMultipartUploadsResult{Bucket: bucketName, Prefix: prefix, KeyMarker: keyMarker, UploadIdMarker: uploadIDMarker, MaxUploads: maxUploads}
+	for _, id := range ids {
+		u := s.uploads[id]
+		if keyMarker != "" && (u.key < keyMarker || (u.key == keyMarker && (uploadIDMarker == "" || id <= uploadIDMarker))) {
+			continue
+		}
+		if len(result.Uploads) == maxUploads {
+			result.IsTruncated = true
+			break
+		}
+		result.Uploads = append(result.Uploads, uploadEntry{Key: u.key, UploadId: id, Initiated: u.initiated.Format(timeFormat)})
+		result.NextKeyMarker, result.NextUploadIdMarker = u.key, id
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+func (s *Server) listParts(w http.ResponseWriter, r *http.Request, bucketName, key string, q url.Values) {
+	u, ok := s.getUpload(w, r, bucketName, key, q.Get("uploadId"))
+	if !ok {
+		return
+	}
+	type part struct {
+		PartNumber   int
+		LastModified string
+		ETag         string
+		Size         int64
+	}
+	type listPartsResult struct {
+		XMLName              xml.Name `xml:"ListPartsResult"`
+		Bucket               string
+		Key                  string
+		UploadId             string
+		PartNumberMarker     int
+		NextPartNumberMarker int
+		MaxParts             int
+		IsTruncated          bool
+		Parts                []part `xml:"Part"`
+	}
+
+	marker, _ := strconv.Atoi(q.Get("part-number-marker"))
+	maxParts := 1000
+	if v, err := strconv.Atoi(q.Get("max-parts")); err == nil && v > 0 && v < maxParts {
+		maxParts = v
+	}
+	var numbers []int
+	for n := range u.parts {
+		if n > marker {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	result := listPartsResult{Bucket: bucketName, Key: key, UploadId: q.Get("uploadId"), PartNumberMarker: marker, MaxParts: maxParts}
+	if len(numbers) > maxParts {
+		numbers = numbers[:maxParts]
+		result.IsTruncated = true
+	}
+	for _, n := range numbers {
+		p := u.parts[n]
+		result.Parts = append(result.Parts, part{PartNumber: n, LastModified: p.lastModified.Format(timeFormat), ETag: p.etag, Size: int64(len(p.data))})
+		result.NextPartNumberMarker = n
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucketName string, b *bucket, key, uploadID string) {
+	u, ok := s.getUpload(w, r, bucketName, key, uploadID)
+	if !ok {
+		return
+	}
+	var req struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Parts) == 0 {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", "the XML you provided was not well-formed")
+		return
+	}
+
+	var data []byte
+	lastPart := 0
+	for _, p := range req.Parts {
+		if p.PartNumber <= lastPart {
+			writeError(w, r, http.StatusBadRequest, "InvalidPartOrder", "the list of parts was not in ascending order")
+			return
+		}
+		lastPart = p.PartNumber
+		part, ok := u.parts[p.PartNumber]
+		if !ok || strings.Trim(part.etag, "\"") != strings.Trim(p.ETag, "\"") {
+			writeError(w, r, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d could not be found", p.PartNumber))
+			return
+		}
+		data = append(data, part.data...)
+	}
+	obj := newObject(data, u.contentType, u.metadata)
+	b.objects[key] = obj
+	delete(s.uploads, uploadID)
+
+	type completeMultipartUploadResult struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+	}
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		Location: s.URL + "/" + bucketName + "/" + key,
+		Bucket:   bucketName,
+		Key:      key,
+		ETag:     obj.etag,
+	})
+}
+
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, r *http.Request, bucketName, key, uploadID string) {
+	if _, ok := s.getUpload(w, r, bucketName, key, uploadID); !ok {
+		return
+	}
+	delete(s.uploads, uploadID)
+	w.WriteHeader(http.StatusNoContent)
+}